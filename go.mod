@@ -0,0 +1,3 @@
+module github.com/kumar-rajesh/system-design
+
+go 1.21
@@ -1,6 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -10,231 +20,8357 @@ type Aircraft struct {
 	TailNumber string
 	Model      string
 	TotalSeats int
+	Cabins     []CabinLayout
+	SeatLayout string
+	ExitRows   []int
 }
 
+// defaultSeatLayout is a single-aisle narrow body. Letters are seat columns
+// and spaces are aisles.
+const defaultSeatLayout = "ABC DEF"
+
 func NewAircraft(tailNumber, model string, totalSeats int) *Aircraft {
 	return &Aircraft{
 		TailNumber: tailNumber,
 		Model:      model,
 		TotalSeats: totalSeats,
+		Cabins:     []CabinLayout{{Class: Economy, Seats: totalSeats}},
+	}
+}
+
+// NewAircraftWithCabins builds an aircraft from cabins listed front to back.
+func NewAircraftWithCabins(tailNumber, model string, cabins ...CabinLayout) *Aircraft {
+	totalSeats := 0
+	for _, cabin := range cabins {
+		totalSeats += cabin.Seats
+	}
+	return &Aircraft{
+		TailNumber: tailNumber,
+		Model:      model,
+		TotalSeats: totalSeats,
+		Cabins:     cabins,
+	}
+}
+
+// cabinLayouts falls back to a single economy cabin for aircraft built
+// without an explicit configuration.
+// clone copies the aircraft and its layout slices.
+func (a *Aircraft) clone() *Aircraft {
+	if a == nil {
+		return nil
+	}
+	aircraft := *a
+	aircraft.Cabins = append([]CabinLayout(nil), a.Cabins...)
+	aircraft.ExitRows = append([]int(nil), a.ExitRows...)
+	return &aircraft
+}
+
+func (a *Aircraft) cabinLayouts() []CabinLayout {
+	if len(a.Cabins) == 0 {
+		return []CabinLayout{{Class: Economy, Seats: a.TotalSeats}}
+	}
+	return a.Cabins
+}
+
+// columnsFor returns the column layout for a cabin, falling back to the
+// aircraft-wide layout and then the default.
+func (a *Aircraft) columnsFor(cabin CabinLayout) string {
+	if cabin.Columns != "" {
+		return cabin.Columns
+	}
+	if a.SeatLayout != "" {
+		return a.SeatLayout
+	}
+	return defaultSeatLayout
+}
+
+func (a *Aircraft) SeatCount(class CabinClass) int {
+	count := 0
+	for _, cabin := range a.cabinLayouts() {
+		if cabin.Class == class {
+			count += cabin.Seats
+		}
+	}
+	return count
+}
+
+// File: aircraft_change.go
+// ReaccommodationReport describes what an equipment swap did to the flight's
+// bookings. Bumped bookings have lost their seat (SeatNumber is 0) and need
+// rebooking or compensation.
+type ReaccommodationReport struct {
+	FlightNumber string
+	Kept         []string
+	// Reseated maps booking IDs whose seat no longer exists to the seat they
+	// were moved to in the same cabin.
+	Reseated map[string]string
+	Bumped   []string
+}
+
+// ChangeAircraft swaps the flight onto another aircraft and rebuilds the
+// seat map from its layout. Bookings keep their seat label where it still
+// exists in the same cabin and are otherwise moved to a free seat in their
+// cabin. When some booking cannot be seated the swap fails, unless force is
+// set and those bookings are bumped instead. Blocked seats stay blocked
+// where the label still exists. Outstanding holds and seat locks on the
+// flight are dropped.
+func (ams *AirlineManagementSystem) ChangeAircraft(flightNumber, newTailNumber string, force bool) (*ReaccommodationReport, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	aircraft, err := ams.GetAircraft(newTailNumber)
+	if err != nil {
+		return nil, err
+	}
+	if err := ams.checkBookable(flight); err != nil {
+		return nil, err
+	}
+	ams.mu.RLock()
+	err = ams.checkMaintenanceLocked(newTailNumber, flight.departureTime(), flight.Arrival)
+	if err == nil {
+		err = ams.checkAircraftConflictsLocked(flight, aircraft)
+	}
+	ams.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	ams.dropHolds(flight)
+	report, err := ams.bookingManager.reaccommodate(flight, aircraft, force)
+	if err != nil {
+		return nil, err
+	}
+	for bookingID := range report.Reseated {
+		ams.refreshBoardingGroup(bookingID)
+	}
+	return report, nil
+}
+
+func (ams *AirlineManagementSystem) dropHolds(flight *Flight) {
+	ams.holdsMu.Lock()
+	dropped := make([]*SeatHold, 0)
+	for id, hold := range ams.holds {
+		if hold.Flight == flight {
+			dropped = append(dropped, hold)
+			delete(ams.holds, id)
+		}
+	}
+	ams.holdsMu.Unlock()
+	for _, hold := range dropped {
+		flight.ReleaseSeat(hold.SeatNumber)
+	}
+}
+
+func (bm *BookingManager) reaccommodate(flight *Flight, aircraft *Aircraft, force bool) (*ReaccommodationReport, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	flight.mu.Lock()
+	defer flight.mu.Unlock()
+	seats := buildSeats(aircraft)
+	byLabel := make(map[string]*Seat, len(seats))
+	for _, seat := range seats {
+		byLabel[seat.Label()] = seat
+	}
+	for _, old := range flight.Seats {
+		if seat, ok := byLabel[old.Label()]; ok {
+			seat.Surcharge = old.Surcharge
+			seat.Blocked = old.Blocked
+		}
+	}
+	active := make([]*Booking, 0)
+	for _, booking := range bm.byFlight[flight.FlightNumber] {
+		if booking.Flight == flight && booking.Status != BookingCancelled && booking.SeatNumber != 0 {
+			active = append(active, booking)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		if !active[i].BookingTime.Equal(active[j].BookingTime) {
+			return active[i].BookingTime.Before(active[j].BookingTime)
+		}
+		return active[i].SeatNumber < active[j].SeatNumber
+	})
+	report := &ReaccommodationReport{
+		FlightNumber: flight.FlightNumber,
+		Kept:         make([]string, 0),
+		Reseated:     make(map[string]string),
+		Bumped:       make([]string, 0),
+	}
+	assigned := make(map[*Booking]*Seat, len(active))
+	displaced := make([]*Booking, 0)
+	for _, booking := range active {
+		old := flight.Seats[booking.SeatNumber-1]
+		if seat, ok := byLabel[old.Label()]; ok && seat.Class == old.Class {
+			seat.IsBooked = true
+			assigned[booking] = seat
+			report.Kept = append(report.Kept, booking.BookingID)
+		} else {
+			displaced = append(displaced, booking)
+		}
+	}
+	for _, booking := range displaced {
+		class := flight.Seats[booking.SeatNumber-1].Class
+		seat := freeSeatIn(seats, class, booking.Passenger == nil || booking.Passenger.ExitRowEligible())
+		if seat == nil {
+			report.Bumped = append(report.Bumped, booking.BookingID)
+			continue
+		}
+		seat.IsBooked = true
+		assigned[booking] = seat
+		report.Reseated[booking.BookingID] = seat.Label()
+	}
+	if len(report.Bumped) > 0 && !force {
+		return nil, fmt.Errorf("%w: %d of %d bookings", ErrAircraftTooSmall, len(report.Bumped), len(active))
+	}
+	for _, booking := range active {
+		old := flight.Seats[booking.SeatNumber-1]
+		seat, ok := assigned[booking]
+		if !ok {
+			bm.recordLocked(booking, ActionBumped, fmt.Sprintf("seat %s removed by aircraft change", old.Label()))
+			booking.SeatNumber = 0
+			bm.supersedeBoardingPassLocked(booking, "aircraft change")
+			continue
+		}
+		if seat.SeatNumber != booking.SeatNumber || seat.Label() != old.Label() {
+			bm.recordLocked(booking, ActionSeatChanged, fmt.Sprintf("seat %s -> %s (aircraft change)", old.Label(), seat.Label()))
+			bm.supersedeBoardingPassLocked(booking, "aircraft change")
+		}
+		booking.SeatNumber = seat.SeatNumber
+	}
+	flight.Aircraft = aircraft
+	flight.Seats = seats
+	flight.locks = make(map[int]seatLock)
+	flight.version++
+	return report, nil
+}
+
+// freeSeatIn returns the first free, unblocked seat in the cabin, leaving
+// exit rows to passengers who may sit there.
+func freeSeatIn(seats []*Seat, class CabinClass, allowExitRow bool) *Seat {
+	for _, seat := range seats {
+		if seat.Class == class && !seat.IsBooked && !seat.Blocked && (allowExitRow || !seat.ExitRow) {
+			return seat
+		}
 	}
+	return nil
+}
+
+// File: aircraft_conflict.go
+const (
+	// minTurnaround is the shortest time an aircraft needs on the ground
+	// between flights.
+	minTurnaround = 30 * time.Minute
+	// minFerryTime is the shortest gap in which an aircraft is assumed to be
+	// repositioned between airports by a flight that is not modelled.
+	minFerryTime = 4 * time.Hour
+)
+
+// checkAircraftConflictsLocked refuses to fly flight with aircraft when the
+// aircraft is still in the air or turning around from another flight, or
+// cannot get from the previous flight's arrival airport in time, or to the
+// next flight's departure airport. The flight itself is skipped, so an
+// aircraft change can be checked before it is made.
+func (ams *AirlineManagementSystem) checkAircraftConflictsLocked(flight *Flight, aircraft *Aircraft) error {
+	start, end := flight.schedule()
+	var prev, next *Flight
+	var prevArrival, nextDeparture time.Time
+	for _, other := range ams.flights {
+		if other == flight || other.Aircraft.TailNumber != aircraft.TailNumber || other.CurrentStatus() == FlightCancelled {
+			continue
+		}
+		departure, arrival := other.schedule()
+		if departure.Before(end.Add(minTurnaround)) && start.Before(arrival.Add(minTurnaround)) {
+			return fmt.Errorf("%w: %s overlaps %s (%s-%s)", ErrAircraftConflict, aircraft.TailNumber,
+				other.FlightNumber, departure.Format(time.RFC3339), arrival.Format(time.RFC3339))
+		}
+		if !arrival.After(start) && (prev == nil || arrival.After(prevArrival)) {
+			prev, prevArrival = other, arrival
+		}
+		if !departure.Before(end) && (next == nil || departure.Before(nextDeparture)) {
+			next, nextDeparture = other, departure
+		}
+	}
+	if prev != nil && prev.Destination != flight.Source && start.Sub(prevArrival) < minFerryTime {
+		return fmt.Errorf("%w: %s lands at %s on %s but %s departs %s", ErrAircraftConflict, aircraft.TailNumber,
+			prev.Destination, prev.FlightNumber, flight.FlightNumber, flight.Source)
+	}
+	if next != nil && next.Source != flight.Destination && nextDeparture.Sub(end) < minFerryTime {
+		return fmt.Errorf("%w: %s lands at %s on %s but %s departs %s", ErrAircraftConflict, aircraft.TailNumber,
+			flight.Destination, flight.FlightNumber, next.FlightNumber, next.Source)
+	}
+	return nil
+}
+
+// File: aircraft_model.go
+// AircraftModel is a reusable seat configuration, e.g. an airline's standard
+// A320neo layout.
+type AircraftModel struct {
+	Cabins     []CabinLayout
+	SeatLayout string
+	ExitRows   []int
+}
+
+type AircraftOption func(*Aircraft)
+
+// WithCabins overrides the model's cabins, e.g. for a dense configuration.
+func WithCabins(cabins ...CabinLayout) AircraftOption {
+	return func(a *Aircraft) {
+		a.Cabins = cabins
+	}
+}
+
+func WithExitRows(rows ...int) AircraftOption {
+	return func(a *Aircraft) {
+		a.ExitRows = rows
+	}
+}
+
+// RegisterModel adds a model to the catalog, replacing any model with the
+// same name.
+func (ams *AirlineManagementSystem) RegisterModel(name string, model AircraftModel) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrModelNameRequired
+	}
+	if err := validateCabins(model.Cabins); err != nil {
+		return err
+	}
+	model.Cabins = append([]CabinLayout(nil), model.Cabins...)
+	model.ExitRows = append([]int(nil), model.ExitRows...)
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	ams.models[name] = model
+	return nil
+}
+
+// NewAircraftFromModel builds an aircraft with the layout of a registered
+// model. The aircraft is not added to the fleet.
+func (ams *AirlineManagementSystem) NewAircraftFromModel(tailNumber, modelName string, opts ...AircraftOption) (*Aircraft, error) {
+	ams.mu.RLock()
+	model, ok := ams.models[strings.TrimSpace(modelName)]
+	ams.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAircraftModel, modelName)
+	}
+	aircraft := &Aircraft{
+		TailNumber: tailNumber,
+		Model:      strings.TrimSpace(modelName),
+		Cabins:     append([]CabinLayout(nil), model.Cabins...),
+		SeatLayout: model.SeatLayout,
+		ExitRows:   append([]int(nil), model.ExitRows...),
+	}
+	for _, opt := range opts {
+		opt(aircraft)
+	}
+	if err := validateCabins(aircraft.Cabins); err != nil {
+		return nil, err
+	}
+	for _, cabin := range aircraft.Cabins {
+		aircraft.TotalSeats += cabin.Seats
+	}
+	return aircraft, nil
+}
+
+func validateCabins(cabins []CabinLayout) error {
+	if len(cabins) == 0 {
+		return ErrInvalidSeatCount
+	}
+	for _, cabin := range cabins {
+		if cabin.Seats <= 0 {
+			return ErrInvalidSeatCount
+		}
+	}
+	return nil
 }
 
 // File: airline_management_system.go
 type AirlineManagementSystem struct {
-	flights          []*Flight
+	flights []*Flight
+	// byNumber indexes flights by number. A number recurs on every day the
+	// flight operates.
+	byNumber map[string][]*Flight
+	// codeshares maps a normalized marketing number to the flight number
+	// that operates it.
+	codeshares       map[string]codeshare
 	aircrafts        []*Aircraft
 	flightSearch     *FlightSearch
 	bookingManager   *BookingManager
 	paymentProcessor *PaymentProcessor
+	passengers       *PassengerRegistry
+	loyalty          *FrequentFlyerProgram
+	boardingPolicy   BoardingPolicy
+	baggageAllowance BaggageAllowance
+	holds            map[string]*SeatHold
+	holdSequence     int
+	maintenance      map[string][]MaintenanceWindow
+	models           map[string]AircraftModel
+	holdsMu          sync.Mutex
+	baggageMu        sync.Mutex
+	refundRules      *RefundRules
+	now              func() time.Time
+	clockMu          sync.RWMutex
 	mu               sync.RWMutex
 }
 
 func NewAirlineManagementSystem() *AirlineManagementSystem {
+	return NewAirlineManagementSystemWith(GetBookingManager(), GetPaymentProcessor())
+}
+
+func NewAirlineManagementSystemWith(bookingManager *BookingManager, paymentProcessor *PaymentProcessor) *AirlineManagementSystem {
 	system := &AirlineManagementSystem{
 		flights:          make([]*Flight, 0),
+		byNumber:         make(map[string][]*Flight),
+		codeshares:       make(map[string]codeshare),
 		aircrafts:        make([]*Aircraft, 0),
-		bookingManager:   GetBookingManager(),
-		paymentProcessor: GetPaymentProcessor(),
+		bookingManager:   bookingManager,
+		paymentProcessor: paymentProcessor,
+		holds:            make(map[string]*SeatHold),
+		maintenance:      make(map[string][]MaintenanceWindow),
+		models:           make(map[string]AircraftModel),
+		boardingPolicy:   DefaultBoardingPolicy(),
+		baggageAllowance: DefaultBaggageAllowance(),
+		now:              time.Now,
 	}
-	system.flightSearch = NewFlightSearch(system.flights)
+	system.flightSearch = NewFlightSearch(system.clock)
+	system.passengers = NewPassengerRegistry()
+	system.passengers.now = system.clock
+	system.passengers.bookings = bookingManager
+	bookingManager.now = system.clock
+	paymentProcessor.now = system.clock
+	system.loyalty = NewFrequentFlyerProgram()
+	system.loyalty.now = system.clock
 	return system
 }
 
-func (ams *AirlineManagementSystem) AddFlight(flight *Flight) {
+// SetClock replaces the time source used for departure checks and expiry.
+func (ams *AirlineManagementSystem) SetClock(now func() time.Time) {
+	ams.clockMu.Lock()
+	defer ams.clockMu.Unlock()
+	ams.now = now
+}
+
+func (ams *AirlineManagementSystem) AddFlight(flight *Flight) error {
+	return ams.addFlight(flight, true)
+}
+
+// AddFerryFlight adds a flight without checking its aircraft against the
+// aircraft's other flights, for deliberately modelling positioning moves.
+func (ams *AirlineManagementSystem) AddFerryFlight(flight *Flight) error {
+	return ams.addFlight(flight, false)
+}
+
+func (ams *AirlineManagementSystem) addFlight(flight *Flight, checkConflicts bool) error {
+	for _, code := range []string{flight.Source, flight.Destination} {
+		if _, err := ParseAirportCode(code); err != nil {
+			return err
+		}
+	}
 	ams.mu.Lock()
 	defer ams.mu.Unlock()
+	if ams.hasFlightLocked(flight.FlightNumber, ams.flightSearch.localDeparture(flight)) {
+		return ErrDuplicateFlight
+	}
+	if _, ok := ams.codeshares[normalizeFlightNumber(flight.FlightNumber)]; ok {
+		return fmt.Errorf("%w: %s", ErrCodeshareConflict, flight.FlightNumber)
+	}
+	if err := ams.checkMaintenanceLocked(flight.Aircraft.TailNumber, flight.Departure, flight.Arrival); err != nil {
+		return err
+	}
+	if checkConflicts {
+		if err := ams.checkAircraftConflictsLocked(flight, flight.Aircraft); err != nil {
+			return err
+		}
+	}
+	flight.mu.Lock()
+	flight.now = ams.clock
+	flight.Codeshares = ams.codesharesForLocked(flight.FlightNumber)
+	flight.mu.Unlock()
 	ams.flights = append(ams.flights, flight)
+	ams.byNumber[flight.FlightNumber] = append(ams.byNumber[flight.FlightNumber], flight)
+	ams.flightSearch.addFlight(flight)
+	return nil
 }
 
-func (ams *AirlineManagementSystem) AddAircraft(aircraft *Aircraft) {
-	ams.mu.Lock()
-	defer ams.mu.Unlock()
-	ams.aircrafts = append(ams.aircrafts, aircraft)
+// RemoveFlight takes the flight out of the system and search. A flight with
+// active bookings is refused unless force is set, in which case they are
+// cancelled and refunded in full first.
+func (ams *AirlineManagementSystem) RemoveFlight(flightNumber string, force bool) error {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return err
+	}
+	active := ams.bookingManager.activeBookingsOn(flight)
+	if len(active) > 0 && !force {
+		return fmt.Errorf("%w: %s has %d", ErrFlightHasBookings, flightNumber, len(active))
+	}
+	errs := make([]error, 0)
+	for _, booking := range active {
+		if err := ams.scrubBooking(booking); err != nil {
+			errs = append(errs, fmt.Errorf("booking %s: %w", booking.BookingID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	ams.dropFlight(flight)
+	return nil
 }
 
-func (ams *AirlineManagementSystem) SearchFlights(source, destination string, date time.Time) []*Flight {
-	return ams.flightSearch.SearchFlights(source, destination, date)
+// hasFlightLocked reports whether a flight with the number already departs
+// on the same local day as departure.
+func (ams *AirlineManagementSystem) hasFlightLocked(flightNumber string, departure time.Time) bool {
+	return ams.flightOnLocked(flightNumber, departure) != nil
 }
 
-// File: booking.go
-type Booking struct {
-	BookingID   string
-	Flight      *Flight
-	Passenger   *Passenger
-	SeatNumber  int
-	BookingTime time.Time
+func (ams *AirlineManagementSystem) flightOnLocked(flightNumber string, date time.Time) *Flight {
+	for _, flight := range ams.byNumber[flightNumber] {
+		if sameDay(ams.flightSearch.localDeparture(flight), date) {
+			return flight
+		}
+	}
+	return nil
 }
 
-func NewBooking(bookingID string, flight *Flight, passenger *Passenger, seatNumber int) *Booking {
-	return &Booking{
-		BookingID:   bookingID,
-		Flight:      flight,
-		Passenger:   passenger,
-		SeatNumber:  seatNumber,
-		BookingTime: time.Now(),
+func (ams *AirlineManagementSystem) dropFlight(flight *Flight) {
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	for i, existing := range ams.flights {
+		if existing == flight {
+			ams.flights = append(ams.flights[:i:i], ams.flights[i+1:]...)
+			break
+		}
 	}
+	numbered := ams.byNumber[flight.FlightNumber]
+	for i, existing := range numbered {
+		if existing == flight {
+			ams.byNumber[flight.FlightNumber] = append(numbered[:i:i], numbered[i+1:]...)
+			break
+		}
+	}
+	if len(ams.byNumber[flight.FlightNumber]) == 0 {
+		delete(ams.byNumber, flight.FlightNumber)
+	}
+	ams.flightSearch.removeFlight(flight)
 }
 
-// File: booking_manager.go
-type BookingManager struct {
-	bookings map[string]*Booking
-	mu       sync.RWMutex
+func (ams *AirlineManagementSystem) AddAircraft(aircraft *Aircraft) error {
+	if aircraft.TotalSeats <= 0 {
+		return ErrInvalidSeatCount
+	}
+	for _, cabin := range aircraft.Cabins {
+		if cabin.Seats <= 0 {
+			return ErrInvalidSeatCount
+		}
+	}
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	for _, existing := range ams.aircrafts {
+		if existing.TailNumber == aircraft.TailNumber {
+			return ErrDuplicateAircraft
+		}
+	}
+	ams.aircrafts = append(ams.aircrafts, aircraft)
+	return nil
 }
 
-var (
-	bookingManagerInstance *BookingManager
-	onceBookingManager     sync.Once
-)
+// RemoveAircraft retires an aircraft. It is refused while the aircraft is
+// still assigned to a flight that has not departed or been cancelled.
+func (ams *AirlineManagementSystem) RemoveAircraft(tailNumber string) error {
+	now := ams.clock()
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	index := -1
+	for i, aircraft := range ams.aircrafts {
+		if aircraft.TailNumber == tailNumber {
+			index = i
+		}
+	}
+	if index < 0 {
+		return ErrAircraftNotFound
+	}
+	for _, flight := range ams.flights {
+		if flight.Aircraft.TailNumber != tailNumber {
+			continue
+		}
+		if flight.departureTime().After(now) && flight.CurrentStatus() != FlightCancelled {
+			return fmt.Errorf("%w: %s on %s", ErrAircraftInUse, tailNumber, flight.FlightNumber)
+		}
+	}
+	ams.aircrafts = append(ams.aircrafts[:index:index], ams.aircrafts[index+1:]...)
+	return nil
+}
 
-func GetBookingManager() *BookingManager {
-	onceBookingManager.Do(func() {
-		bookingManagerInstance = &BookingManager{
-			bookings: make(map[string]*Booking),
+func (ams *AirlineManagementSystem) GetAircraft(tailNumber string) (*Aircraft, error) {
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	for _, aircraft := range ams.aircrafts {
+		if aircraft.TailNumber == tailNumber {
+			return aircraft, nil
 		}
-	})
-	return bookingManagerInstance
+	}
+	return nil, ErrAircraftNotFound
 }
 
-func (bm *BookingManager) AddBooking(booking *Booking) {
-	bm.mu.Lock()
-	defer bm.mu.Unlock()
-	bm.bookings[booking.BookingID] = booking
+func (ams *AirlineManagementSystem) clock() time.Time {
+	ams.clockMu.RLock()
+	defer ams.clockMu.RUnlock()
+	return ams.now()
 }
 
-func (bm *BookingManager) GetBooking(bookingID string) *Booking {
-	bm.mu.RLock()
-	defer bm.mu.RUnlock()
-	return bm.bookings[bookingID]
+// GetFlight returns a copy of the flight with the number that departs on
+// date, local to its departure airport.
+func (ams *AirlineManagementSystem) GetFlight(flightNumber string, date time.Time) (*Flight, error) {
+	flight, err := ams.findFlightOn(flightNumber, date)
+	if err != nil {
+		return nil, err
+	}
+	return flight.clone(), nil
 }
 
-// File: flight.go
-type Flight struct {
-	FlightNumber string
-	Source       string
-	Destination  string
-	Departure    time.Time
-	Arrival      time.Time
-	Aircraft     *Aircraft
-	Seats        []*Seat
+func (ams *AirlineManagementSystem) findFlightOn(flightNumber string, date time.Time) (*Flight, error) {
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	if flight := ams.flightOnLocked(ams.resolveLocked(flightNumber), date); flight != nil {
+		return flight, nil
+	}
+	return nil, fmt.Errorf("%w: %s on %s", ErrFlightNotFound, flightNumber, date.Format(time.DateOnly))
 }
 
-func NewFlight(flightNumber, source, destination string, departure, arrival time.Time, aircraft *Aircraft) *Flight {
-	seats := make([]*Seat, aircraft.TotalSeats)
-	for i := 0; i < aircraft.TotalSeats; i++ {
-		seats[i] = &Seat{
-			SeatNumber: i + 1,
-			IsBooked:   false,
+// findFlight resolves a bare flight number. When the number operates on
+// several days it is the next departure, or the latest one once they have
+// all left.
+func (ams *AirlineManagementSystem) findFlight(flightNumber string) (*Flight, error) {
+	now := ams.clock()
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	var next, last *Flight
+	for _, flight := range ams.byNumber[ams.resolveLocked(flightNumber)] {
+		departure := flight.departureTime()
+		if departure.After(now) && (next == nil || departure.Before(next.departureTime())) {
+			next = flight
+		}
+		if last == nil || departure.After(last.departureTime()) {
+			last = flight
 		}
 	}
-	return &Flight{
-		FlightNumber: flightNumber,
-		Source:       source,
-		Destination:  destination,
-		Departure:    departure,
-		Arrival:      arrival,
-		Aircraft:     aircraft,
-		Seats:        seats,
+	switch {
+	case next != nil:
+		return next, nil
+	case last != nil:
+		return last, nil
 	}
+	return nil, ErrFlightNotFound
 }
 
-func (f *Flight) BookSeat(seatNumber int) bool {
-	if seatNumber < 1 || seatNumber > len(f.Seats) {
-		return false
-	}
-	if f.Seats[seatNumber-1].IsBooked {
-		return false
-	}
-	f.Seats[seatNumber-1].IsBooked = true
-	return true
+func (ams *AirlineManagementSystem) SearchFlights(source, destination string, date time.Time) []*Flight {
+	return ams.flightSearch.SearchFlights(source, destination, date)
 }
 
-// File: flight_search.go
-type FlightSearch struct {
-	flights []*Flight
+func (ams *AirlineManagementSystem) Search(criteria SearchCriteria) (*SearchResult, error) {
+	return ams.flightSearch.Search(criteria)
 }
 
-func NewFlightSearch(flights []*Flight) *FlightSearch {
-	return &FlightSearch{
-		flights: flights,
-	}
+func (ams *AirlineManagementSystem) SearchFlightsFlexible(source, destination string, center time.Time, days int) map[string][]*Flight {
+	return ams.flightSearch.SearchFlightsFlexible(source, destination, center, days)
 }
 
-func (fs *FlightSearch) SearchFlights(source, destination string, date time.Time) []*Flight {
-	results := make([]*Flight, 0)
-	for _, flight := range fs.flights {
-		if flight.Source == source && flight.Destination == destination &&
-			flight.Departure.Year() == date.Year() &&
-			flight.Departure.Month() == date.Month() &&
-			flight.Departure.Day() == date.Day() {
-			results = append(results, flight)
-		}
-	}
-	return results
+func (ams *AirlineManagementSystem) SearchByFlightNumber(query string) []*Flight {
+	return ams.flightSearch.SearchByFlightNumber(query)
 }
 
-// File: passenger.go
-type Passenger struct {
-	PassengerID string
-	Name        string
-	Email       string
-	Phone       string
+func (ams *AirlineManagementSystem) SetAirportTimezone(code string, loc *time.Location) error {
+	return ams.flightSearch.SetAirportTimezone(code, loc)
 }
 
-func NewPassenger(passengerID, name, email, phone string) *Passenger {
-	return &Passenger{
-		PassengerID: passengerID,
-		Name:        name,
-		Email:       email,
-		Phone:       phone,
-	}
+func (ams *AirlineManagementSystem) RegisterAirportGroup(code string, airports []string) error {
+	return ams.flightSearch.RegisterAirportGroup(code, airports)
 }
 
-// File: payment.go
-type Payment struct {
-	PaymentID string
-	Amount    float64
-	Method    string
-	Status    string
+func (ams *AirlineManagementSystem) SearchAvailableFlights(source, destination string, date time.Time) []*Flight {
+	return ams.flightSearch.SearchAvailableFlights(source, destination, date)
 }
 
-func NewPayment(paymentID string, amount float64, method, status string) *Payment {
-	return &Payment{
-		PaymentID: paymentID,
-		Amount:    amount,
-		Method:    method,
-		Status:    status,
+func (ams *AirlineManagementSystem) SearchFlightsInClass(source, destination string, date time.Time, class CabinClass) []*Flight {
+	return ams.flightSearch.SearchFlightsInClass(source, destination, date, class)
+}
+
+// File: airport_code.go
+// ParseAirportCode normalizes an IATA airport code to upper case and rejects
+// anything that is not exactly three letters.
+func ParseAirportCode(code string) (string, error) {
+	normalized := normalizeAirportCode(code)
+	if len(normalized) != 3 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAirportCode, code)
+	}
+	for _, r := range normalized {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidAirportCode, code)
+		}
 	}
+	return normalized, nil
 }
 
-// File: payment_processor.go
-type PaymentProcessor struct {
-	payments map[string]*Payment
-	mu       sync.RWMutex
+func normalizeAirportCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
 }
 
-var (
-	paymentProcessorInstance *PaymentProcessor
-	oncePaymentProcessor     sync.Once
+// File: baggage.go
+// maxBaggagePieces caps the bags, checked and cabin together, on one
+// booking.
+const maxBaggagePieces = 4
+
+type BaggageType int
+
+const (
+	CheckedBag BaggageType = iota
+	CabinBag
 )
 
-func GetPaymentProcessor() *PaymentProcessor {
-	oncePaymentProcessor.Do(func() {
-		paymentProcessorInstance = &PaymentProcessor{
-			payments: make(map[string]*Payment),
-		}
-	})
-	return paymentProcessorInstance
+func (t BaggageType) String() string {
+	switch t {
+	case CheckedBag:
+		return "Checked"
+	case CabinBag:
+		return "Cabin"
+	}
+	return fmt.Sprintf("BaggageType(%d)", int(t))
 }
 
-func (pp *PaymentProcessor) ProcessPayment(payment *Payment) {
-	pp.mu.Lock()
-	defer pp.mu.Unlock()
-	pp.payments[payment.PaymentID] = payment
+// Baggage is one bag on a booking. Tag and BookingID are filled in when the
+// bag is added, along with the excess charged if it went over the allowance.
+type Baggage struct {
+	Tag             string
+	BookingID       string
+	WeightKg        float64
+	Type            BaggageType
+	ExcessKg        float64
+	ExcessPaymentID string
+	Status          BaggageStatus
+	History         []BaggageEvent
 }
 
-// File: seat.go
-type Seat struct {
+// BaggageAllowance sets the free checked weight per cabin and the rate for
+// anything over it. Frequent flyers get TierBonusKg on top. Cabin bags do
+// not count against the allowance.
+type BaggageAllowance struct {
+	FreeKg      map[CabinClass]float64
+	TierBonusKg map[FrequentFlyerTier]float64
+	// ExcessPerKg is charged for every kilogram, or part of one, over the
+	// allowance.
+	ExcessPerKg Money
+}
+
+func DefaultBaggageAllowance() BaggageAllowance {
+	return BaggageAllowance{
+		FreeKg: map[CabinClass]float64{
+			Economy:        15,
+			PremiumEconomy: 20,
+			Business:       30,
+			First:          40,
+		},
+		TierBonusKg: map[FrequentFlyerTier]float64{
+			TierGold:     10,
+			TierPlatinum: 10,
+		},
+		ExcessPerKg: NewMoney(50000, "INR"),
+	}
+}
+
+func (a BaggageAllowance) Validate() error {
+	if a.ExcessPerKg.Amount <= 0 || a.ExcessPerKg.Currency == "" {
+		return fmt.Errorf("%w: excess rate %s", ErrInvalidBaggageAllowance, a.ExcessPerKg)
+	}
+	for class, kg := range a.FreeKg {
+		if kg < 0 {
+			return fmt.Errorf("%w: %s allowance %.1f kg", ErrInvalidBaggageAllowance, class, kg)
+		}
+	}
+	for tier, kg := range a.TierBonusKg {
+		if kg < 0 {
+			return fmt.Errorf("%w: %s bonus %.1f kg", ErrInvalidBaggageAllowance, tier, kg)
+		}
+	}
+	return nil
+}
+
+// SetBaggageAllowance applies to bags added from now on.
+func (ams *AirlineManagementSystem) SetBaggageAllowance(allowance BaggageAllowance) error {
+	if err := allowance.Validate(); err != nil {
+		return err
+	}
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	ams.baggageAllowance = allowance
+	return nil
+}
+
+func (b Baggage) Validate() error {
+	if b.WeightKg <= 0 {
+		return fmt.Errorf("%w: weight must be positive", ErrInvalidBaggage)
+	}
+	if b.Type != CheckedBag && b.Type != CabinBag {
+		return fmt.Errorf("%w: %s", ErrInvalidBaggage, b.Type)
+	}
+	return nil
+}
+
+// AddBaggage adds a bag to the booking and returns its tag. Bags are
+// accepted until check-in closes. A checked bag that takes the booking over
+// its allowance is only accepted once the excess has been paid for.
+func (ams *AirlineManagementSystem) AddBaggage(bookingID string, bag Baggage) (string, error) {
+	if err := bag.Validate(); err != nil {
+		return "", err
+	}
+	bag.ExcessKg, bag.ExcessPaymentID = 0, ""
+	booking, err := ams.bookingManager.GetBooking(bookingID)
+	if err != nil {
+		return "", err
+	}
+	flight := booking.Flight
+	_, closes := flight.checkInWindow()
+	if ams.clock().After(flight.Departure.Add(-closes)) || flight.isCheckInClosed() {
+		return "", ErrCheckInClosed
+	}
+	// Bags are added one at a time so the allowance left is not spent twice.
+	ams.baggageMu.Lock()
+	defer ams.baggageMu.Unlock()
+	checkedKg, pieces := ams.bookingManager.baggageTotals(booking)
+	if pieces >= maxBaggagePieces {
+		return "", fmt.Errorf("%w: %d pieces", ErrBaggageLimit, maxBaggagePieces)
+	}
+	var payment *Payment
+	if bag.Type == CheckedBag {
+		if excess := bag.WeightKg - math.Max(ams.freeBaggageKg(booking)-checkedKg, 0); excess > 0 {
+			if payment, err = ams.chargeExcessBaggage(booking, excess); err != nil {
+				return "", err
+			}
+			bag.ExcessKg, bag.ExcessPaymentID = excess, payment.PaymentID
+		}
+	}
+	tag, err := ams.bookingManager.addBaggage(bookingID, bag, ams.clock())
+	if err != nil && payment != nil {
+		return "", errors.Join(err, ams.paymentProcessor.Refund(payment.PaymentID))
+	}
+	return tag, err
+}
+
+// freeBaggageKg is the checked weight included with the booking's cabin,
+// plus any frequent flyer bonus. A booking without a seat gets the Economy
+// allowance.
+func (ams *AirlineManagementSystem) freeBaggageKg(booking *Booking) float64 {
+	ams.mu.RLock()
+	allowance := ams.baggageAllowance
+	ams.mu.RUnlock()
+	class, err := booking.Flight.seatClass(booking.SeatNumber)
+	if err != nil {
+		class = Economy
+	}
+	free := allowance.FreeKg[class]
+	if booking.Passenger != nil {
+		if account, err := ams.GetAccount(booking.Passenger.PassengerID); err == nil {
+			free += allowance.TierBonusKg[account.Tier]
+		}
+	}
+	return free
+}
+
+// chargeExcessBaggage charges whole kilograms of excess, rounding up, with
+// the method used for the booking.
+func (ams *AirlineManagementSystem) chargeExcessBaggage(booking *Booking, excessKg float64) (*Payment, error) {
+	ams.mu.RLock()
+	rate := ams.baggageAllowance.ExcessPerKg
+	ams.mu.RUnlock()
+	amount := rate.Multiply(math.Ceil(excessKg))
+	method := defaultPaymentMethod()
+	if booking.Payment != nil {
+		method = booking.Payment.Method
+	}
+	payment := NewPayment(ams.paymentProcessor.nextPaymentID(), amount, method, PaymentPending)
+	payment.BookingID = booking.BookingID
+	if booking.Passenger != nil {
+		payment.PassengerID = booking.Passenger.PassengerID
+	}
+	payment.LineItems = []LineItem{{Kind: LineBaggageFee, Amount: amount}}
+	if err := ams.paymentProcessor.ProcessPayment(payment); err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// baggageTotals returns the checked weight and the number of pieces already
+// on the booking.
+func (bm *BookingManager) baggageTotals(booking *Booking) (float64, int) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	checkedKg := 0.0
+	for _, bag := range booking.Baggage {
+		if bag.Type == CheckedBag {
+			checkedKg += bag.WeightKg
+		}
+	}
+	return checkedKg, len(booking.Baggage)
+}
+
+// GetBaggage returns copies of the bags on a booking in the order they were
+// added.
+func (ams *AirlineManagementSystem) GetBaggage(bookingID string) ([]Baggage, error) {
+	bm := ams.bookingManager
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return nil, ErrBookingNotFound
+	}
+	bags := make([]Baggage, len(booking.Baggage))
+	for i, bag := range booking.Baggage {
+		bags[i] = *bag
+		bags[i].History = append([]BaggageEvent(nil), bag.History...)
+	}
+	return bags, nil
+}
+
+func (bm *BookingManager) addBaggage(bookingID string, bag Baggage, now time.Time) (string, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return "", ErrBookingNotFound
+	}
+	switch booking.Status {
+	case BookingCancelled:
+		return "", ErrBookingAlreadyCancelled
+	case BookingCompleted, BookingNoShow:
+		return "", ErrCheckInClosed
+	}
+	if len(booking.Baggage) >= maxBaggagePieces {
+		return "", fmt.Errorf("%w: %d pieces", ErrBaggageLimit, maxBaggagePieces)
+	}
+	bag.Tag = bm.newBagTagLocked()
+	bag.BookingID = booking.BookingID
+	bag.Status = BaggageCheckedIn
+	bag.History = []BaggageEvent{{Status: BaggageCheckedIn, At: now}}
+	bm.bags[bag.Tag] = &bag
+	booking.Baggage = append(booking.Baggage, &bag)
+	bm.recordLocked(booking, ActionBaggageAdded, fmt.Sprintf("%s %s bag %.1f kg", bag.Tag, bag.Type, bag.WeightKg))
+	return bag.Tag, nil
+}
+
+func (bm *BookingManager) newBagTagLocked() string {
+	bm.bagSequence++
+	return fmt.Sprintf("BAG%06d", bm.bagSequence)
+}
+
+// File: baggage_tracking.go
+type BaggageStatus int
+
+const (
+	BaggageCheckedIn BaggageStatus = iota
+	BaggageLoaded
+	BaggageOffloaded
+	BaggageDelivered
+)
+
+// An offloaded bag may go back on board or be handed back to its owner.
+var baggageTransitions = map[BaggageStatus][]BaggageStatus{
+	BaggageCheckedIn: {BaggageLoaded},
+	BaggageLoaded:    {BaggageOffloaded, BaggageDelivered},
+	BaggageOffloaded: {BaggageLoaded, BaggageDelivered},
+}
+
+func (s BaggageStatus) String() string {
+	switch s {
+	case BaggageCheckedIn:
+		return "CheckedIn"
+	case BaggageLoaded:
+		return "Loaded"
+	case BaggageOffloaded:
+		return "Offloaded"
+	case BaggageDelivered:
+		return "Delivered"
+	}
+	return fmt.Sprintf("BaggageStatus(%d)", int(s))
+}
+
+// Transition returns next if a bag may move from s to next.
+func (s BaggageStatus) Transition(next BaggageStatus) (BaggageStatus, error) {
+	for _, allowed := range baggageTransitions[s] {
+		if allowed == next {
+			return next, nil
+		}
+	}
+	return s, fmt.Errorf("%w: %s -> %s", ErrInvalidBaggageTransition, s, next)
+}
+
+type BaggageEvent struct {
+	Status BaggageStatus
+	At     time.Time
+}
+
+// BaggageLoadReport compares the checked bags accepted for a flight with
+// those on board. NotLoaded lists the tags that are not on board, whether
+// still waiting to be loaded or offloaded again.
+type BaggageLoadReport struct {
+	FlightNumber string
+	CheckedIn    int
+	Loaded       int
+	Offloaded    int
+	NotLoaded    []string
+}
+
+// Mismatch reports whether any accepted bag is not on board.
+func (r *BaggageLoadReport) Mismatch() bool {
+	return r.Loaded != r.CheckedIn
+}
+
+// UpdateBaggageStatus moves a bag on, refusing steps such as delivering a
+// bag that was never loaded.
+func (ams *AirlineManagementSystem) UpdateBaggageStatus(tag string, status BaggageStatus) error {
+	return ams.bookingManager.updateBaggageStatus(tag, status, ams.clock())
+}
+
+// TrackBaggage returns the status history of a bag, oldest first.
+func (ams *AirlineManagementSystem) TrackBaggage(tag string) ([]BaggageEvent, error) {
+	bm := ams.bookingManager
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	bag, ok := bm.bags[tag]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBaggageNotFound, tag)
+	}
+	return append([]BaggageEvent(nil), bag.History...), nil
+}
+
+// BaggageLoadReport covers the checked bags on active bookings for the next
+// departure of the flight number. Delivered bags count as loaded.
+func (ams *AirlineManagementSystem) BaggageLoadReport(flightNumber string) (*BaggageLoadReport, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	bm := ams.bookingManager
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	report := &BaggageLoadReport{FlightNumber: flight.FlightNumber, NotLoaded: make([]string, 0)}
+	for _, booking := range bm.byFlight[flight.FlightNumber] {
+		if booking.Flight != flight || booking.Status == BookingCancelled {
+			continue
+		}
+		for _, bag := range booking.Baggage {
+			if bag.Type != CheckedBag {
+				continue
+			}
+			report.CheckedIn++
+			switch bag.Status {
+			case BaggageCheckedIn:
+				report.NotLoaded = append(report.NotLoaded, bag.Tag)
+			case BaggageLoaded, BaggageDelivered:
+				report.Loaded++
+			case BaggageOffloaded:
+				report.Offloaded++
+				report.NotLoaded = append(report.NotLoaded, bag.Tag)
+			}
+		}
+	}
+	sort.Strings(report.NotLoaded)
+	return report, nil
+}
+
+func (bm *BookingManager) updateBaggageStatus(tag string, status BaggageStatus, now time.Time) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bag, ok := bm.bags[tag]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrBaggageNotFound, tag)
+	}
+	next, err := bag.Status.Transition(status)
+	if err != nil {
+		return err
+	}
+	bag.Status = next
+	bag.History = append(bag.History, BaggageEvent{Status: next, At: now})
+	return nil
+}
+
+// File: boarding_pass.go
+// BoardingPass is issued at check-in. Barcode encodes the pass in a
+// fixed-width layout loosely modelled on IATA BCBP:
+//
+//	M1 | name (20) | E | booking (7) | from (3) | to (3) | flight (8) |
+//	date YYYYMMDD (8) | seat, row padded to 3 digits (4) | group (2)
+//
+// Text fields are upper-cased, left-aligned and space-padded or truncated.
+type BoardingPass struct {
+	BookingID     string
+	PassengerName string
+	FlightNumber  string
+	Source        string
+	Destination   string
+	Departure     time.Time
+	Seat          string
+	BoardingGroup int
+	Terminal      string
+	Gate          string
+	IssuedAt      time.Time
+	Barcode       string
+	// Superseded is set on a stored pass once the booking has changed and a
+	// new pass has to be issued.
+	Superseded bool
+}
+
+// BarcodeFields are the values carried by a boarding pass barcode.
+type BarcodeFields struct {
+	PassengerName string
+	BookingID     string
+	Source        string
+	Destination   string
+	FlightNumber  string
+	Date          time.Time
+	Seat          string
+	BoardingGroup int
+}
+
+const barcodeLength = 58
+
+var barcodeWidths = []int{2, 20, 1, 7, 3, 3, 8, 8, 4, 2}
+
+// GenerateBoardingPass reissues the boarding pass of a checked-in booking
+// with the flight's current gate and seat, replacing a superseded pass.
+func (ams *AirlineManagementSystem) GenerateBoardingPass(bookingID string) (*BoardingPass, error) {
+	bm := ams.bookingManager
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return nil, ErrBookingNotFound
+	}
+	if booking.Status != BookingCheckedIn {
+		return nil, fmt.Errorf("%w: booking is %s", ErrNotCheckedIn, booking.Status)
+	}
+	return bm.issueBoardingPassLocked(booking, ams.clock()), nil
+}
+
+// issueBoardingPassLocked stores a new pass on the booking and returns a
+// copy for the caller.
+func (bm *BookingManager) issueBoardingPassLocked(booking *Booking, now time.Time) *BoardingPass {
+	booking.BoardingPass = newBoardingPass(booking, now)
+	issued := *booking.BoardingPass
+	return &issued
+}
+
+// supersedeBoardingPassLocked marks the pass of a checked-in booking as out
+// of date.
+func (bm *BookingManager) supersedeBoardingPassLocked(booking *Booking, reason string) {
+	if booking.Status != BookingCheckedIn || booking.BoardingPass == nil || booking.BoardingPass.Superseded {
+		return
+	}
+	booking.BoardingPass.Superseded = true
+	bm.recordLocked(booking, ActionPassSuperseded, reason)
+}
+
+// supersedeBoardingPasses marks every issued pass for one departure as out of
+// date.
+func (bm *BookingManager) supersedeBoardingPasses(flight *Flight, reason string) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	for _, booking := range bm.byFlight[flight.FlightNumber] {
+		if booking.Flight == flight {
+			bm.supersedeBoardingPassLocked(booking, reason)
+		}
+	}
+}
+
+// StaleBoardingPasses returns the checked-in bookings on a flight whose
+// boarding pass has been superseded and still has to be reissued.
+func (ams *AirlineManagementSystem) StaleBoardingPasses(flightNumber string) ([]*Booking, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	bm := ams.bookingManager
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	results := make([]*Booking, 0)
+	for _, booking := range bm.byFlight[flight.FlightNumber] {
+		if booking.Flight == flight && booking.Status == BookingCheckedIn && booking.NeedsBoardingPassReissue() {
+			results = append(results, booking)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].BookingID < results[j].BookingID })
+	return results, nil
+}
+
+// NeedsBoardingPassReissue reports whether the issued pass no longer matches
+// the booking.
+func (b *Booking) NeedsBoardingPassReissue() bool {
+	return b.BoardingPass != nil && b.BoardingPass.Superseded
+}
+
+func newBoardingPass(booking *Booking, now time.Time) *BoardingPass {
+	flight := booking.Flight
+	flight.mu.Lock()
+	defer flight.mu.Unlock()
+	pass := &BoardingPass{
+		BookingID:     booking.BookingID,
+		FlightNumber:  flight.FlightNumber,
+		Source:        flight.Source,
+		Destination:   flight.Destination,
+		Departure:     flight.Departure,
+		Terminal:      flight.Terminal,
+		Gate:          flight.Gate,
+		IssuedAt:      now,
+		BoardingGroup: booking.BoardingGroup,
+	}
+	if booking.Passenger != nil {
+		pass.PassengerName = booking.Passenger.Name
+	}
+	if booking.SeatNumber >= 1 && booking.SeatNumber <= len(flight.Seats) {
+		pass.Seat = flight.Seats[booking.SeatNumber-1].Label()
+	}
+	pass.Barcode = pass.barcodeFields().encode()
+	return pass
+}
+
+func (p *BoardingPass) barcodeFields() BarcodeFields {
+	return BarcodeFields{
+		PassengerName: p.PassengerName,
+		BookingID:     p.BookingID,
+		Source:        p.Source,
+		Destination:   p.Destination,
+		FlightNumber:  p.FlightNumber,
+		Date:          p.Departure,
+		Seat:          p.Seat,
+		BoardingGroup: p.BoardingGroup,
+	}
+}
+
+func (f BarcodeFields) encode() string {
+	seat := "    "
+	if row, column, err := parseSeatLabel(f.Seat); err == nil {
+		seat = fmt.Sprintf("%03d%s", row, column)
+	}
+	return fmt.Sprintf("M1%-20.20sE%-7.7s%-3.3s%-3.3s%-8.8s%s%s%02d",
+		strings.ToUpper(f.PassengerName), strings.ToUpper(f.BookingID), f.Source, f.Destination,
+		strings.ToUpper(f.FlightNumber), f.Date.Format("20060102"), seat, f.BoardingGroup%100)
+}
+
+// ParseBarcode decodes a boarding pass barcode. Text fields come back
+// trimmed and upper-cased; the date is midnight UTC.
+func ParseBarcode(barcode string) (*BarcodeFields, error) {
+	runes := []rune(barcode)
+	if len(runes) != barcodeLength {
+		return nil, fmt.Errorf("%w: length %d, want %d", ErrInvalidBarcode, len(runes), barcodeLength)
+	}
+	parts := make([]string, len(barcodeWidths))
+	offset := 0
+	for i, width := range barcodeWidths {
+		parts[i] = string(runes[offset : offset+width])
+		offset += width
+	}
+	if parts[0] != "M1" || parts[2] != "E" {
+		return nil, fmt.Errorf("%w: bad format code", ErrInvalidBarcode)
+	}
+	date, err := time.Parse("20060102", parts[7])
+	if err != nil {
+		return nil, fmt.Errorf("%w: date %q", ErrInvalidBarcode, parts[7])
+	}
+	group, err := strconv.Atoi(parts[9])
+	if err != nil {
+		return nil, fmt.Errorf("%w: boarding group %q", ErrInvalidBarcode, parts[9])
+	}
+	fields := &BarcodeFields{
+		PassengerName: strings.TrimRight(parts[1], " "),
+		BookingID:     strings.TrimRight(parts[3], " "),
+		Source:        strings.TrimRight(parts[4], " "),
+		Destination:   strings.TrimRight(parts[5], " "),
+		FlightNumber:  strings.TrimRight(parts[6], " "),
+		Date:          date,
+		BoardingGroup: group,
+	}
+	if seat := strings.TrimSpace(parts[8]); seat != "" {
+		row, column, err := parseSeatLabel(seat)
+		if err != nil {
+			return nil, fmt.Errorf("%w: seat %q", ErrInvalidBarcode, seat)
+		}
+		fields.Seat = strconv.Itoa(row) + column
+	}
+	return fields, nil
+}
+
+// Render lays the pass out as plain text for printing.
+func (p *BoardingPass) Render() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "BOARDING PASS  %s\n", p.BookingID)
+	fmt.Fprintf(&sb, "  %-10s %s\n", "Passenger", p.PassengerName)
+	fmt.Fprintf(&sb, "  %-10s %s  %s\n", "Flight", p.FlightNumber, p.Departure.Format("02 Jan 2006 15:04"))
+	fmt.Fprintf(&sb, "  %-10s %s -> %s\n", "Route", p.Source, p.Destination)
+	seat := p.Seat
+	if seat == "" {
+		seat = "-"
+	}
+	group := "-"
+	if p.BoardingGroup > 0 {
+		group = strconv.Itoa(p.BoardingGroup)
+	}
+	fmt.Fprintf(&sb, "  %-10s %s  Group %s\n", "Seat", seat, group)
+	gate := strings.TrimSpace(p.Terminal + " " + p.Gate)
+	if gate == "" {
+		gate = "TBA"
+	}
+	fmt.Fprintf(&sb, "  %-10s %s\n", "Gate", gate)
+	fmt.Fprintf(&sb, "  %s\n", p.Barcode)
+	return sb.String()
+}
+
+// File: boarding_policy.go
+// BoardingPolicy decides boarding groups. Group 1 is for the priority cabins
+// and frequent flyer tiers; everyone else boards back to front in
+// EconomyZones groups, starting at group 2. Passengers without a seat board
+// last.
+type BoardingPolicy struct {
+	PriorityClasses []CabinClass
+	PriorityTiers   []FrequentFlyerTier
+	EconomyZones    int
+}
+
+func DefaultBoardingPolicy() BoardingPolicy {
+	return BoardingPolicy{
+		PriorityClasses: []CabinClass{First, Business},
+		PriorityTiers:   []FrequentFlyerTier{TierGold, TierPlatinum},
+		EconomyZones:    4,
+	}
+}
+
+// SetBoardingPolicy applies to check-ins from now on.
+func (ams *AirlineManagementSystem) SetBoardingPolicy(policy BoardingPolicy) error {
+	if policy.EconomyZones < 1 {
+		return ErrInvalidBoardingPolicy
+	}
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	ams.boardingPolicy = policy
+	return nil
+}
+
+func (p BoardingPolicy) priorityClass(class CabinClass) bool {
+	for _, priority := range p.PriorityClasses {
+		if priority == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (p BoardingPolicy) priorityTier(tier FrequentFlyerTier) bool {
+	for _, priority := range p.PriorityTiers {
+		if priority == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// boardingGroupFor works out the group for a passenger in seatNumber.
+func (ams *AirlineManagementSystem) boardingGroupFor(flight *Flight, seatNumber int, passenger *Passenger) int {
+	ams.mu.RLock()
+	policy := ams.boardingPolicy
+	ams.mu.RUnlock()
+	if passenger != nil {
+		if account, err := ams.GetAccount(passenger.PassengerID); err == nil && policy.priorityTier(account.Tier) {
+			return 1
+		}
+	}
+	flight.mu.Lock()
+	defer flight.mu.Unlock()
+	if seatNumber < 1 || seatNumber > len(flight.Seats) {
+		return policy.EconomyZones + 2
+	}
+	seat := flight.Seats[seatNumber-1]
+	if policy.priorityClass(seat.Class) {
+		return 1
+	}
+	first, last := seat.Row, seat.Row
+	for _, other := range flight.Seats {
+		if policy.priorityClass(other.Class) {
+			continue
+		}
+		first, last = min(first, other.Row), max(last, other.Row)
+	}
+	return 2 + (last-seat.Row)*policy.EconomyZones/(last-first+1)
+}
+
+// refreshBoardingGroup recomputes the group of a checked-in booking after
+// its seat has changed.
+func (ams *AirlineManagementSystem) refreshBoardingGroup(bookingID string) {
+	bm := ams.bookingManager
+	bm.mu.RLock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok || booking.Status != BookingCheckedIn {
+		bm.mu.RUnlock()
+		return
+	}
+	flight, seatNumber, passenger := booking.Flight, booking.SeatNumber, booking.Passenger
+	bm.mu.RUnlock()
+	group := ams.boardingGroupFor(flight, seatNumber, passenger)
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if booking.Status == BookingCheckedIn && booking.SeatNumber == seatNumber {
+		booking.BoardingGroup = group
+	}
+}
+
+// ChangeSeat moves the booking to newSeat, as BookingManager.ChangeSeat
+// does, and keeps a checked-in passenger's boarding group in step.
+func (ams *AirlineManagementSystem) ChangeSeat(bookingID string, newSeat int, opts ...ChangeOption) error {
+	if err := ams.bookingManager.ChangeSeat(bookingID, newSeat, opts...); err != nil {
+		return err
+	}
+	ams.refreshBoardingGroup(bookingID)
+	return nil
+}
+
+type BoardingGroup struct {
+	Group    int
+	Bookings []*Booking
+}
+
+// GetBoardingSequence lists the checked-in bookings on the next departure
+// of the flight number by boarding group, each group back to front.
+func (ams *AirlineManagementSystem) GetBoardingSequence(flightNumber string) ([]BoardingGroup, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	flight.mu.Lock()
+	seats := make(map[int]Seat, len(flight.Seats))
+	for _, seat := range flight.Seats {
+		seats[seat.SeatNumber] = *seat
+	}
+	flight.mu.Unlock()
+
+	bm := ams.bookingManager
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	byGroup := make(map[int][]*Booking)
+	for _, booking := range bm.byFlight[flight.FlightNumber] {
+		if booking.Flight == flight && booking.Status == BookingCheckedIn {
+			byGroup[booking.BoardingGroup] = append(byGroup[booking.BoardingGroup], booking)
+		}
+	}
+	sequence := make([]BoardingGroup, 0, len(byGroup))
+	for group, bookings := range byGroup {
+		sort.Slice(bookings, func(i, j int) bool {
+			a, b := seats[bookings[i].SeatNumber], seats[bookings[j].SeatNumber]
+			if a.Row != b.Row {
+				return a.Row > b.Row
+			}
+			if a.columnIndex != b.columnIndex {
+				return a.columnIndex < b.columnIndex
+			}
+			return bookings[i].BookingID < bookings[j].BookingID
+		})
+		sequence = append(sequence, BoardingGroup{Group: group, Bookings: bookings})
+	}
+	sort.Slice(sequence, func(i, j int) bool { return sequence[i].Group < sequence[j].Group })
+	return sequence, nil
+}
+
+// File: booking.go
+type Booking struct {
+	BookingID   string
+	Flight      *Flight
+	Passenger   *Passenger
+	SeatNumber  int
+	BookingTime time.Time
+	Payment     *Payment
+	// Payments lists every portion when the fare was split across payment
+	// methods. Payment is the first of them.
+	Payments []*Payment
+	Status   BookingStatus
+	GroupID  string
+	TripID   string
+	Infant   *Passenger
+	Contact  *BookingContact
+	// CancellationFee is the part of the fare kept when the booking was
+	// cancelled under a refund policy.
+	CancellationFee Money
+	History         []BookingEvent
+	// MarketingNumber is the flight number the booking was sold under, which
+	// differs from Flight.FlightNumber for codeshare sales.
+	MarketingNumber string
+	// Anonymized is set once the passenger's personal data has been erased.
+	Anonymized bool
+	SSRs       []SSRCode
+	// UnaccompaniedMinor is set for a child travelling without an adult.
+	UnaccompaniedMinor *UnaccompaniedMinorForm
+	// BoardingGroup is assigned at check-in; 0 until then.
+	BoardingGroup int
+	// BoardingPass is the pass last issued for the booking.
+	BoardingPass *BoardingPass
+	Baggage      []*Baggage
+}
+
+// charges returns the payments made for the booking itself, excluding fare
+// differences and upgrades recorded separately.
+func (b *Booking) charges() []*Payment {
+	if len(b.Payments) > 0 {
+		return b.Payments
+	}
+	if b.Payment != nil {
+		return []*Payment{b.Payment}
+	}
+	return nil
+}
+
+// BookingContact is the person to reach about a booking, which may differ
+// from the passenger who is travelling.
+type BookingContact struct {
+	Name  string
+	Email string
+	Phone string
+}
+
+func NewBookingContact(name, email, phone string) (*BookingContact, error) {
+	contact := &BookingContact{
+		Name:  name,
+		Email: email,
+		Phone: phone,
+	}
+	if err := contact.Validate(); err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+func (c *BookingContact) Validate() error {
+	if c.Email == "" && c.Phone == "" {
+		return ErrContactChannelRequired
+	}
+	return nil
+}
+
+// NotificationContact returns who should receive notifications and receipts
+// for the booking: the explicit contact if set, otherwise the passenger.
+func (b *Booking) NotificationContact() BookingContact {
+	if b.Contact != nil {
+		return *b.Contact
+	}
+	if b.Passenger == nil {
+		return BookingContact{}
+	}
+	return BookingContact{
+		Name:  b.Passenger.Name,
+		Email: b.Passenger.Email,
+		Phone: b.Passenger.Phone,
+	}
+}
+
+type BookingAction string
+
+const (
+	ActionCreated         BookingAction = "Created"
+	ActionSeatChanged     BookingAction = "SeatChanged"
+	ActionStatusChanged   BookingAction = "StatusChanged"
+	ActionRebooked        BookingAction = "Rebooked"
+	ActionCancelled       BookingAction = "Cancelled"
+	ActionFlightClosed    BookingAction = "FlightClosed"
+	ActionFeeRetained     BookingAction = "FeeRetained"
+	ActionInfantAdded     BookingAction = "InfantAdded"
+	ActionFlightDelayed   BookingAction = "FlightDelayed"
+	ActionFlightCancelled BookingAction = "FlightCancelled"
+	ActionBumped          BookingAction = "Bumped"
+	ActionGateChanged     BookingAction = "GateChanged"
+	ActionAnonymized      BookingAction = "Anonymized"
+	ActionSSRAdded        BookingAction = "SSRAdded"
+	ActionSSRRemoved      BookingAction = "SSRRemoved"
+	ActionPassSuperseded  BookingAction = "BoardingPassSuperseded"
+	ActionCheckInClosed   BookingAction = "CheckInClosed"
+	ActionBaggageAdded    BookingAction = "BaggageAdded"
+)
+
+type BookingEvent struct {
+	Timestamp time.Time
+	Action    BookingAction
+	Detail    string
+}
+
+func NewBooking(bookingID string, flight *Flight, passenger *Passenger, seatNumber int) *Booking {
+	return &Booking{
+		BookingID:   bookingID,
+		Flight:      flight,
+		Passenger:   passenger,
+		SeatNumber:  seatNumber,
+		BookingTime: time.Now(),
+		Status:      BookingPending,
+	}
+}
+
+// File: booking_lock.go
+type ChangeOption func(*changeOptions)
+
+type changeOptions struct {
+	override bool
+}
+
+// AgentOverride lets an agent change a booking that is already checked in.
+// Its boarding pass is superseded and has to be reissued.
+func AgentOverride() ChangeOption {
+	return func(o *changeOptions) {
+		o.override = true
+	}
+}
+
+// checkUnlocked refuses changes to a checked-in booking unless overridden.
+func checkUnlocked(status BookingStatus, opts []ChangeOption) error {
+	var options changeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if status == BookingCheckedIn && !options.override {
+		return ErrBookingLocked
+	}
+	return nil
+}
+
+// File: booking_manager.go
+type BookingManager struct {
+	bookings     map[string]*Booking
+	groups       map[string]*GroupBooking
+	byPassenger  map[string]map[string]*Booking
+	byFlight     map[string]map[string]*Booking
+	byTrip       map[string]map[string]*Booking
+	bags         map[string]*Baggage
+	bagSequence  int
+	tripSequence int
+	now          func() time.Time
+	mu           sync.RWMutex
+}
+
+var (
+	bookingManagerInstance *BookingManager
+	onceBookingManager     sync.Once
+	bookingManagerMu       sync.RWMutex
+)
+
+func NewBookingManager() *BookingManager {
+	return &BookingManager{
+		bookings:    make(map[string]*Booking),
+		groups:      make(map[string]*GroupBooking),
+		byPassenger: make(map[string]map[string]*Booking),
+		byFlight:    make(map[string]map[string]*Booking),
+		byTrip:      make(map[string]map[string]*Booking),
+		bags:        make(map[string]*Baggage),
+		now:         time.Now,
+	}
+}
+
+func GetBookingManager() *BookingManager {
+	onceBookingManager.Do(func() {
+		bookingManagerInstance = NewBookingManager()
+	})
+	bookingManagerMu.RLock()
+	defer bookingManagerMu.RUnlock()
+	return bookingManagerInstance
+}
+
+// ResetBookingManager swaps the singleton for a fresh instance so tests can
+// start from a clean slate. Systems built earlier keep their old instance.
+func ResetBookingManager() *BookingManager {
+	onceBookingManager.Do(func() {})
+	bookingManagerMu.Lock()
+	defer bookingManagerMu.Unlock()
+	bookingManagerInstance = NewBookingManager()
+	return bookingManagerInstance
+}
+
+func (bm *BookingManager) AddBooking(booking *Booking) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if _, exists := bm.bookings[booking.BookingID]; exists {
+		return ErrDuplicateBooking
+	}
+	booking.BookingTime = bm.now()
+	bm.storeLocked(booking)
+	bm.recordLocked(booking, ActionCreated, fmt.Sprintf("flight %s seat %d", booking.Flight.FlightNumber, booking.SeatNumber))
+	return nil
+}
+
+func (bm *BookingManager) UpdateBooking(booking *Booking) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	existing, exists := bm.bookings[booking.BookingID]
+	if !exists {
+		return ErrBookingNotFound
+	}
+	bm.deleteLocked(existing)
+	bm.storeLocked(booking)
+	return nil
+}
+
+func (bm *BookingManager) removeBooking(bookingID string) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if booking, ok := bm.bookings[bookingID]; ok {
+		bm.deleteLocked(booking)
+	}
+}
+
+// recordLocked appends an event to the booking's history. Callers must hold
+// bm.mu so the trail cannot miss a mutation.
+func (bm *BookingManager) recordLocked(booking *Booking, action BookingAction, detail string) {
+	booking.History = append(booking.History, BookingEvent{
+		Timestamp: bm.now(),
+		Action:    action,
+		Detail:    detail,
+	})
+}
+
+func (bm *BookingManager) GetBookingHistory(bookingID string) ([]BookingEvent, error) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return nil, ErrBookingNotFound
+	}
+	history := make([]BookingEvent, len(booking.History))
+	copy(history, booking.History)
+	return history, nil
+}
+
+// storeLocked adds the booking to the primary map and every secondary index.
+// Callers must hold bm.mu.
+func (bm *BookingManager) storeLocked(booking *Booking) {
+	bm.bookings[booking.BookingID] = booking
+	if booking.Passenger != nil {
+		addToIndex(bm.byPassenger, booking.Passenger.PassengerID, booking)
+	}
+	if booking.Flight != nil {
+		addToIndex(bm.byFlight, booking.Flight.FlightNumber, booking)
+	}
+	if booking.TripID != "" {
+		addToIndex(bm.byTrip, booking.TripID, booking)
+	}
+}
+
+// deleteLocked is the inverse of storeLocked. Callers must hold bm.mu.
+func (bm *BookingManager) deleteLocked(booking *Booking) {
+	delete(bm.bookings, booking.BookingID)
+	if booking.Passenger != nil {
+		removeFromIndex(bm.byPassenger, booking.Passenger.PassengerID, booking)
+	}
+	if booking.Flight != nil {
+		removeFromIndex(bm.byFlight, booking.Flight.FlightNumber, booking)
+	}
+	if booking.TripID != "" {
+		removeFromIndex(bm.byTrip, booking.TripID, booking)
+	}
+}
+
+func addToIndex(index map[string]map[string]*Booking, key string, booking *Booking) {
+	if index[key] == nil {
+		index[key] = make(map[string]*Booking)
+	}
+	index[key][booking.BookingID] = booking
+}
+
+func removeFromIndex(index map[string]map[string]*Booking, key string, booking *Booking) {
+	delete(index[key], booking.BookingID)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// GetBookingsByTrip returns the legs of a round trip ordered by departure.
+func (bm *BookingManager) GetBookingsByTrip(tripID string) []*Booking {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	results := make([]*Booking, 0, len(bm.byTrip[tripID]))
+	for _, booking := range bm.byTrip[tripID] {
+		results = append(results, booking)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Flight.Departure.Before(results[j].Flight.Departure)
+	})
+	return results
+}
+
+type BookingQueryOption func(*bookingQuery)
+
+type bookingQuery struct {
+	includeCancelled bool
+	date             time.Time
+	flight           *Flight
+}
+
+func IncludeCancelled() BookingQueryOption {
+	return func(q *bookingQuery) {
+		q.includeCancelled = true
+	}
+}
+
+// DepartingOn keeps bookings on the departure that leaves on date, as a
+// calendar day in date's location.
+func DepartingOn(date time.Time) BookingQueryOption {
+	return func(q *bookingQuery) {
+		q.date = date
+	}
+}
+
+// onFlight keeps bookings on one departure of the flight number.
+func onFlight(flight *Flight) BookingQueryOption {
+	return func(q *bookingQuery) {
+		q.flight = flight
+	}
+}
+
+// GetBookingsByFlight returns the bookings on a flight ordered by seat
+// number. A flight number that operates daily has bookings on every date
+// unless DepartingOn picks one. Cancelled bookings are left out unless
+// IncludeCancelled is passed.
+func (bm *BookingManager) GetBookingsByFlight(flightNumber string, opts ...BookingQueryOption) []*Booking {
+	var query bookingQuery
+	for _, opt := range opts {
+		opt(&query)
+	}
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	results := make([]*Booking, 0, len(bm.byFlight[flightNumber]))
+	for _, booking := range bm.byFlight[flightNumber] {
+		if booking.Status == BookingCancelled && !query.includeCancelled {
+			continue
+		}
+		if query.flight != nil && booking.Flight != query.flight {
+			continue
+		}
+		if !query.date.IsZero() && !sameDay(booking.Flight.Departure.In(query.date.Location()), query.date) {
+			continue
+		}
+		results = append(results, booking)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].SeatNumber != results[j].SeatNumber {
+			return results[i].SeatNumber < results[j].SeatNumber
+		}
+		return results[i].BookingID < results[j].BookingID
+	})
+	return results
+}
+
+// GetBookingsByPassenger returns the passenger's bookings ordered by flight
+// departure time.
+func (bm *BookingManager) GetBookingsByPassenger(passengerID string) []*Booking {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	results := make([]*Booking, 0, len(bm.byPassenger[passengerID]))
+	for _, booking := range bm.byPassenger[passengerID] {
+		results = append(results, booking)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].Flight.Departure.Equal(results[j].Flight.Departure) {
+			return results[i].Flight.Departure.Before(results[j].Flight.Departure)
+		}
+		return results[i].BookingID < results[j].BookingID
+	})
+	return results
+}
+
+func (bm *BookingManager) CancelBooking(bookingID string) (*Booking, error) {
+	return bm.cancel(bookingID, false)
+}
+
+// cancel moves the booking to Cancelled. force also cancels a checked-in
+// booking, which is only right when its flight is not going to operate.
+func (bm *BookingManager) cancel(bookingID string, force bool) (*Booking, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return nil, ErrBookingNotFound
+	}
+	if booking.Status == BookingCancelled {
+		return nil, ErrBookingAlreadyCancelled
+	}
+	if !force || booking.Status != BookingCheckedIn {
+		if _, err := booking.Status.Transition(BookingCancelled); err != nil {
+			return nil, err
+		}
+	}
+	bm.recordLocked(booking, ActionCancelled, fmt.Sprintf("%s -> %s", booking.Status, BookingCancelled))
+	booking.Status = BookingCancelled
+	return booking, nil
+}
+
+func (bm *BookingManager) retainFee(bookingID string, fee Money) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return
+	}
+	bm.recordLocked(booking, ActionFeeRetained, fee.String())
+	booking.CancellationFee = fee
+}
+
+func (bm *BookingManager) UpdateStatus(bookingID string, newStatus BookingStatus) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return ErrBookingNotFound
+	}
+	status, err := booking.Status.Transition(newStatus)
+	if err != nil {
+		return err
+	}
+	bm.recordLocked(booking, ActionStatusChanged, fmt.Sprintf("%s -> %s", booking.Status, status))
+	booking.Status = status
+	return nil
+}
+
+// ChangeSeat moves the booking to newSeat on the same flight. The old seat is
+// only released once the new one has been secured. A checked-in booking
+// needs AgentOverride.
+func (bm *BookingManager) ChangeSeat(bookingID string, newSeat int, opts ...ChangeOption) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return ErrBookingNotFound
+	}
+	if booking.Status == BookingCancelled {
+		return ErrBookingAlreadyCancelled
+	}
+	if booking.SeatNumber == newSeat {
+		return nil
+	}
+	if err := checkUnlocked(booking.Status, opts); err != nil {
+		return err
+	}
+	if err := checkSeatForPassenger(booking.Flight, newSeat, booking.Passenger); err != nil {
+		return err
+	}
+	if err := booking.Flight.swapSeat(booking.SeatNumber, newSeat); err != nil {
+		return err
+	}
+	bm.recordLocked(booking, ActionSeatChanged, fmt.Sprintf("seat %d -> %d", booking.SeatNumber, newSeat))
+	booking.SeatNumber = newSeat
+	bm.supersedeBoardingPassLocked(booking, "seat changed")
+	return nil
+}
+
+func (bm *BookingManager) attachInfant(bookingID string, infant *Passenger) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return ErrBookingNotFound
+	}
+	if booking.Status == BookingCancelled {
+		return ErrBookingAlreadyCancelled
+	}
+	if booking.Passenger == nil || booking.Passenger.Type != Adult {
+		return ErrInfantRequiresAdult
+	}
+	if booking.Infant != nil {
+		return ErrInfantAlreadyAttached
+	}
+	booking.Infant = infant
+	bm.recordLocked(booking, ActionInfantAdded, infant.PassengerID)
+	return nil
+}
+
+// moveBooking points the booking at a new flight and seat, returning the
+// flight and seat it held before.
+func (bm *BookingManager) moveBooking(bookingID string, flight *Flight, seatNumber int) (*Flight, int, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return nil, 0, ErrBookingNotFound
+	}
+	if booking.Status == BookingCancelled {
+		return nil, 0, ErrBookingAlreadyCancelled
+	}
+	oldFlight, oldSeat := booking.Flight, booking.SeatNumber
+	bm.deleteLocked(booking)
+	booking.Flight = flight
+	booking.SeatNumber = seatNumber
+	bm.storeLocked(booking)
+	bm.recordLocked(booking, ActionRebooked, fmt.Sprintf("flight %s seat %d -> flight %s seat %d", oldFlight.FlightNumber, oldSeat, flight.FlightNumber, seatNumber))
+	bm.supersedeBoardingPassLocked(booking, "rebooked")
+	return oldFlight, oldSeat, nil
+}
+
+// statusOf reads the booking's status under the manager's lock.
+func (bm *BookingManager) statusOf(booking *Booking) BookingStatus {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	return booking.Status
+}
+
+func (bm *BookingManager) seatOf(booking *Booking) (*Flight, int) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	return booking.Flight, booking.SeatNumber
+}
+
+func (bm *BookingManager) Count() int {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	return len(bm.bookings)
+}
+
+// pnrAlphabet leaves out 0/O and 1/I so references can be read over the phone.
+const (
+	pnrAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	pnrLength   = 6
+)
+
+// NewBookingID returns a 6-character PNR not used by any stored booking or
+// group booking.
+func (bm *BookingManager) NewBookingID() string {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.newBookingIDLocked()
+}
+
+// newTripID numbers round trips on their own sequence so they never use up
+// a PNR.
+func (bm *BookingManager) newTripID() string {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.tripSequence++
+	return fmt.Sprintf("TRP%06d", bm.tripSequence)
+}
+
+func (bm *BookingManager) newBookingIDLocked() string {
+	for {
+		id := randomPNR()
+		_, bookingExists := bm.bookings[id]
+		_, groupExists := bm.groups[id]
+		if !bookingExists && !groupExists {
+			return id
+		}
+	}
+}
+
+func randomPNR() string {
+	b := make([]byte, pnrLength)
+	for i := range b {
+		b[i] = pnrAlphabet[rand.Intn(len(pnrAlphabet))]
+	}
+	return string(b)
+}
+
+func (bm *BookingManager) GetBooking(bookingID string) (*Booking, error) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return nil, ErrBookingNotFound
+	}
+	return booking, nil
+}
+
+// File: booking_status.go
+type BookingStatus int
+
+const (
+	BookingPending BookingStatus = iota
+	BookingConfirmed
+	BookingCancelled
+	BookingCheckedIn
+	BookingCompleted
+	BookingNoShow
+)
+
+var bookingTransitions = map[BookingStatus][]BookingStatus{
+	BookingPending:   {BookingConfirmed, BookingCancelled},
+	BookingConfirmed: {BookingCancelled, BookingCheckedIn, BookingNoShow},
+	BookingCheckedIn: {BookingCompleted},
+}
+
+func (s BookingStatus) String() string {
+	switch s {
+	case BookingPending:
+		return "Pending"
+	case BookingConfirmed:
+		return "Confirmed"
+	case BookingCancelled:
+		return "Cancelled"
+	case BookingCheckedIn:
+		return "CheckedIn"
+	case BookingCompleted:
+		return "Completed"
+	case BookingNoShow:
+		return "NoShow"
+	}
+	return fmt.Sprintf("BookingStatus(%d)", int(s))
+}
+
+// Transition returns next if moving from s to next is a legal step in the
+// booking lifecycle.
+func (s BookingStatus) Transition(next BookingStatus) (BookingStatus, error) {
+	for _, allowed := range bookingTransitions[s] {
+		if allowed == next {
+			return next, nil
+		}
+	}
+	return s, fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, s, next)
+}
+
+// File: booking_flow.go
+type BookingOption func(*bookingOptions)
+
+type bookingOptions struct {
+	date          time.Time
+	seatNumber    int
+	paymentMethod PaymentMethod
+	contact       *BookingContact
+	class         *CabinClass
+	preference    *SeatPreference
+	lockOwner     string
+	allowPaidSeat bool
+	allowExitRow  bool
+	minorForm     *UnaccompaniedMinorForm
+}
+
+// OnDate books the flight departing on date, for flight numbers that operate
+// daily. Without it the next departure is booked.
+func OnDate(date time.Time) BookingOption {
+	return func(o *bookingOptions) {
+		o.date = date
+	}
+}
+
+// WithSeat requests a specific seat. Without it a free seat is auto-assigned.
+func WithSeat(seatNumber int) BookingOption {
+	return func(o *bookingOptions) {
+		o.seatNumber = seatNumber
+	}
+}
+
+func WithPaymentMethod(method PaymentMethod) BookingOption {
+	return func(o *bookingOptions) {
+		o.paymentMethod = method
+	}
+}
+
+// WithPaidSeats lets auto-assignment pick seats that carry a surcharge.
+func WithPaidSeats() BookingOption {
+	return func(o *bookingOptions) {
+		o.allowPaidSeat = true
+	}
+}
+
+// WithExitRowSeat opts in to auto-assigned exit-row seats. It only takes
+// effect for passengers who are eligible to sit there.
+func WithExitRowSeat() BookingOption {
+	return func(o *bookingOptions) {
+		o.allowExitRow = true
+	}
+}
+
+// WithSeatLockOwner books on behalf of the session holding a seat lock.
+func WithSeatLockOwner(owner string) BookingOption {
+	return func(o *bookingOptions) {
+		o.lockOwner = owner
+	}
+}
+
+// WithSeatPreference auto-assigns a seat in the preferred position instead
+// of an explicit seat number.
+func WithSeatPreference(pref SeatPreference) BookingOption {
+	return func(o *bookingOptions) {
+		o.preference = &pref
+	}
+}
+
+// WithCabinClass restricts the booking to seats in the given cabin.
+func WithCabinClass(class CabinClass) BookingOption {
+	return func(o *bookingOptions) {
+		o.class = &class
+	}
+}
+
+// WithContact sets a contact that differs from the travelling passenger.
+func WithContact(contact *BookingContact) BookingOption {
+	return func(o *bookingOptions) {
+		o.contact = contact
+	}
+}
+
+// WithUnaccompaniedMinor supplies the guardians for a child travelling
+// without an adult.
+func WithUnaccompaniedMinor(form *UnaccompaniedMinorForm) BookingOption {
+	return func(o *bookingOptions) {
+		o.minorForm = form
+	}
+}
+
+// CreateBooking reserves the seat, charges the payment and records the
+// booking. If the payment fails the seat is released again. A seatNumber of
+// 0 auto-assigns a seat.
+func (ams *AirlineManagementSystem) CreateBooking(flightNumber string, passenger *Passenger, seatNumber int, payment *Payment) (*Booking, error) {
+	if err := payment.Validate(); err != nil {
+		return nil, err
+	}
+	ams.ExpireHolds()
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	if err := ams.checkBookable(flight); err != nil {
+		return nil, err
+	}
+	if seatNumber == 0 {
+		seatNumber, err = flight.AutoAssignSeat(nil)
+	} else {
+		err = flight.reserveSeat(seatNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+	booking := NewBooking(ams.bookingManager.NewBookingID(), flight, passenger, seatNumber)
+	return ams.completeBooking(booking, payment)
+}
+
+// BookFlight is the high-level booking entry point: it picks or validates the
+// seat, generates booking and payment IDs and charges the flight fare.
+func (ams *AirlineManagementSystem) BookFlight(flightNumber string, passenger *Passenger, opts ...BookingOption) (*Booking, *Payment, error) {
+	options := bookingOptions{paymentMethod: defaultPaymentMethod()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.paymentMethod == nil {
+		return nil, nil, ErrPaymentMethodRequired
+	}
+	booking, quote, err := ams.prepareBooking(flightNumber, passenger, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	payment := NewPayment(ams.paymentProcessor.nextPaymentID(), quote.Total, options.paymentMethod, PaymentPending)
+	payment.LineItems = quote.LineItems()
+	if _, err := ams.completeBooking(booking, payment); err != nil {
+		return nil, nil, err
+	}
+	return booking, payment, nil
+}
+
+// BookFlightFor books a passenger already in the registry.
+func (ams *AirlineManagementSystem) BookFlightFor(flightNumber, passengerID string, opts ...BookingOption) (*Booking, *Payment, error) {
+	passenger, err := ams.passengers.GetPassenger(passengerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ams.BookFlight(flightNumber, passenger, opts...)
+}
+
+// prepareBooking validates options, secures a seat and quotes it. The
+// returned booking has not been stored yet; the caller must complete it or
+// release the seat.
+func (ams *AirlineManagementSystem) prepareBooking(flightNumber string, passenger *Passenger, options bookingOptions) (*Booking, *Quote, error) {
+	if options.contact != nil {
+		if err := options.contact.Validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if passenger != nil {
+		registered, err := ams.passengers.resolve(passenger)
+		if err != nil {
+			return nil, nil, err
+		}
+		passenger = registered
+		if !passenger.ExitRowEligible() {
+			options.allowExitRow = false
+		}
+	}
+	ams.ExpireHolds()
+	var flight *Flight
+	var err error
+	if options.date.IsZero() {
+		flight, err = ams.findFlight(flightNumber)
+	} else {
+		flight, err = ams.findFlightOn(flightNumber, options.date)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ams.checkBookable(flight); err != nil {
+		return nil, nil, err
+	}
+	seatNumber := options.seatNumber
+	if seatNumber == 0 {
+		seatNumber, err = assignSeat(options, flight.reserveAnySeat)
+	} else {
+		if err := checkSeatClass(flight, seatNumber, options); err != nil {
+			return nil, nil, err
+		}
+		err = flight.BookSeatAs(seatNumber, options.lockOwner)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	quote, err := newQuote(flight, seatNumber)
+	if err != nil {
+		flight.ReleaseSeat(seatNumber)
+		return nil, nil, err
+	}
+	booking := NewBooking(ams.bookingManager.NewBookingID(), flight, passenger, seatNumber)
+	booking.Contact = options.contact
+	booking.MarketingNumber = ams.marketingNumber(flightNumber, flight)
+	if passenger != nil && passenger.Type == Child {
+		booking.UnaccompaniedMinor = options.minorForm
+	}
+	return booking, quote, nil
+}
+
+// checkBookable refuses flights that have been cancelled or have left,
+// whether by status or by the clock.
+func (ams *AirlineManagementSystem) checkBookable(flight *Flight) error {
+	switch flight.CurrentStatus() {
+	case FlightCancelled:
+		return ErrFlightCancelled
+	case FlightDeparted, FlightArrived:
+		return ErrFlightDeparted
+	}
+	if !flight.departureTime().After(ams.clock()) {
+		return ErrFlightDeparted
+	}
+	return nil
+}
+
+// assignSeat picks a seat honouring the cabin class, seat preference and
+// paid-seat opt-in in options. pick either reserves the seat or only looks
+// it up, so the same rules drive booking and quoting.
+func assignSeat(options bookingOptions, pick func(func(*Seat) bool) (int, error)) (int, error) {
+	eligible := seatFilter(options)
+	if options.preference == nil || options.preference.Position == AnyPosition {
+		return pick(eligible)
+	}
+	pref := *options.preference
+	seatNumber, err := pick(func(seat *Seat) bool {
+		return eligible(seat) && seat.Position == pref.Position
+	})
+	if errors.Is(err, ErrNoSeatsAvailable) {
+		if pref.Strict {
+			return 0, ErrNoSeatMatchingPreference
+		}
+		return pick(eligible)
+	}
+	return seatNumber, err
+}
+
+// seatFilter matches the seats auto-assignment may pick: the requested
+// cabin, exit rows only when allowed and paid seats only when opted in.
+func seatFilter(options bookingOptions) func(*Seat) bool {
+	class := options.class
+	if options.preference != nil && options.preference.Class != nil {
+		class = options.preference.Class
+	}
+	return func(seat *Seat) bool {
+		if class != nil && seat.Class != *class {
+			return false
+		}
+		if seat.ExitRow && !options.allowExitRow {
+			return false
+		}
+		return options.allowPaidSeat || seat.Surcharge.IsZero()
+	}
+}
+
+func checkSeatClass(flight *Flight, seatNumber int, options bookingOptions) error {
+	if options.class == nil {
+		return nil
+	}
+	class, err := flight.seatClass(seatNumber)
+	if err != nil {
+		return err
+	}
+	if class != *options.class {
+		return ErrCabinClassMismatch
+	}
+	return nil
+}
+
+// checkSeatForPassenger applies the passenger rules that depend on the seat:
+// infants never get a seat of their own and exit rows need an eligible adult.
+func checkSeatForPassenger(flight *Flight, seatNumber int, passenger *Passenger) error {
+	if passenger == nil {
+		return nil
+	}
+	if passenger.Type == Infant {
+		return ErrInfantRequiresAdult
+	}
+	exitRow, err := flight.isExitRow(seatNumber)
+	if err != nil {
+		return err
+	}
+	if exitRow && !passenger.ExitRowEligible() {
+		return ErrIneligibleForExitRow
+	}
+	return nil
+}
+
+// completeBooking stores the booking and charges its payments in order. If
+// one fails, the portions already captured are refunded and the seat is
+// released.
+func (ams *AirlineManagementSystem) completeBooking(booking *Booking, payments ...*Payment) (*Booking, error) {
+	if err := checkSeatForPassenger(booking.Flight, booking.SeatNumber, booking.Passenger); err != nil {
+		booking.Flight.ReleaseSeat(booking.SeatNumber)
+		return nil, err
+	}
+	if err := checkTravelDocument(booking.Flight, booking.Passenger); err != nil {
+		booking.Flight.ReleaseSeat(booking.SeatNumber)
+		return nil, err
+	}
+	if booking.Passenger != nil && booking.Passenger.Type == Child {
+		if err := checkUnaccompaniedMinor(booking.Flight, booking.UnaccompaniedMinor); err != nil {
+			booking.Flight.ReleaseSeat(booking.SeatNumber)
+			return nil, err
+		}
+	}
+	for _, payment := range payments {
+		if err := payment.Validate(); err != nil {
+			booking.Flight.ReleaseSeat(booking.SeatNumber)
+			return nil, err
+		}
+		payment.BookingID = booking.BookingID
+		if booking.Passenger != nil {
+			payment.PassengerID = booking.Passenger.PassengerID
+		}
+	}
+	booking.Payment = payments[0]
+	if len(payments) > 1 {
+		booking.Payments = payments
+	}
+	if err := ams.bookingManager.AddBooking(booking); err != nil {
+		booking.Flight.ReleaseSeat(booking.SeatNumber)
+		return nil, err
+	}
+	for i, payment := range payments {
+		if err := ams.paymentProcessor.ProcessPayment(payment); err != nil {
+			errs := []error{err}
+			for _, captured := range payments[:i] {
+				errs = append(errs, ams.paymentProcessor.Refund(captured.PaymentID))
+			}
+			ams.bookingManager.removeBooking(booking.BookingID)
+			booking.Flight.ReleaseSeat(booking.SeatNumber)
+			return nil, errors.Join(errs...)
+		}
+	}
+	if err := ams.bookingManager.UpdateStatus(booking.BookingID, BookingConfirmed); err != nil {
+		return nil, err
+	}
+	return booking, nil
+}
+
+// CancelBooking cancels the booking, frees its seat and refunds the payment.
+func (ams *AirlineManagementSystem) CancelBooking(bookingID string) error {
+	existing, err := ams.bookingManager.GetBooking(bookingID)
+	if err != nil {
+		return err
+	}
+	if existing.GroupID != "" {
+		return ErrBookingInGroup
+	}
+	return ams.cancelBooking(bookingID, true)
+}
+
+// cancelBooking refunds according to the booking's refund rules when
+// applyRules is set, and in full otherwise.
+func (ams *AirlineManagementSystem) cancelBooking(bookingID string, applyRules bool) error {
+	booking, err := ams.bookingManager.CancelBooking(bookingID)
+	if err != nil {
+		return err
+	}
+	var releaseErr error
+	if booking.SeatNumber != 0 {
+		releaseErr = booking.Flight.ReleaseSeat(booking.SeatNumber)
+	}
+	if booking.Infant != nil {
+		booking.Flight.releaseLapInfant()
+	}
+	errs := []error{releaseErr}
+	var rules *RefundRules
+	if applyRules {
+		rules = ams.refundRulesFor(booking)
+	}
+	if rules == nil {
+		for _, payment := range booking.charges() {
+			errs = append(errs, ams.paymentProcessor.Refund(payment.PaymentID))
+		}
+		return errors.Join(errs...)
+	}
+	paid := Money{}
+	for _, payment := range booking.charges() {
+		if paid, err = paid.Add(payment.Amount); err != nil {
+			return errors.Join(releaseErr, err)
+		}
+	}
+	refund, fee := rules.Evaluate(booking.Flight.Departure, ams.clock(), paid)
+	if fee.Amount > 0 {
+		ams.bookingManager.retainFee(bookingID, fee)
+	}
+	return errors.Join(append(errs, ams.refundCharges(booking, refund))...)
+}
+
+// refundCharges refunds amount against the booking's charges, oldest first.
+func (ams *AirlineManagementSystem) refundCharges(booking *Booking, refund Money) error {
+	errs := make([]error, 0)
+	for _, payment := range booking.charges() {
+		if refund.Amount <= 0 {
+			break
+		}
+		portion := payment.Amount
+		if refund.Amount < portion.Amount {
+			portion = refund
+		}
+		_, err := ams.paymentProcessor.RefundPayment(payment.PaymentID, portion)
+		errs = append(errs, err)
+		refund.Amount -= portion.Amount
+	}
+	return errors.Join(errs...)
+}
+
+// scrubBooking cancels a booking whose flight is not going to operate and
+// refunds it in full. Checked-in bookings are cancelled too, and a group
+// member gets back only its own share of the group payment.
+func (ams *AirlineManagementSystem) scrubBooking(booking *Booking) error {
+	if _, err := ams.bookingManager.cancel(booking.BookingID, true); err != nil {
+		return err
+	}
+	errs := make([]error, 0)
+	if booking.SeatNumber != 0 {
+		errs = append(errs, booking.Flight.ReleaseSeat(booking.SeatNumber))
+	}
+	if booking.Infant != nil {
+		booking.Flight.releaseLapInfant()
+	}
+	groupPayment, share, inGroup := ams.bookingManager.groupShare(booking)
+	for _, payment := range booking.charges() {
+		if inGroup && payment == groupPayment {
+			_, err := ams.paymentProcessor.RefundPayment(payment.PaymentID, share)
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, ams.paymentProcessor.Refund(payment.PaymentID))
+	}
+	return errors.Join(errs...)
+}
+
+// AddLapInfant attaches an infant to an adult's booking. The infant shares
+// the adult's seat and counts against the flight's lap-infant cap.
+func (ams *AirlineManagementSystem) AddLapInfant(adultBookingID string, infant *Passenger) error {
+	if infant.Type != Infant {
+		return ErrNotAnInfant
+	}
+	booking, err := ams.bookingManager.GetBooking(adultBookingID)
+	if err != nil {
+		return err
+	}
+	if err := checkTravelDocument(booking.Flight, infant); err != nil {
+		return err
+	}
+	if err := booking.Flight.reserveLapInfant(); err != nil {
+		return err
+	}
+	if err := ams.bookingManager.attachInfant(adultBookingID, infant); err != nil {
+		booking.Flight.releaseLapInfant()
+		return err
+	}
+	return nil
+}
+
+// RebookToFlight moves a booking onto another flight, charging any fare
+// difference as an additional payment and refunding it when the new seat
+// quotes lower. Flights on a different route are refused unless force is
+// set. A checked-in booking needs AgentOverride.
+func (ams *AirlineManagementSystem) RebookToFlight(bookingID, newFlightNumber string, seatNumber int, force bool, opts ...ChangeOption) error {
+	booking, err := ams.bookingManager.GetBooking(bookingID)
+	if err != nil {
+		return err
+	}
+	status := ams.bookingManager.statusOf(booking)
+	if status == BookingCancelled {
+		return ErrBookingAlreadyCancelled
+	}
+	if err := checkUnlocked(status, opts); err != nil {
+		return err
+	}
+	newFlight, err := ams.findFlight(newFlightNumber)
+	if err != nil {
+		return err
+	}
+	if err := ams.checkBookable(newFlight); err != nil {
+		return err
+	}
+	if newFlight == booking.Flight {
+		return ErrSameFlight
+	}
+	if !force && (newFlight.Source != booking.Flight.Source || newFlight.Destination != booking.Flight.Destination) {
+		return ErrRouteMismatch
+	}
+	if err := checkSeatForPassenger(newFlight, seatNumber, booking.Passenger); err != nil {
+		return err
+	}
+	if err := checkTravelDocument(newFlight, booking.Passenger); err != nil {
+		return err
+	}
+	if booking.Infant != nil {
+		if err := checkTravelDocument(newFlight, booking.Infant); err != nil {
+			return err
+		}
+	}
+	if booking.UnaccompaniedMinor != nil && newFlight.RefuseMinors {
+		return ErrMinorNotAccepted
+	}
+	if err := newFlight.reserveSeat(seatNumber); err != nil {
+		return err
+	}
+	if booking.Infant != nil {
+		if err := newFlight.reserveLapInfant(); err != nil {
+			newFlight.ReleaseSeat(seatNumber)
+			return err
+		}
+	}
+	rollback := func() {
+		newFlight.ReleaseSeat(seatNumber)
+		if booking.Infant != nil {
+			newFlight.releaseLapInfant()
+		}
+	}
+	difference, err := ams.rebookDifference(booking, newFlight, seatNumber)
+	if err != nil {
+		rollback()
+		return err
+	}
+	var payment *Payment
+	if difference.Amount > 0 {
+		method := defaultPaymentMethod()
+		if booking.Payment != nil {
+			method = booking.Payment.Method
+		}
+		payment = NewPayment(ams.paymentProcessor.nextPaymentID(), difference, method, PaymentPending)
+		payment.BookingID = bookingID
+		if booking.Passenger != nil {
+			payment.PassengerID = booking.Passenger.PassengerID
+		}
+		payment.LineItems = []LineItem{{Kind: LineFareDifference, Amount: difference}}
+		if err := ams.paymentProcessor.ProcessPayment(payment); err != nil {
+			rollback()
+			return err
+		}
+	}
+	oldFlight, oldSeat, err := ams.bookingManager.moveBooking(bookingID, newFlight, seatNumber)
+	if err != nil {
+		rollback()
+		if payment != nil {
+			return errors.Join(err, ams.paymentProcessor.Refund(payment.PaymentID))
+		}
+		return err
+	}
+	oldFlight.ReleaseSeat(oldSeat)
+	if booking.Infant != nil {
+		oldFlight.releaseLapInfant()
+	}
+	ams.refreshBoardingGroup(bookingID)
+	if difference.Amount < 0 {
+		return ams.refundCharges(booking, difference.Multiply(-1))
+	}
+	return nil
+}
+
+// rebookDifference is what the new seat costs over the booking's current
+// one, both quoted the way a new booking would be. A booking left without a
+// seat is compared against the economy fare of its flight.
+func (ams *AirlineManagementSystem) rebookDifference(booking *Booking, newFlight *Flight, seatNumber int) (Money, error) {
+	target, err := newQuote(newFlight, seatNumber)
+	if err != nil {
+		return Money{}, err
+	}
+	oldFlight, oldSeat := ams.bookingManager.seatOf(booking)
+	var current *Quote
+	if oldSeat == 0 {
+		current, err = classQuote(oldFlight, Economy)
+	} else {
+		current, err = newQuote(oldFlight, oldSeat)
+	}
+	if err != nil {
+		return Money{}, err
+	}
+	return target.Total.Sub(current.Total)
+}
+
+// File: cabin_class.go
+type CabinClass int
+
+const (
+	Economy CabinClass = iota
+	PremiumEconomy
+	Business
+	First
+)
+
+func (c CabinClass) String() string {
+	switch c {
+	case Economy:
+		return "Economy"
+	case PremiumEconomy:
+		return "PremiumEconomy"
+	case Business:
+		return "Business"
+	case First:
+		return "First"
+	}
+	return fmt.Sprintf("CabinClass(%d)", int(c))
+}
+
+type CabinLayout struct {
+	Class   CabinClass
+	Seats   int
+	Columns string
+}
+
+// File: check_in.go
+const (
+	defaultCheckInOpens  = 48 * time.Hour
+	defaultCheckInCloses = 60 * time.Minute
+)
+
+// WithCheckInWindow opens check-in opens before departure and closes it
+// closes before departure.
+func WithCheckInWindow(opens, closes time.Duration) FlightOption {
+	return func(f *Flight) {
+		f.CheckInOpens = opens
+		f.CheckInCloses = closes
+	}
+}
+
+func (f *Flight) checkInWindow() (opens, closes time.Duration) {
+	opens, closes = f.CheckInOpens, f.CheckInCloses
+	if opens == 0 {
+		opens = defaultCheckInOpens
+	}
+	if closes == 0 {
+		closes = defaultCheckInCloses
+	}
+	return opens, closes
+}
+
+// isCheckInClosed reports whether CloseCheckIn has run for the flight.
+func (f *Flight) isCheckInClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.checkInClosed
+}
+
+type CheckInOption func(*checkInOptions)
+
+type checkInOptions struct {
+	atAirport bool
+}
+
+// AtAirport checks in at the desk, which is required for unaccompanied
+// minors.
+func AtAirport() CheckInOption {
+	return func(o *checkInOptions) {
+		o.atAirport = true
+	}
+}
+
+// CheckIn checks the booking in and issues a boarding pass. Check-in is open
+// from CheckInOpens until CheckInCloses before the scheduled departure, both
+// ends included. A booking left without a seat, e.g. after an aircraft
+// change, is given one now.
+func (ams *AirlineManagementSystem) CheckIn(bookingID string, now time.Time, opts ...CheckInOption) (*BoardingPass, error) {
+	var options checkInOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	booking, err := ams.bookingManager.GetBooking(bookingID)
+	if err != nil {
+		return nil, err
+	}
+	status, passenger, seatNumber, airportOnly := ams.bookingManager.checkInState(booking)
+	switch status {
+	case BookingCancelled:
+		return nil, ErrBookingAlreadyCancelled
+	case BookingCheckedIn, BookingCompleted:
+		return nil, ErrAlreadyCheckedIn
+	}
+	if airportOnly && !options.atAirport {
+		return nil, ErrAirportCheckInRequired
+	}
+	flight := booking.Flight
+	switch flight.CurrentStatus() {
+	case FlightCancelled:
+		return nil, ErrFlightCancelled
+	case FlightDeparted, FlightArrived:
+		return nil, ErrFlightDeparted
+	}
+	opens, closes := flight.checkInWindow()
+	if now.Before(flight.Departure.Add(-opens)) {
+		return nil, fmt.Errorf("%w: opens %s", ErrCheckInNotOpen, flight.Departure.Add(-opens).Format(time.RFC3339))
+	}
+	if now.After(flight.Departure.Add(-closes)) || flight.isCheckInClosed() {
+		return nil, ErrCheckInClosed
+	}
+	assigned := 0
+	if seatNumber == 0 {
+		options := bookingOptions{allowExitRow: passenger != nil && passenger.ExitRowEligible()}
+		if assigned, err = assignSeat(options, flight.reserveAnySeat); err != nil {
+			return nil, err
+		}
+	}
+	seat := seatNumber
+	if assigned != 0 {
+		seat = assigned
+	}
+	group := ams.boardingGroupFor(flight, seat, passenger)
+	pass, err := ams.bookingManager.checkIn(bookingID, assigned, group, now)
+	if err != nil {
+		if assigned != 0 {
+			flight.ReleaseSeat(assigned)
+		}
+		return nil, err
+	}
+	return pass, nil
+}
+
+func (bm *BookingManager) checkInState(booking *Booking) (BookingStatus, *Passenger, int, bool) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	return booking.Status, booking.Passenger, booking.SeatNumber, booking.RequiresAirportCheckIn()
+}
+
+// checkIn moves the booking to CheckedIn in the given boarding group, giving
+// it seatNumber if it has no seat, and issues the boarding pass.
+func (bm *BookingManager) checkIn(bookingID string, seatNumber, group int, now time.Time) (*BoardingPass, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return nil, ErrBookingNotFound
+	}
+	if booking.Status == BookingCheckedIn {
+		return nil, ErrAlreadyCheckedIn
+	}
+	status, err := booking.Status.Transition(BookingCheckedIn)
+	if err != nil {
+		return nil, err
+	}
+	if seatNumber != 0 {
+		if booking.SeatNumber != 0 {
+			return nil, ErrSeatUnavailable
+		}
+		booking.SeatNumber = seatNumber
+		bm.recordLocked(booking, ActionSeatChanged, fmt.Sprintf("seat %d assigned at check-in", seatNumber))
+	}
+	bm.recordLocked(booking, ActionStatusChanged, fmt.Sprintf("%s -> %s", booking.Status, status))
+	booking.Status = status
+	booking.BoardingGroup = group
+	return bm.issueBoardingPassLocked(booking, now), nil
+}
+
+// File: codeshare.go
+type codeshare struct {
+	number   string
+	operator string
+}
+
+// AddCodeshare sells every departure of the operated flight number under
+// marketingNumber as well, e.g. AI-101 as LH-7420. A marketing number may not
+// be an operated flight number or belong to another flight.
+func (ams *AirlineManagementSystem) AddCodeshare(flightNumber, marketingNumber string) error {
+	key := normalizeFlightNumber(marketingNumber)
+	if key == "" {
+		return ErrFlightNumberRequired
+	}
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	flights := ams.byNumber[flightNumber]
+	if len(flights) == 0 {
+		return ErrFlightNotFound
+	}
+	if existing, ok := ams.codeshares[key]; ok {
+		if existing.operator == flightNumber {
+			return nil
+		}
+		return fmt.Errorf("%w: %s is sold on %s", ErrCodeshareConflict, marketingNumber, existing.operator)
+	}
+	for number := range ams.byNumber {
+		if normalizeFlightNumber(number) == key {
+			return fmt.Errorf("%w: %s is an operated flight", ErrCodeshareConflict, marketingNumber)
+		}
+	}
+	ams.codeshares[key] = codeshare{number: strings.TrimSpace(marketingNumber), operator: flightNumber}
+	codeshares := ams.codesharesForLocked(flightNumber)
+	for _, flight := range flights {
+		flight.mu.Lock()
+		flight.Codeshares = codeshares
+		flight.mu.Unlock()
+	}
+	ams.flightSearch.addCodeshare(key, normalizeFlightNumber(flightNumber))
+	return nil
+}
+
+func (ams *AirlineManagementSystem) codesharesForLocked(flightNumber string) []string {
+	numbers := make([]string, 0)
+	for _, cs := range ams.codeshares {
+		if cs.operator == flightNumber {
+			numbers = append(numbers, cs.number)
+		}
+	}
+	sort.Strings(numbers)
+	return numbers
+}
+
+// resolveLocked maps a codeshare number to the flight number operating it.
+func (ams *AirlineManagementSystem) resolveLocked(flightNumber string) string {
+	if _, ok := ams.byNumber[flightNumber]; ok {
+		return flightNumber
+	}
+	if cs, ok := ams.codeshares[normalizeFlightNumber(flightNumber)]; ok {
+		return cs.operator
+	}
+	return flightNumber
+}
+
+// marketingNumber is the number a booking for flight requested as
+// flightNumber is sold under.
+func (ams *AirlineManagementSystem) marketingNumber(flightNumber string, flight *Flight) string {
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	if cs, ok := ams.codeshares[normalizeFlightNumber(flightNumber)]; ok && cs.operator == flight.FlightNumber {
+		return cs.number
+	}
+	return flight.FlightNumber
+}
+
+func (fs *FlightSearch) addCodeshare(marketing, operated string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.codeshares[marketing] = operated
+}
+
+// File: connection_search.go
+// maxConnections caps how many one-stop itineraries a search returns.
+const maxConnections = 20
+
+// Connection is a one-stop itinerary: First lands at the hub and Second
+// leaves it for the destination.
+type Connection struct {
+	First    *Flight
+	Second   *Flight
+	Layover  time.Duration
+	Duration time.Duration
+}
+
+// SearchConnections finds one-stop itineraries leaving source on date whose
+// layover falls within [minLayover, maxLayover]. The second leg may depart
+// on a later day if the window allows it. Results are sorted by total travel
+// time and capped at maxConnections.
+func (fs *FlightSearch) SearchConnections(source, destination string, date time.Time, minLayover, maxLayover time.Duration) ([]*Connection, error) {
+	if minLayover < 0 || maxLayover < minLayover {
+		return nil, ErrInvalidLayover
+	}
+	source, destination = normalizeAirportCode(source), normalizeAirportCode(destination)
+	now := fs.now()
+	connections := make([]*Connection, 0)
+	for _, hub := range fs.hubsFrom(source) {
+		if hub == source || hub == destination {
+			continue
+		}
+		onward := fs.routeFlights(hub, destination)
+		if len(onward) == 0 {
+			continue
+		}
+		for _, first := range fs.SearchFlights(source, hub, date) {
+			for _, second := range onward {
+				if !searchable(second, now, false) {
+					continue
+				}
+				layover := second.Departure.Sub(first.Arrival)
+				if layover < minLayover || layover > maxLayover {
+					continue
+				}
+				connections = append(connections, &Connection{
+					First:    first,
+					Second:   second.clone(),
+					Layover:  layover,
+					Duration: second.Arrival.Sub(first.Departure),
+				})
+			}
+		}
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		a, b := connections[i], connections[j]
+		if a.Duration != b.Duration {
+			return a.Duration < b.Duration
+		}
+		if a.First.FlightNumber != b.First.FlightNumber {
+			return a.First.FlightNumber < b.First.FlightNumber
+		}
+		return a.Second.FlightNumber < b.Second.FlightNumber
+	})
+	if len(connections) > maxConnections {
+		connections = connections[:maxConnections]
+	}
+	return connections, nil
+}
+
+func (fs *FlightSearch) hubsFrom(source string) []string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	hubs := make([]string, 0, len(fs.hubs[source]))
+	for hub := range fs.hubs[source] {
+		hubs = append(hubs, hub)
+	}
+	return hubs
+}
+
+func (ams *AirlineManagementSystem) SearchConnections(source, destination string, date time.Time, minLayover, maxLayover time.Duration) ([]*Connection, error) {
+	return ams.flightSearch.SearchConnections(source, destination, date, minLayover, maxLayover)
+}
+
+// File: errors.go
+var (
+	ErrBookingNotFound   = errors.New("booking not found")
+	ErrDuplicateFlight   = errors.New("flight already exists")
+	ErrAircraftNotFound  = errors.New("aircraft not found")
+	ErrDuplicateAircraft = errors.New("aircraft already exists")
+	ErrInvalidSeatCount  = errors.New("aircraft must have at least one seat")
+	ErrFlightNotFound    = errors.New("flight not found")
+	ErrInvalidSeatNumber = errors.New("invalid seat number")
+	ErrSeatUnavailable   = errors.New("seat already booked")
+	ErrPaymentDeclined   = errors.New("payment declined")
+	ErrDuplicateBooking  = errors.New("booking already exists")
+	ErrStaleVersion      = errors.New("flight seat map has changed")
+	ErrFlightDeparted    = errors.New("flight has already departed")
+	ErrNoSeatsAvailable  = errors.New("no seats available")
+
+	ErrBookingAlreadyCancelled  = errors.New("booking already cancelled")
+	ErrPaymentNotFound          = errors.New("payment not found")
+	ErrPaymentNotRefundable     = errors.New("payment cannot be refunded")
+	ErrInvalidPaymentTransition = errors.New("invalid payment status transition")
+	ErrPaymentMethodRequired    = errors.New("payment method is required")
+	ErrPaymentIDRequired        = errors.New("payment ID is required")
+	ErrInvalidPaymentAmount     = errors.New("payment amount must be positive")
+	ErrDuplicatePayment         = errors.New("payment already exists")
+	ErrSplitTotalMismatch       = errors.New("split payment amounts do not add up to the total")
+	ErrInvalidRefundBand        = errors.New("invalid refund band")
+	ErrInvalidSearchWindow      = errors.New("invalid departure time window")
+	ErrInvalidLayover           = errors.New("invalid layover window")
+	ErrInvalidAirportCode       = errors.New("airport code must be three letters")
+	ErrInvalidPagination        = errors.New("offset and limit must not be negative")
+	ErrInvalidCabinClass        = errors.New("unknown cabin class")
+	ErrInvalidSortKey           = errors.New("unknown sort key")
+	ErrOverlappingRefundBands   = errors.New("refund bands overlap")
+	ErrIdempotencyKeyRequired   = errors.New("idempotency key is required")
+	ErrPaymentNotCompleted      = errors.New("payment has not completed")
+	ErrCardDeclined             = errors.New("card declined")
+	ErrTransactionNotFound      = errors.New("transaction not found")
+	ErrTransientPayment         = errors.New("transient payment failure")
+	ErrInsufficientBalance      = errors.New("insufficient wallet balance")
+	ErrNothingAuthorized        = errors.New("no authorized amount to capture")
+	ErrInvalidRefundAmount      = errors.New("refund amount must be positive")
+	ErrCurrencyMismatch         = errors.New("currency mismatch")
+	ErrInvalidMoney             = errors.New("invalid money amount")
+	ErrRefundExceedsPayment     = errors.New("refund exceeds the remaining refundable amount")
+	ErrInvalidTimezone          = errors.New("timezone location is required")
+	ErrInvalidStatusTransition  = errors.New("invalid status transition")
+	ErrRouteMismatch            = errors.New("flight is on a different route")
+	ErrSameFlight               = errors.New("booking is already on this flight")
+	ErrHoldNotFound             = errors.New("seat hold not found")
+	ErrHoldExpired              = errors.New("seat hold has expired")
+	ErrGroupSizeMismatch        = errors.New("group needs one seat per passenger")
+	ErrGroupNotFound            = errors.New("group booking not found")
+	ErrBookingInGroup           = errors.New("booking belongs to a group booking")
+	ErrInvalidReturnFlight      = errors.New("return flight must depart after the outbound flight arrives")
+	ErrContactChannelRequired   = errors.New("contact needs an email or phone number")
+	ErrFlightNotDeparted        = errors.New("flight has not departed yet")
+	ErrInfantRequiresAdult      = errors.New("infants must be added to an adult booking")
+	ErrNotAnInfant              = errors.New("passenger is not an infant")
+	ErrInfantAlreadyAttached    = errors.New("booking already has a lap infant")
+	ErrLapInfantLimit           = errors.New("flight has reached its lap infant limit")
+	ErrCabinClassMismatch       = errors.New("seat is in a different cabin class")
+	ErrInvalidSeatLabel         = errors.New("invalid seat label")
+	ErrNoAdjacentSeats          = errors.New("no block of adjacent seats available")
+	ErrNoSeatMatchingPreference = errors.New("no free seat matches the preference")
+	ErrSeatNotBooked            = errors.New("seat is not booked")
+	ErrSeatLocked               = errors.New("seat is locked by another session")
+	ErrSeatLockNotHeld          = errors.New("seat lock is not held by this owner")
+	ErrLockOwnerRequired        = errors.New("seat lock needs an owner")
+	ErrSeatBlocked              = errors.New("seat is blocked")
+	ErrSeatNotBlocked           = errors.New("seat is not blocked")
+	ErrIneligibleForExitRow     = errors.New("passenger is not eligible for an exit row seat")
+	ErrCabinFull                = errors.New("no seats left in the cabin")
+	ErrFareNotConfigured        = errors.New("no fare configured for the cabin class")
+	ErrInvalidUpgrade           = errors.New("target cabin is not an upgrade")
+	ErrInvalidFlightTransition  = errors.New("invalid flight status transition")
+	ErrFlightCancelled          = errors.New("flight has been cancelled")
+	ErrInvalidDelay             = errors.New("delay must be positive")
+	ErrFlightHasBookings        = errors.New("flight has active bookings")
+	ErrAircraftInUse            = errors.New("aircraft is assigned to an upcoming flight")
+	ErrAircraftTooSmall         = errors.New("aircraft cannot seat every booking")
+	ErrInvalidMaintenanceWindow = errors.New("maintenance window must end after it starts")
+	ErrMaintenanceOverlap       = errors.New("maintenance window overlaps another")
+	ErrAircraftInMaintenance    = errors.New("aircraft is in maintenance")
+	ErrFlightNumberRequired     = errors.New("flight number is required")
+	ErrAircraftRequired         = errors.New("aircraft is required")
+	ErrSameSourceDestination    = errors.New("source and destination are the same airport")
+	ErrFlightTimesRequired      = errors.New("departure and arrival times are required")
+	ErrArrivalBeforeDeparture   = errors.New("arrival must be after departure")
+	ErrInvalidSchedule          = errors.New("invalid flight schedule")
+	ErrUnknownAircraftModel     = errors.New("unknown aircraft model")
+	ErrModelNameRequired        = errors.New("aircraft model name is required")
+	ErrAircraftConflict         = errors.New("aircraft is already committed elsewhere")
+	ErrGateRequired             = errors.New("terminal and gate are required")
+	ErrCodeshareConflict        = errors.New("codeshare number is already in use")
+	ErrPassengerIDRequired      = errors.New("passenger ID is required")
+	ErrPassengerNotFound        = errors.New("passenger not found")
+	ErrDuplicatePassenger       = errors.New("passenger is already registered")
+	ErrPassengerNameRequired    = errors.New("passenger name is required")
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrInvalidPhone             = errors.New("invalid phone number")
+	ErrPassengerIDImmutable     = errors.New("passenger ID cannot be changed")
+	ErrAlreadyEnrolled          = errors.New("passenger is already enrolled")
+	ErrNotEnrolled              = errors.New("passenger is not enrolled in the frequent flyer program")
+	ErrInvalidDistance          = errors.New("route distance must be positive")
+	ErrInsufficientMiles        = errors.New("insufficient miles")
+	ErrInvalidConversionRate    = errors.New("miles conversion rate must be positive")
+	ErrPassengerHasBookings     = errors.New("passenger has upcoming bookings")
+	ErrTravelDocumentRequired   = errors.New("travel document is required for international flights")
+	ErrTravelDocumentExpiring   = errors.New("travel document expires too soon")
+	ErrUnknownSSR               = errors.New("unknown special service request code")
+	ErrSSRNotAllowed            = errors.New("special service request not allowed for this booking")
+	ErrUnaccompaniedMinorForm   = errors.New("unaccompanied minor form is required")
+	ErrMinorNotAccepted         = errors.New("flight does not accept unaccompanied minors")
+	ErrInvalidCheckInWindow     = errors.New("check-in must open before it closes")
+	ErrCheckInNotOpen           = errors.New("check-in is not open yet")
+	ErrCheckInClosed            = errors.New("check-in has closed")
+	ErrAlreadyCheckedIn         = errors.New("booking is already checked in")
+	ErrAirportCheckInRequired   = errors.New("booking must be checked in at the airport")
+	ErrNotCheckedIn             = errors.New("booking is not checked in")
+	ErrInvalidBarcode           = errors.New("invalid boarding pass barcode")
+	ErrInvalidBoardingPolicy    = errors.New("boarding policy needs at least one zone")
+	ErrBookingLocked            = errors.New("booking is checked in and cannot be changed")
+	ErrFlightNotFull            = errors.New("flight still has seats available")
+	ErrAlreadyOnStandby         = errors.New("passenger is already on the standby list")
+	ErrInvalidBaggage           = errors.New("invalid baggage")
+	ErrBaggageLimit             = errors.New("booking has reached its baggage piece limit")
+	ErrInvalidBaggageAllowance  = errors.New("invalid baggage allowance")
+	ErrBaggageNotFound          = errors.New("baggage tag not found")
+	ErrInvalidBaggageTransition = errors.New("invalid baggage status transition")
+	ErrPassengerRequired        = errors.New("passenger is required")
+	ErrBookingNotChangeable     = errors.New("booking can no longer be changed")
+	ErrInvalidTaxRate           = errors.New("tax rate must be between 0 and 100 percent")
+	ErrPaymentInProgress        = errors.New("payment is still being processed")
+	ErrUnsupportedGroupOption   = errors.New("option is not supported for group bookings")
+)
+
+// File: fleet_utilization.go
+type FleetUtilizationReport struct {
+	From     time.Time
+	To       time.Time
+	Aircraft []AircraftUtilization
+}
+
+// AircraftUtilization covers the flights departing in the report range.
+// BlockTime sums arrival minus departure and IdleGaps lists the time between
+// them, including before the first and after the last.
+type AircraftUtilization struct {
+	TailNumber string
+	Flights    int
+	BlockTime  time.Duration
+	IdleGaps   []IdleGap
+}
+
+type IdleGap struct {
+	From time.Time
+	To   time.Time
+}
+
+// GetFlightsForAircraft returns copies of the aircraft's flights departing
+// in [from, to), earliest first. Cancelled flights are left out.
+func (ams *AirlineManagementSystem) GetFlightsForAircraft(tailNumber string, from, to time.Time) []*Flight {
+	_, flights := ams.fleetSnapshot()
+	results := flightsForAircraft(flights, tailNumber, from, to)
+	for i, flight := range results {
+		results[i] = flight.clone()
+	}
+	return results
+}
+
+// FleetUtilization reports every aircraft's use over [from, to), busiest
+// first.
+func (ams *AirlineManagementSystem) FleetUtilization(from, to time.Time) *FleetUtilizationReport {
+	aircrafts, flights := ams.fleetSnapshot()
+	report := &FleetUtilizationReport{From: from, To: to, Aircraft: make([]AircraftUtilization, 0, len(aircrafts))}
+	for _, aircraft := range aircrafts {
+		usage := AircraftUtilization{TailNumber: aircraft.TailNumber, IdleGaps: make([]IdleGap, 0)}
+		idleSince := from
+		for _, flight := range flightsForAircraft(flights, aircraft.TailNumber, from, to) {
+			departure, arrival := flight.schedule()
+			usage.Flights++
+			usage.BlockTime += arrival.Sub(departure)
+			if departure.After(idleSince) {
+				usage.IdleGaps = append(usage.IdleGaps, IdleGap{From: idleSince, To: departure})
+			}
+			if arrival.After(idleSince) {
+				idleSince = arrival
+			}
+		}
+		if to.After(idleSince) {
+			usage.IdleGaps = append(usage.IdleGaps, IdleGap{From: idleSince, To: to})
+		}
+		report.Aircraft = append(report.Aircraft, usage)
+	}
+	sort.SliceStable(report.Aircraft, func(i, j int) bool {
+		return report.Aircraft[i].BlockTime > report.Aircraft[j].BlockTime
+	})
+	return report
+}
+
+// fleetSnapshot copies the fleet and flight lists so reports can be built
+// without holding the system lock.
+func (ams *AirlineManagementSystem) fleetSnapshot() ([]*Aircraft, []*Flight) {
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	return append([]*Aircraft(nil), ams.aircrafts...), append([]*Flight(nil), ams.flights...)
+}
+
+func flightsForAircraft(flights []*Flight, tailNumber string, from, to time.Time) []*Flight {
+	results := make([]*Flight, 0)
+	for _, flight := range flights {
+		if flight.Aircraft.TailNumber != tailNumber || flight.CurrentStatus() == FlightCancelled {
+			continue
+		}
+		if departure := flight.departureTime(); !departure.Before(from) && departure.Before(to) {
+			results = append(results, flight)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].departureTime().Before(results[j].departureTime())
+	})
+	return results
+}
+
+// File: flight.go
+type Flight struct {
+	FlightNumber string
+	Source       string
+	Destination  string
+	// Departure and Arrival are the published schedule. Delays move the
+	// estimated times instead.
+	Departure time.Time
+	Arrival   time.Time
+	Aircraft  *Aircraft
+	Seats     []*Seat
+	Fare      Money
+	Fares     map[CabinClass]Money
+	// RefundRules and ClassRefundRules override the system refund rules for
+	// bookings on this flight.
+	RefundRules      *RefundRules
+	ClassRefundRules map[CabinClass]*RefundRules
+	MaxLapInfants    int
+	Status           FlightStatus
+	StatusHistory    []FlightStatusChange
+	Terminal         string
+	Gate             string
+	GateChanges      []GateChange
+	// International flights need every passenger to carry a valid passport.
+	International bool
+	// RefuseMinors turns away children travelling alone, e.g. on the last
+	// departure of the day.
+	RefuseMinors bool
+	// CheckInOpens and CheckInCloses are measured back from the scheduled
+	// departure. Zero uses the system defaults.
+	CheckInOpens  time.Duration
+	CheckInCloses time.Duration
+	// TaxPercent is levied on the fare and seat fee of every booking.
+	TaxPercent float64
+	// Codeshares lists the other numbers the flight is sold under.
+	Codeshares         []string
+	estimatedDeparture time.Time
+	estimatedArrival   time.Time
+	actualDeparture    time.Time
+	actualArrival      time.Time
+	checkInClosed      bool
+	standby            []StandbyEntry
+	lapInfants         int
+	locks              map[int]seatLock
+	now                func() time.Time
+	version            int
+	mu                 sync.Mutex
+}
+
+const defaultMaxLapInfants = 10
+
+func NewFlight(flightNumber, source, destination string, departure, arrival time.Time, aircraft *Aircraft, opts ...FlightOption) (*Flight, error) {
+	source, destination = normalizeAirportCode(source), normalizeAirportCode(destination)
+	switch {
+	case strings.TrimSpace(flightNumber) == "":
+		return nil, ErrFlightNumberRequired
+	case aircraft == nil:
+		return nil, ErrAircraftRequired
+	case source == destination:
+		return nil, fmt.Errorf("%w: %s", ErrSameSourceDestination, source)
+	case departure.IsZero() || arrival.IsZero():
+		return nil, ErrFlightTimesRequired
+	case !arrival.After(departure):
+		return nil, fmt.Errorf("%w: %s departs %s, arrives %s", ErrArrivalBeforeDeparture, flightNumber,
+			departure.Format(time.RFC3339), arrival.Format(time.RFC3339))
+	}
+	flight := &Flight{
+		FlightNumber:  flightNumber,
+		Source:        source,
+		Destination:   destination,
+		Departure:     departure,
+		Arrival:       arrival,
+		Aircraft:      aircraft,
+		Seats:         buildSeats(aircraft),
+		MaxLapInfants: defaultMaxLapInfants,
+		locks:         make(map[int]seatLock),
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(flight)
+	}
+	if opens, closes := flight.checkInWindow(); opens <= closes || closes < 0 {
+		return nil, fmt.Errorf("%w: opens %s, closes %s before departure", ErrInvalidCheckInWindow, opens, closes)
+	}
+	if flight.TaxPercent < 0 || flight.TaxPercent > 100 {
+		return nil, fmt.Errorf("%w: %.2f%%", ErrInvalidTaxRate, flight.TaxPercent)
+	}
+	return flight, nil
+}
+
+// buildSeats lays out the aircraft's cabins front to back, numbering seats
+// from 1.
+func buildSeats(aircraft *Aircraft) []*Seat {
+	seats := make([]*Seat, 0, aircraft.TotalSeats)
+	row := 0
+	for _, cabin := range aircraft.cabinLayouts() {
+		layout := aircraft.columnsFor(cabin)
+		columns := strings.ReplaceAll(layout, " ", "")
+		blocks := columnBlocks(layout)
+		positions := columnPositions(blocks)
+		for i := 0; i < cabin.Seats; i++ {
+			if i%len(columns) == 0 {
+				row++
+			}
+			seats = append(seats, &Seat{
+				SeatNumber:  len(seats) + 1,
+				Row:         row,
+				Column:      string(columns[i%len(columns)]),
+				Class:       cabin.Class,
+				IsBooked:    false,
+				Position:    positions[i%len(columns)],
+				columnIndex: i % len(columns),
+				block:       blocks[i%len(columns)],
+			})
+		}
+	}
+	for _, seat := range seats {
+		for _, exitRow := range aircraft.ExitRows {
+			if seat.Row == exitRow {
+				seat.ExitRow = true
+			}
+		}
+	}
+	return seats
+}
+
+type FlightOption func(*Flight)
+
+// WithFare sets the fare for one cabin class. Economy falls back to
+// Flight.Fare when no class fare is set.
+func WithFare(class CabinClass, fare Money) FlightOption {
+	return func(f *Flight) {
+		if f.Fares == nil {
+			f.Fares = make(map[CabinClass]Money)
+		}
+		f.Fares[class] = fare
+	}
+}
+
+// International marks the flight as crossing a border.
+func International() FlightOption {
+	return func(f *Flight) {
+		f.International = true
+	}
+}
+
+// RefuseUnaccompaniedMinors turns away children travelling without an adult.
+func RefuseUnaccompaniedMinors() FlightOption {
+	return func(f *Flight) {
+		f.RefuseMinors = true
+	}
+}
+
+// WithTaxPercent levies tax on the fare and seat fee, e.g. 5 for 5% GST.
+func WithTaxPercent(percent float64) FlightOption {
+	return func(f *Flight) {
+		f.TaxPercent = percent
+	}
+}
+
+// WithRowSurcharge charges extra for every seat in the row, e.g. exit rows
+// or extra-legroom front rows.
+func WithRowSurcharge(row int, surcharge Money) FlightOption {
+	return func(f *Flight) {
+		for _, seat := range f.Seats {
+			if seat.Row == row {
+				seat.Surcharge = surcharge
+			}
+		}
+	}
+}
+
+func (f *Flight) BookSeat(seatNumber int) error {
+	return f.reserveSeat(seatNumber)
+}
+
+// BookSeatByLabel books a seat by its row/letter label, e.g. "12A".
+func (f *Flight) BookSeatByLabel(label string) error {
+	seatNumber, err := f.SeatNumberForLabel(label)
+	if err != nil {
+		return err
+	}
+	return f.reserveSeat(seatNumber)
+}
+
+func (f *Flight) SeatNumberForLabel(label string) (int, error) {
+	row, column, err := parseSeatLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, seat := range f.Seats {
+		if seat.Row == row && seat.Column == column {
+			return seat.SeatNumber, nil
+		}
+	}
+	return 0, ErrInvalidSeatLabel
+}
+
+// BookSeatIfVersion books the seat only if the seat map has not changed
+// since the caller observed expectedVersion.
+func (f *Flight) BookSeatIfVersion(seatNumber, expectedVersion int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.version != expectedVersion {
+		return ErrStaleVersion
+	}
+	return f.reserveSeatLocked(seatNumber)
+}
+
+// clone returns a deep copy of the flight so callers outside the system
+// cannot mutate shared seat state.
+func (f *Flight) clone() *Flight {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seats := make([]*Seat, len(f.Seats))
+	for i, seat := range f.Seats {
+		seatCopy := *seat
+		seats[i] = &seatCopy
+	}
+	var fares map[CabinClass]Money
+	if f.Fares != nil {
+		fares = make(map[CabinClass]Money, len(f.Fares))
+		for class, fare := range f.Fares {
+			fares[class] = fare
+		}
+	}
+	var classRules map[CabinClass]*RefundRules
+	if f.ClassRefundRules != nil {
+		classRules = make(map[CabinClass]*RefundRules, len(f.ClassRefundRules))
+		for class, rules := range f.ClassRefundRules {
+			classRules[class] = rules.clone()
+		}
+	}
+	return &Flight{
+		FlightNumber:       f.FlightNumber,
+		Source:             f.Source,
+		Destination:        f.Destination,
+		Departure:          f.Departure,
+		Arrival:            f.Arrival,
+		Aircraft:           f.Aircraft.clone(),
+		Seats:              seats,
+		Fare:               f.Fare,
+		Fares:              fares,
+		RefundRules:        f.RefundRules.clone(),
+		ClassRefundRules:   classRules,
+		MaxLapInfants:      f.MaxLapInfants,
+		Status:             f.Status,
+		StatusHistory:      append([]FlightStatusChange(nil), f.StatusHistory...),
+		Terminal:           f.Terminal,
+		Gate:               f.Gate,
+		GateChanges:        append([]GateChange(nil), f.GateChanges...),
+		Codeshares:         append([]string(nil), f.Codeshares...),
+		International:      f.International,
+		RefuseMinors:       f.RefuseMinors,
+		CheckInOpens:       f.CheckInOpens,
+		CheckInCloses:      f.CheckInCloses,
+		TaxPercent:         f.TaxPercent,
+		estimatedDeparture: f.estimatedDeparture,
+		estimatedArrival:   f.estimatedArrival,
+		actualDeparture:    f.actualDeparture,
+		actualArrival:      f.actualArrival,
+		checkInClosed:      f.checkInClosed,
+		standby:            append([]StandbyEntry(nil), f.standby...),
+		lapInfants:         f.lapInfants,
+		locks:              make(map[int]seatLock),
+		now:                f.now,
+		version:            f.version,
+	}
+}
+
+func (f *Flight) Version() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.version
+}
+
+func (f *Flight) reserveSeat(seatNumber int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reserveSeatLocked(seatNumber)
+}
+
+func (f *Flight) reserveSeatLocked(seatNumber int) error {
+	return f.reserveSeatAsLocked(seatNumber, "")
+}
+
+// reserveSeatAsLocked books the seat on behalf of owner. A seat soft-locked
+// by someone else is refused; the owner's own lock is consumed.
+func (f *Flight) reserveSeatAsLocked(seatNumber int, owner string) error {
+	if seatNumber < 1 || seatNumber > len(f.Seats) {
+		return ErrInvalidSeatNumber
+	}
+	if f.Seats[seatNumber-1].Blocked {
+		return ErrSeatBlocked
+	}
+	if f.Seats[seatNumber-1].IsBooked {
+		return ErrSeatUnavailable
+	}
+	if f.lockedByOtherLocked(seatNumber, owner) {
+		return ErrSeatLocked
+	}
+	delete(f.locks, seatNumber)
+	f.Seats[seatNumber-1].IsBooked = true
+	f.version++
+	return nil
+}
+
+// reserveAnySeat books the first free seat accepted by match, or any free
+// seat when match is nil.
+func (f *Flight) reserveAnySeat(match func(*Seat) bool) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, seat := range f.Seats {
+		if f.assignableLocked(seat) && (match == nil || match(seat)) {
+			seat.IsBooked = true
+			f.version++
+			return seat.SeatNumber, nil
+		}
+	}
+	return 0, ErrNoSeatsAvailable
+}
+
+// findAnySeat is reserveAnySeat without the reservation, used for quotes.
+func (f *Flight) findAnySeat(match func(*Seat) bool) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, seat := range f.Seats {
+		if f.assignableLocked(seat) && (match == nil || match(seat)) {
+			return seat.SeatNumber, nil
+		}
+	}
+	return 0, ErrNoSeatsAvailable
+}
+
+func (f *Flight) seatSurcharge(seatNumber int) (Money, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seatNumber < 1 || seatNumber > len(f.Seats) {
+		return Money{}, ErrInvalidSeatNumber
+	}
+	return f.Seats[seatNumber-1].Surcharge, nil
+}
+
+func (f *Flight) swapSeat(oldSeat, newSeat int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reserveSeatLocked(newSeat); err != nil {
+		return err
+	}
+	if err := f.releaseSeatLocked(oldSeat); err != nil {
+		f.releaseSeatLocked(newSeat)
+		return err
+	}
+	return nil
+}
+
+// reserveSeats books every requested seat or none of them.
+func (f *Flight) reserveSeats(seatNumbers []int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, seatNumber := range seatNumbers {
+		if err := f.reserveSeatLocked(seatNumber); err != nil {
+			for _, reserved := range seatNumbers[:i] {
+				f.releaseSeatLocked(reserved)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Flight) seatClass(seatNumber int) (CabinClass, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seatNumber < 1 || seatNumber > len(f.Seats) {
+		return 0, ErrInvalidSeatNumber
+	}
+	return f.Seats[seatNumber-1].Class, nil
+}
+
+// FindAdjacentSeats returns n free seats side by side in the same row,
+// preferring rows nearer the front. Runs that cross an aisle are only
+// considered when allowAcrossAisle is set.
+func (f *Flight) FindAdjacentSeats(n int, allowAcrossAisle bool) ([]*Seat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	run, err := f.findAdjacentLocked(n, allowAcrossAisle, nil)
+	if err != nil {
+		return nil, err
+	}
+	seats := make([]*Seat, len(run))
+	for i, seat := range run {
+		seatCopy := *seat
+		seats[i] = &seatCopy
+	}
+	return seats, nil
+}
+
+// reserveAdjacentSeats finds and books n adjacent seats accepted by match in
+// one step.
+func (f *Flight) reserveAdjacentSeats(n int, allowAcrossAisle bool, match func(*Seat) bool) ([]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	run, err := f.findAdjacentLocked(n, allowAcrossAisle, match)
+	if err != nil {
+		return nil, err
+	}
+	seatNumbers := make([]int, len(run))
+	for i, seat := range run {
+		seat.IsBooked = true
+		seatNumbers[i] = seat.SeatNumber
+	}
+	f.version++
+	return seatNumbers, nil
+}
+
+func (f *Flight) findAdjacentLocked(n int, allowAcrossAisle bool, match func(*Seat) bool) ([]*Seat, error) {
+	if n < 1 {
+		return nil, ErrInvalidSeatNumber
+	}
+	run := make([]*Seat, 0, n)
+	for _, seat := range f.Seats {
+		if len(run) > 0 {
+			last := run[len(run)-1]
+			if seat.Row != last.Row || seat.columnIndex != last.columnIndex+1 ||
+				(!allowAcrossAisle && seat.block != last.block) {
+				run = run[:0]
+			}
+		}
+		if !f.assignableLocked(seat) || (match != nil && !match(seat)) {
+			run = run[:0]
+			continue
+		}
+		run = append(run, seat)
+		if len(run) == n {
+			return run, nil
+		}
+	}
+	return nil, ErrNoAdjacentSeats
+}
+
+// FindSeatByPreference returns a copy of the first free seat in the
+// preferred position, falling back to any free seat unless pref is strict.
+func (f *Flight) FindSeatByPreference(pref SeatPreference) (*Seat, error) {
+	seatNumber, err := assignSeat(bookingOptions{preference: &pref, allowPaidSeat: true}, f.findAnySeat)
+	if errors.Is(err, ErrNoSeatsAvailable) {
+		return nil, ErrNoSeatMatchingPreference
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seatCopy := *f.Seats[seatNumber-1]
+	return &seatCopy, nil
+}
+
+// AutoAssignSeat books the first free seat that satisfies pref, skipping
+// blocked, held and locked seats and seats that carry a surcharge. Finding
+// and booking happen under one lock so concurrent callers never get the same
+// seat. A nil pref accepts any seat.
+func (f *Flight) AutoAssignSeat(pref *SeatPreference) (int, error) {
+	return assignSeat(bookingOptions{preference: pref}, f.reserveAnySeat)
+}
+
+// FareFor returns the fare for a cabin class and whether one is configured.
+// Fare stands in for an economy fare that is not listed, but only if it is
+// set.
+func (f *Flight) FareFor(class CabinClass) (Money, bool) {
+	if fare, ok := f.Fares[class]; ok {
+		return fare, true
+	}
+	if class == Economy && !f.Fare.IsZero() {
+		return f.Fare, true
+	}
+	return Money{}, false
+}
+
+func (f *Flight) isExitRow(seatNumber int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seatNumber < 1 || seatNumber > len(f.Seats) {
+		return false, ErrInvalidSeatNumber
+	}
+	return f.Seats[seatNumber-1].ExitRow, nil
+}
+
+func (f *Flight) AvailableSeatCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, seat := range f.Seats {
+		if !seat.IsBooked && !seat.Blocked {
+			count++
+		}
+	}
+	return count
+}
+
+// AvailableSeats returns copies of the free seats so callers cannot change
+// seat state directly.
+func (f *Flight) AvailableSeats() []*Seat {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seats := make([]*Seat, 0)
+	for _, seat := range f.Seats {
+		if !seat.IsBooked && !seat.Blocked {
+			seatCopy := *seat
+			seats = append(seats, &seatCopy)
+		}
+	}
+	return seats
+}
+
+func (f *Flight) AvailableSeatsByClass() map[CabinClass]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	available := make(map[CabinClass]int)
+	for _, seat := range f.Seats {
+		if !seat.IsBooked && !seat.Blocked {
+			available[seat.Class]++
+		}
+	}
+	return available
+}
+
+// reserveLapInfant claims one of the flight's lap-infant slots. Lap infants
+// travel on an adult's seat and do not consume a Seat of their own.
+func (f *Flight) reserveLapInfant() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lapInfants >= f.MaxLapInfants {
+		return ErrLapInfantLimit
+	}
+	f.lapInfants++
+	return nil
+}
+
+func (f *Flight) releaseLapInfant() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lapInfants > 0 {
+		f.lapInfants--
+	}
+}
+
+func (f *Flight) LapInfantCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lapInfants
+}
+
+func (f *Flight) releaseSeats(seatNumbers []int) {
+	for _, seatNumber := range seatNumbers {
+		f.ReleaseSeat(seatNumber)
+	}
+}
+
+// ReleaseSeat is the inverse of BookSeat. It fails if the seat does not
+// exist or is not currently booked.
+func (f *Flight) ReleaseSeat(seatNumber int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.releaseSeatLocked(seatNumber)
+}
+
+func (f *Flight) releaseSeatLocked(seatNumber int) error {
+	if seatNumber < 1 || seatNumber > len(f.Seats) {
+		return ErrInvalidSeatNumber
+	}
+	if !f.Seats[seatNumber-1].IsBooked {
+		return ErrSeatNotBooked
+	}
+	f.Seats[seatNumber-1].IsBooked = false
+	f.Seats[seatNumber-1].Held = false
+	f.version++
+	return nil
+}
+
+// File: flight_cancellation.go
+type CancellationReport struct {
+	FlightNumber string
+	Cancelled    []string
+	Refunded     Money
+	// Failures holds bookings that need manual follow-up, keyed by booking ID.
+	Failures map[string]error
+}
+
+// CancelFlight scrubs the flight: it stops taking bookings and drops out of
+// search, and every active booking, checked in or not, is cancelled with a
+// full refund whatever the refund rules say. A booking that fails is
+// reported and the rest still go ahead.
+func (ams *AirlineManagementSystem) CancelFlight(flightNumber string, reason string) (*CancellationReport, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	if err := flight.SetStatus(FlightCancelled); err != nil {
+		return nil, err
+	}
+	report := &CancellationReport{
+		FlightNumber: flightNumber,
+		Cancelled:    make([]string, 0),
+		Failures:     make(map[string]error),
+	}
+	ams.bookingManager.recordFlightEvent(flight, ActionFlightCancelled, reason)
+	for _, booking := range ams.bookingManager.activeBookingsOn(flight) {
+		before := ams.paymentProcessor.refundedFor(booking.charges())
+		err := ams.scrubBooking(booking)
+		after := ams.paymentProcessor.refundedFor(booking.charges())
+		if refunded, subErr := after.Sub(before); subErr == nil {
+			if total, addErr := report.Refunded.Add(refunded); addErr == nil {
+				report.Refunded = total
+			} else {
+				err = errors.Join(err, addErr)
+			}
+		}
+		if booking.Status == BookingCancelled {
+			report.Cancelled = append(report.Cancelled, booking.BookingID)
+		}
+		if err != nil {
+			report.Failures[booking.BookingID] = err
+		}
+	}
+	return report, nil
+}
+
+// File: flight_closeout.go
+type FlightCloseoutSummary struct {
+	FlightNumber string
+	Boarded      int
+	NoShows      int
+}
+
+// CloseFlight reconciles bookings once the flight has departed: checked-in
+// passengers are completed and confirmed passengers who never checked in are
+// marked as no-shows. Completed bookings earn miles for enrolled passengers.
+// Running it again only recomputes the summary. Unless one was recorded, the
+// flight is taken to have left at its estimated time.
+func (ams *AirlineManagementSystem) CloseFlight(flightNumber string, now time.Time) (*FlightCloseoutSummary, error) {
+	flight, err := ams.findDepartedFlight(flightNumber, now)
+	if err != nil {
+		return nil, err
+	}
+	flight.mu.Lock()
+	if flight.actualDeparture.IsZero() {
+		flight.actualDeparture = flight.estimatedDepartureLocked()
+	}
+	flight.mu.Unlock()
+	summary, released := ams.bookingManager.closeFlight(flight)
+	flight.releaseSeats(released)
+	ams.creditMiles(flight)
+	return summary, nil
+}
+
+// findDepartedFlight returns the latest departure of the flight number at or
+// before now.
+func (ams *AirlineManagementSystem) findDepartedFlight(flightNumber string, now time.Time) (*Flight, error) {
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	numbered := ams.byNumber[flightNumber]
+	if len(numbered) == 0 {
+		return nil, ErrFlightNotFound
+	}
+	var latest *Flight
+	for _, flight := range numbered {
+		departure := flight.departureTime()
+		if !departure.After(now) && (latest == nil || departure.After(latest.departureTime())) {
+			latest = flight
+		}
+	}
+	if latest == nil {
+		return nil, ErrFlightNotDeparted
+	}
+	return latest, nil
+}
+
+// closeFlight settles the flight's bookings and returns the seats held by
+// newly detected no-shows so the caller can release them.
+func (bm *BookingManager) closeFlight(flight *Flight) (*FlightCloseoutSummary, []int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	summary := &FlightCloseoutSummary{FlightNumber: flight.FlightNumber}
+	released := make([]int, 0)
+	for _, booking := range bm.byFlight[flight.FlightNumber] {
+		if booking.Flight != flight {
+			continue
+		}
+		switch booking.Status {
+		case BookingCheckedIn:
+			bm.recordLocked(booking, ActionFlightClosed, fmt.Sprintf("%s -> %s", booking.Status, BookingCompleted))
+			booking.Status = BookingCompleted
+		case BookingConfirmed:
+			bm.recordLocked(booking, ActionFlightClosed, fmt.Sprintf("%s -> %s", booking.Status, BookingNoShow))
+			booking.Status = BookingNoShow
+			released = append(released, booking.SeatNumber)
+		}
+		switch booking.Status {
+		case BookingCompleted:
+			summary.Boarded++
+		case BookingNoShow:
+			summary.NoShows++
+		}
+	}
+	return summary, released
+}
+
+// File: flight_status.go
+type FlightStatus int
+
+const (
+	FlightScheduled FlightStatus = iota
+	FlightBoarding
+	FlightDelayed
+	FlightDeparted
+	FlightArrived
+	FlightCancelled
+)
+
+var flightTransitions = map[FlightStatus][]FlightStatus{
+	FlightScheduled: {FlightBoarding, FlightDelayed, FlightCancelled},
+	FlightDelayed:   {FlightBoarding, FlightCancelled},
+	FlightBoarding:  {FlightDeparted, FlightDelayed, FlightCancelled},
+	FlightDeparted:  {FlightArrived},
+}
+
+func (s FlightStatus) String() string {
+	switch s {
+	case FlightScheduled:
+		return "Scheduled"
+	case FlightBoarding:
+		return "Boarding"
+	case FlightDelayed:
+		return "Delayed"
+	case FlightDeparted:
+		return "Departed"
+	case FlightArrived:
+		return "Arrived"
+	case FlightCancelled:
+		return "Cancelled"
+	}
+	return fmt.Sprintf("FlightStatus(%d)", int(s))
+}
+
+// Transition returns next if moving from s to next is a legal step in the
+// flight lifecycle.
+func (s FlightStatus) Transition(next FlightStatus) (FlightStatus, error) {
+	for _, allowed := range flightTransitions[s] {
+		if allowed == next {
+			return next, nil
+		}
+	}
+	return s, fmt.Errorf("%w: %s -> %s", ErrInvalidFlightTransition, s, next)
+}
+
+type FlightStatusChange struct {
+	From FlightStatus
+	To   FlightStatus
+	At   time.Time
+}
+
+// SetStatus moves the flight to status and records when it happened.
+func (f *Flight) SetStatus(status FlightStatus) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.setStatusLocked(status)
+}
+
+func (f *Flight) setStatusLocked(status FlightStatus) error {
+	next, err := f.Status.Transition(status)
+	if err != nil {
+		return err
+	}
+	f.StatusHistory = append(f.StatusHistory, FlightStatusChange{From: f.Status, To: next, At: f.now()})
+	f.Status = next
+	return nil
+}
+
+func (f *Flight) CurrentStatus() FlightStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Status
+}
+
+func (ams *AirlineManagementSystem) SetFlightStatus(flightNumber string, status FlightStatus) error {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return err
+	}
+	return flight.SetStatus(status)
+}
+
+// GetFlightStatus returns the flight's status and the changes that led to it,
+// oldest first.
+func (ams *AirlineManagementSystem) GetFlightStatus(flightNumber string) (FlightStatus, []FlightStatusChange, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return FlightScheduled, nil, err
+	}
+	flight.mu.Lock()
+	defer flight.mu.Unlock()
+	return flight.Status, append([]FlightStatusChange(nil), flight.StatusHistory...), nil
+}
+
+// File: flight_delay.go
+// DelayFlight pushes the flight back by delay and notes it on every active
+// booking. Repeated delays add up against the original schedule.
+func (ams *AirlineManagementSystem) DelayFlight(flightNumber string, delay time.Duration, reason string) error {
+	if delay <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidDelay, delay)
+	}
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return err
+	}
+	departure, arrival := flight.schedule()
+	ams.mu.RLock()
+	err = ams.checkMaintenanceLocked(flight.Aircraft.TailNumber, departure.Add(delay), arrival.Add(delay))
+	ams.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	total, err := flight.delay(delay, ams.clock())
+	if err != nil {
+		return err
+	}
+	detail := fmt.Sprintf("delayed %s (%s in total)", delay, total)
+	if reason != "" {
+		detail += ": " + reason
+	}
+	ams.bookingManager.recordFlightEvent(flight, ActionFlightDelayed, detail)
+	return nil
+}
+
+// delay moves the estimated times back and returns the total delay against
+// the schedule.
+func (f *Flight) delay(delay time.Duration, now time.Time) (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch f.Status {
+	case FlightCancelled:
+		return 0, ErrFlightCancelled
+	case FlightDeparted, FlightArrived:
+		return 0, ErrFlightDeparted
+	}
+	if !f.actualDeparture.IsZero() || !f.estimatedDepartureLocked().After(now) {
+		return 0, ErrFlightDeparted
+	}
+	if f.Status != FlightDelayed {
+		if err := f.setStatusLocked(FlightDelayed); err != nil {
+			return 0, err
+		}
+	}
+	f.estimatedDeparture = f.estimatedDepartureLocked().Add(delay)
+	f.estimatedArrival = f.estimatedArrivalLocked().Add(delay)
+	return f.estimatedDeparture.Sub(f.Departure), nil
+}
+
+// TotalDelay is how far the estimated departure has moved from the schedule.
+func (f *Flight) TotalDelay() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.estimatedDepartureLocked().Sub(f.Departure)
+}
+
+func (bm *BookingManager) recordFlightEvent(flight *Flight, action BookingAction, detail string) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	for _, booking := range bm.byFlight[flight.FlightNumber] {
+		if booking.Flight == flight && booking.Status != BookingCancelled {
+			bm.recordLocked(booking, action, detail)
+		}
+	}
+}
+
+// activeBookingsOn is GetBookingsByFlight for one departure of a flight
+// number that operates on several days.
+func (bm *BookingManager) activeBookingsOn(flight *Flight) []*Booking {
+	return bm.GetBookingsByFlight(flight.FlightNumber, onFlight(flight))
+}
+
+// File: flight_times.go
+// onTimeTolerance is how late a flight may leave and still count as on time.
+const onTimeTolerance = 15 * time.Minute
+
+func (f *Flight) ScheduledDeparture() time.Time {
+	return f.Departure
+}
+
+func (f *Flight) ScheduledArrival() time.Time {
+	return f.Arrival
+}
+
+// EstimatedDeparture is the schedule moved by any delays.
+func (f *Flight) EstimatedDeparture() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.estimatedDepartureLocked()
+}
+
+func (f *Flight) EstimatedArrival() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.estimatedArrivalLocked()
+}
+
+// ActualDeparture reports the recorded off-block time, if any.
+func (f *Flight) ActualDeparture() (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.actualDeparture, !f.actualDeparture.IsZero()
+}
+
+func (f *Flight) ActualArrival() (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.actualArrival, !f.actualArrival.IsZero()
+}
+
+func (f *Flight) estimatedDepartureLocked() time.Time {
+	if f.estimatedDeparture.IsZero() {
+		return f.Departure
+	}
+	return f.estimatedDeparture
+}
+
+func (f *Flight) estimatedArrivalLocked() time.Time {
+	if f.estimatedArrival.IsZero() {
+		return f.Arrival
+	}
+	return f.estimatedArrival
+}
+
+// departureTime is when the flight left, or is expected to leave.
+func (f *Flight) departureTime() time.Time {
+	departure, _ := f.schedule()
+	return departure
+}
+
+// schedule returns the best known departure and arrival: actual, then
+// estimated, then scheduled.
+func (f *Flight) schedule() (departure, arrival time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	departure, arrival = f.actualDeparture, f.actualArrival
+	if departure.IsZero() {
+		departure = f.estimatedDepartureLocked()
+	}
+	if arrival.IsZero() {
+		arrival = f.estimatedArrivalLocked()
+	}
+	return departure, arrival
+}
+
+// RecordDeparture sets the actual off-block time of the flight departing
+// closest to at.
+func (ams *AirlineManagementSystem) RecordDeparture(flightNumber string, at time.Time) error {
+	flight, err := ams.findFlightNear(flightNumber, at)
+	if err != nil {
+		return err
+	}
+	flight.mu.Lock()
+	defer flight.mu.Unlock()
+	if flight.Status == FlightCancelled {
+		return ErrFlightCancelled
+	}
+	flight.actualDeparture = at
+	return nil
+}
+
+// RecordArrival sets the actual on-block time. The departure must have been
+// recorded first.
+func (ams *AirlineManagementSystem) RecordArrival(flightNumber string, at time.Time) error {
+	flight, err := ams.findFlightNear(flightNumber, at)
+	if err != nil {
+		return err
+	}
+	flight.mu.Lock()
+	defer flight.mu.Unlock()
+	if flight.actualDeparture.IsZero() {
+		return ErrFlightNotDeparted
+	}
+	if !at.After(flight.actualDeparture) {
+		return ErrArrivalBeforeDeparture
+	}
+	flight.actualArrival = at
+	return nil
+}
+
+// findFlightNear picks, among the departures of a flight number, the one
+// whose estimated departure is closest to at.
+func (ams *AirlineManagementSystem) findFlightNear(flightNumber string, at time.Time) (*Flight, error) {
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	var nearest *Flight
+	var gap time.Duration
+	for _, flight := range ams.byNumber[ams.resolveLocked(flightNumber)] {
+		d := flight.EstimatedDeparture().Sub(at)
+		if d < 0 {
+			d = -d
+		}
+		if nearest == nil || d < gap {
+			nearest, gap = flight, d
+		}
+	}
+	if nearest == nil {
+		return nil, ErrFlightNotFound
+	}
+	return nearest, nil
+}
+
+type OnTimeReport struct {
+	Flights      int
+	OnTime       int
+	AverageDelay time.Duration
+}
+
+// OnTimePerformance compares actual with scheduled departures for flights
+// scheduled in [from, to) that have a recorded departure. Early departures
+// count as no delay.
+func (ams *AirlineManagementSystem) OnTimePerformance(from, to time.Time) OnTimeReport {
+	_, flights := ams.fleetSnapshot()
+	var report OnTimeReport
+	var total time.Duration
+	for _, flight := range flights {
+		if flight.Departure.Before(from) || !flight.Departure.Before(to) {
+			continue
+		}
+		actual, ok := flight.ActualDeparture()
+		if !ok {
+			continue
+		}
+		delay := max(actual.Sub(flight.Departure), 0)
+		report.Flights++
+		total += delay
+		if delay <= onTimeTolerance {
+			report.OnTime++
+		}
+	}
+	if report.Flights > 0 {
+		report.AverageDelay = total / time.Duration(report.Flights)
+	}
+	return report
+}
+
+// File: flight_search.go
+// FlightSearch indexes flights by route so a query only scans the flights
+// flying that route.
+type FlightSearch struct {
+	routes map[routeKey][]*Flight
+	// hubs lists the destinations served from each source, for connections.
+	hubs map[string]map[string]bool
+	// groups maps a metro code such as NYC to the airports it covers.
+	groups map[string][]string
+	// zones holds each airport's local time, so a departure is matched
+	// against the calendar day at the airport it leaves from.
+	zones map[string]*time.Location
+	// codeshares maps normalized marketing numbers to normalized operating
+	// numbers.
+	codeshares map[string]string
+	now        func() time.Time
+	mu         sync.RWMutex
+}
+
+type routeKey struct {
+	source      string
+	destination string
+}
+
+// NewFlightSearch takes the clock searches use to leave out flights that
+// have already departed.
+func NewFlightSearch(now func() time.Time) *FlightSearch {
+	return &FlightSearch{
+		routes:     make(map[routeKey][]*Flight),
+		hubs:       make(map[string]map[string]bool),
+		groups:     make(map[string][]string),
+		zones:      make(map[string]*time.Location),
+		codeshares: make(map[string]string),
+		now:        now,
+	}
+}
+
+func (fs *FlightSearch) addFlight(flight *Flight) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	key := routeKey{flight.Source, flight.Destination}
+	fs.routes[key] = append(fs.routes[key], flight)
+	if fs.hubs[flight.Source] == nil {
+		fs.hubs[flight.Source] = make(map[string]bool)
+	}
+	fs.hubs[flight.Source][flight.Destination] = true
+}
+
+func (fs *FlightSearch) removeFlight(flight *Flight) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	key := routeKey{flight.Source, flight.Destination}
+	flights := fs.routes[key]
+	for i, existing := range flights {
+		if existing == flight {
+			fs.routes[key] = append(flights[:i:i], flights[i+1:]...)
+			break
+		}
+	}
+	if len(fs.routes[key]) == 0 {
+		delete(fs.routes, key)
+		delete(fs.hubs[flight.Source], flight.Destination)
+	}
+}
+
+func (fs *FlightSearch) routeFlights(source, destination string) []*Flight {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	flights := fs.routes[routeKey{normalizeAirportCode(source), normalizeAirportCode(destination)}]
+	return flights[:len(flights):len(flights)]
+}
+
+// SearchFlights returns the route's flights on date that have not departed.
+func (fs *FlightSearch) SearchFlights(source, destination string, date time.Time) []*Flight {
+	return fs.matchRoute(source, destination, date, false)
+}
+
+func (fs *FlightSearch) matchRoute(source, destination string, date time.Time, includePast bool) []*Flight {
+	now := fs.now()
+	results := make([]*Flight, 0)
+	for _, from := range fs.expandAirport(source) {
+		for _, to := range fs.expandAirport(destination) {
+			for _, flight := range fs.routeFlights(from, to) {
+				if !searchable(flight, now, includePast) {
+					continue
+				}
+				if sameDay(fs.localDeparture(flight), date) {
+					results = append(results, flight.clone())
+				}
+			}
+		}
+	}
+	return results
+}
+
+// searchable reports whether a search may offer flight: it has not been
+// cancelled and, unless includePast is set, has not departed by now.
+func searchable(flight *Flight, now time.Time, includePast bool) bool {
+	status := flight.CurrentStatus()
+	if status == FlightCancelled {
+		return false
+	}
+	if includePast {
+		return true
+	}
+	return status != FlightDeparted && status != FlightArrived && flight.departureTime().After(now)
+}
+
+// RegisterAirportGroup lets searches for a metro code such as "NYC" cover
+// each of its airports. Registering a code again replaces its airports.
+func (fs *FlightSearch) RegisterAirportGroup(code string, airports []string) error {
+	group, err := ParseAirportCode(code)
+	if err != nil {
+		return err
+	}
+	members := make([]string, 0, len(airports))
+	seen := make(map[string]bool)
+	for _, airport := range airports {
+		member, err := ParseAirportCode(airport)
+		if err != nil {
+			return err
+		}
+		if !seen[member] {
+			seen[member] = true
+			members = append(members, member)
+		}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.groups[group] = members
+	return nil
+}
+
+// expandAirport returns the airports a code stands for: the group's members,
+// or just the code itself when it is not a group.
+func (fs *FlightSearch) expandAirport(code string) []string {
+	code = normalizeAirportCode(code)
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if members, ok := fs.groups[code]; ok {
+		return members
+	}
+	return []string{code}
+}
+
+// SetAirportTimezone makes date searches match departures from the airport
+// by its local calendar day. Airports without one use the departure time's
+// own location.
+func (fs *FlightSearch) SetAirportTimezone(code string, loc *time.Location) error {
+	airport, err := ParseAirportCode(code)
+	if err != nil {
+		return err
+	}
+	if loc == nil {
+		return fmt.Errorf("%w: airport %s", ErrInvalidTimezone, airport)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.zones[airport] = loc
+	return nil
+}
+
+func (fs *FlightSearch) localDeparture(flight *Flight) time.Time {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.localDepartureLocked(flight)
+}
+
+func (fs *FlightSearch) localDepartureLocked(flight *Flight) time.Time {
+	if loc, ok := fs.zones[flight.Source]; ok {
+		return flight.Departure.In(loc)
+	}
+	return flight.Departure
+}
+
+// SearchCriteria narrows a route and date search. Zero times leave that end
+// of the departure window open. MinAvailableSeats defaults to 1 and counts
+// only seats in Class when it is set.
+type SearchCriteria struct {
+	Source            string
+	Destination       string
+	Date              time.Time
+	DepartAfter       time.Time
+	DepartBefore      time.Time
+	MinAvailableSeats int
+	Class             *CabinClass
+	SortBy            SortKey
+	Descending        bool
+	// Offset and Limit page through the sorted matches; a zero Limit returns
+	// everything from Offset on.
+	Offset int
+	Limit  int
+	// IncludePast also returns flights that have already departed.
+	IncludePast bool
+	// MaxPrice drops flights whose quoted price in Class (economy by
+	// default), taxes included, is higher. Zero means no limit.
+	MaxPrice Money
+}
+
+// SearchOption adjusts a SearchCriteria and rejects invalid input up front.
+type SearchOption func(*SearchCriteria) error
+
+// NewSearchCriteria builds criteria for a route and date, validating each
+// option as it is applied.
+func NewSearchCriteria(source, destination string, date time.Time, opts ...SearchOption) (SearchCriteria, error) {
+	var err error
+	criteria := SearchCriteria{Date: date}
+	if criteria.Source, err = ParseAirportCode(source); err != nil {
+		return SearchCriteria{}, err
+	}
+	if criteria.Destination, err = ParseAirportCode(destination); err != nil {
+		return SearchCriteria{}, err
+	}
+	if date.IsZero() {
+		return SearchCriteria{}, fmt.Errorf("%w: date is required", ErrInvalidSearchWindow)
+	}
+	for _, opt := range opts {
+		if err := opt(&criteria); err != nil {
+			return SearchCriteria{}, err
+		}
+	}
+	if err := criteria.validateWindow(); err != nil {
+		return SearchCriteria{}, err
+	}
+	return criteria, nil
+}
+
+func WithClass(class CabinClass) SearchOption {
+	return func(c *SearchCriteria) error {
+		if class < Economy || class > First {
+			return fmt.Errorf("%w: %s", ErrInvalidCabinClass, class)
+		}
+		c.Class = &class
+		return nil
+	}
+}
+
+// WithTimeWindow keeps flights departing in [after, before). Either end may
+// be the zero time to leave it open.
+func WithTimeWindow(after, before time.Time) SearchOption {
+	return func(c *SearchCriteria) error {
+		if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+			return fmt.Errorf("%w: departure-after must be before departure-before", ErrInvalidSearchWindow)
+		}
+		c.DepartAfter, c.DepartBefore = after, before
+		return nil
+	}
+}
+
+func WithMinSeats(seats int) SearchOption {
+	return func(c *SearchCriteria) error {
+		if seats < 1 {
+			return ErrInvalidSeatCount
+		}
+		c.MinAvailableSeats = seats
+		return nil
+	}
+}
+
+func WithSort(key SortKey, descending bool) SearchOption {
+	return func(c *SearchCriteria) error {
+		if key < SortNone || key > SortByPrice {
+			return fmt.Errorf("%w: %d", ErrInvalidSortKey, int(key))
+		}
+		c.SortBy, c.Descending = key, descending
+		return nil
+	}
+}
+
+// WithLimit returns at most limit flights starting at offset.
+func WithLimit(offset, limit int) SearchOption {
+	return func(c *SearchCriteria) error {
+		if offset < 0 || limit < 0 {
+			return ErrInvalidPagination
+		}
+		c.Offset, c.Limit = offset, limit
+		return nil
+	}
+}
+
+func WithMaxPrice(price Money) SearchOption {
+	return func(c *SearchCriteria) error {
+		if price.Amount <= 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidMoney, price)
+		}
+		c.MaxPrice = price
+		return nil
+	}
+}
+
+func WithIncludePast() SearchOption {
+	return func(c *SearchCriteria) error {
+		c.IncludePast = true
+		return nil
+	}
+}
+
+// SearchResult is one page of matches. Total counts every match, not just
+// the ones on the page.
+type SearchResult struct {
+	Flights []*Flight
+	Total   int
+	// MissingFare counts flights left out of a price-bounded search because
+	// no fare is set for the class.
+	MissingFare int
+}
+
+type SortKey int
+
+const (
+	SortNone SortKey = iota
+	SortByDeparture
+	SortByArrival
+	SortByDuration
+	SortByPrice
+)
+
+// Search matches the route and date, then keeps flights departing in
+// [DepartAfter, DepartBefore). The window has to fall on Date.
+func (fs *FlightSearch) Search(criteria SearchCriteria) (*SearchResult, error) {
+	if err := criteria.validateWindow(); err != nil {
+		return nil, err
+	}
+	if criteria.Offset < 0 || criteria.Limit < 0 {
+		return nil, ErrInvalidPagination
+	}
+	results := make([]*Flight, 0)
+	missingFare := 0
+	for _, flight := range fs.matchRoute(criteria.Source, criteria.Destination, criteria.Date, criteria.IncludePast) {
+		if !criteria.DepartAfter.IsZero() && flight.Departure.Before(criteria.DepartAfter) {
+			continue
+		}
+		if !criteria.DepartBefore.IsZero() && !flight.Departure.Before(criteria.DepartBefore) {
+			continue
+		}
+		if criteria.availableSeats(flight) < max(criteria.MinAvailableSeats, 1) {
+			continue
+		}
+		if !criteria.MaxPrice.IsZero() {
+			within, ok := criteria.withinPrice(flight)
+			if !ok {
+				missingFare++
+			}
+			if !within {
+				continue
+			}
+		}
+		results = append(results, flight)
+	}
+	criteria.sort(results)
+	page := criteria.page(results)
+	page.MissingFare = missingFare
+	return page, nil
+}
+
+// withinPrice reports whether the flight's price in the searched class is at
+// most MaxPrice, and whether the class has a fare at all.
+func (c SearchCriteria) withinPrice(flight *Flight) (within, hasFare bool) {
+	quote, err := classQuote(flight, c.fareClass())
+	if err != nil {
+		return false, false
+	}
+	over, err := quote.Total.Sub(c.MaxPrice)
+	return err == nil && over.Amount <= 0, true
+}
+
+func (c SearchCriteria) fareClass() CabinClass {
+	if c.Class != nil {
+		return *c.Class
+	}
+	return Economy
+}
+
+func (c SearchCriteria) page(matches []*Flight) *SearchResult {
+	start := min(c.Offset, len(matches))
+	end := len(matches)
+	if c.Limit > 0 {
+		end = min(start+c.Limit, end)
+	}
+	return &SearchResult{Flights: matches[start:end], Total: len(matches)}
+}
+
+// sort orders results by the chosen key, breaking ties by flight number so
+// the order is stable across calls. Price is the quoted price in Class, or
+// in economy when no class is given.
+func (c SearchCriteria) sort(results []*Flight) {
+	if c.SortBy == SortNone {
+		return
+	}
+	key := func(f *Flight) int64 {
+		switch c.SortBy {
+		case SortByDeparture:
+			return f.Departure.UnixNano()
+		case SortByArrival:
+			return f.Arrival.UnixNano()
+		case SortByDuration:
+			return int64(f.Arrival.Sub(f.Departure))
+		}
+		quote, err := classQuote(f, c.fareClass())
+		if err != nil {
+			return 0
+		}
+		return quote.Total.Amount
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		ki, kj := key(results[i]), key(results[j])
+		if ki == kj {
+			return results[i].FlightNumber < results[j].FlightNumber
+		}
+		if c.Descending {
+			return ki > kj
+		}
+		return ki < kj
+	})
+}
+
+func (c SearchCriteria) availableSeats(flight *Flight) int {
+	if c.Class != nil {
+		return flight.AvailableSeatsByClass()[*c.Class]
+	}
+	return flight.AvailableSeatCount()
+}
+
+func (c SearchCriteria) validateWindow() error {
+	dayStart := time.Date(c.Date.Year(), c.Date.Month(), c.Date.Day(), 0, 0, 0, 0, c.Date.Location())
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	if !c.DepartAfter.IsZero() && (c.DepartAfter.Before(dayStart) || !c.DepartAfter.Before(dayEnd)) {
+		return fmt.Errorf("%w: departure-after %s is not on %s", ErrInvalidSearchWindow, c.DepartAfter.Format(time.RFC3339), dayStart.Format(time.DateOnly))
+	}
+	if !c.DepartBefore.IsZero() && (!c.DepartBefore.After(dayStart) || c.DepartBefore.After(dayEnd)) {
+		return fmt.Errorf("%w: departure-before %s is not on %s", ErrInvalidSearchWindow, c.DepartBefore.Format(time.RFC3339), dayStart.Format(time.DateOnly))
+	}
+	if !c.DepartAfter.IsZero() && !c.DepartBefore.IsZero() && !c.DepartAfter.Before(c.DepartBefore) {
+		return fmt.Errorf("%w: departure-after must be before departure-before", ErrInvalidSearchWindow)
+	}
+	return nil
+}
+
+// SearchByFlightNumber matches "6E-204", "6e204" or "6E 204" exactly, or
+// every flight of an airline when given just its two-character code.
+// Codeshare numbers match the flight that operates them.
+func (fs *FlightSearch) SearchByFlightNumber(query string) []*Flight {
+	return fs.searchByFlightNumber(query, func(*Flight) bool { return true })
+}
+
+// SearchByFlightNumberOn is SearchByFlightNumber restricted to one day.
+func (fs *FlightSearch) SearchByFlightNumberOn(query string, date time.Time) []*Flight {
+	return fs.searchByFlightNumber(query, func(f *Flight) bool { return sameDay(fs.localDepartureLocked(f), date) })
+}
+
+// keep is called with fs.mu held for reading.
+func (fs *FlightSearch) searchByFlightNumber(query string, keep func(*Flight) bool) []*Flight {
+	q := normalizeFlightNumber(query)
+	results := make([]*Flight, 0)
+	if q == "" {
+		return results
+	}
+	fs.mu.RLock()
+	operated := make(map[string]bool)
+	for marketing, number := range fs.codeshares {
+		if marketing == q || len(q) == airlineCodeLength && strings.HasPrefix(marketing, q) {
+			operated[number] = true
+		}
+	}
+	for _, flights := range fs.routes {
+		for _, flight := range flights {
+			number := normalizeFlightNumber(flight.FlightNumber)
+			airlineOnly := len(q) == airlineCodeLength && strings.HasPrefix(number, q)
+			if (number == q || airlineOnly || operated[number]) && keep(flight) {
+				results = append(results, flight)
+			}
+		}
+	}
+	fs.mu.RUnlock()
+	for i, flight := range results {
+		results[i] = flight.clone()
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].Departure.Equal(results[j].Departure) {
+			return results[i].Departure.Before(results[j].Departure)
+		}
+		return results[i].FlightNumber < results[j].FlightNumber
+	})
+	return results
+}
+
+// airlineCodeLength is the length of an IATA airline designator such as 6E.
+const airlineCodeLength = 2
+
+func normalizeFlightNumber(number string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(number)))
+}
+
+// maxFlexibleDays bounds the window either side of a flexible-date search.
+const maxFlexibleDays = 7
+
+// SearchFlightsFlexible returns the route's upcoming flights for every day
+// within days of center, keyed by "2006-01-02". Days without flights map to
+// an empty slice so a fare calendar can show the gap.
+func (fs *FlightSearch) SearchFlightsFlexible(source, destination string, center time.Time, days int) map[string][]*Flight {
+	days = min(max(days, 0), maxFlexibleDays)
+	results := make(map[string][]*Flight, 2*days+1)
+	for offset := -days; offset <= days; offset++ {
+		results[center.AddDate(0, 0, offset).Format(time.DateOnly)] = make([]*Flight, 0)
+	}
+	now := fs.now()
+	for _, flight := range fs.routeFlights(source, destination) {
+		if !searchable(flight, now, false) {
+			continue
+		}
+		day := fs.localDeparture(flight).Format(time.DateOnly)
+		if matches, ok := results[day]; ok {
+			results[day] = append(matches, flight.clone())
+		}
+	}
+	return results
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.Month() == b.Month() && a.Day() == b.Day()
+}
+
+// SearchAvailableFlights leaves out sold-out flights.
+func (fs *FlightSearch) SearchAvailableFlights(source, destination string, date time.Time) []*Flight {
+	results := make([]*Flight, 0)
+	for _, flight := range fs.SearchFlights(source, destination, date) {
+		if flight.AvailableSeatCount() > 0 {
+			results = append(results, flight)
+		}
+	}
+	return results
+}
+
+// SearchFlightsInClass only returns flights with a free seat in the class.
+func (fs *FlightSearch) SearchFlightsInClass(source, destination string, date time.Time, class CabinClass) []*Flight {
+	results := make([]*Flight, 0)
+	for _, flight := range fs.SearchFlights(source, destination, date) {
+		if flight.AvailableSeatsByClass()[class] > 0 {
+			results = append(results, flight)
+		}
+	}
+	return results
+}
+
+// File: frequent_flyer.go
+type FrequentFlyerTier int
+
+const (
+	TierBlue FrequentFlyerTier = iota
+	TierSilver
+	TierGold
+	TierPlatinum
+)
+
+// tierThresholds are the miles flown in the last 12 months needed for each
+// tier, highest first.
+var tierThresholds = []struct {
+	tier  FrequentFlyerTier
+	miles int
+}{
+	{TierPlatinum, 100000},
+	{TierGold, 50000},
+	{TierSilver, 25000},
+}
+
+func (t FrequentFlyerTier) String() string {
+	switch t {
+	case TierBlue:
+		return "Blue"
+	case TierSilver:
+		return "Silver"
+	case TierGold:
+		return "Gold"
+	case TierPlatinum:
+		return "Platinum"
+	}
+	return fmt.Sprintf("FrequentFlyerTier(%d)", int(t))
+}
+
+// defaultMilesPerUnit is how many miles buy one major currency unit.
+const defaultMilesPerUnit = 100
+
+// MilesEntry is one line of a frequent flyer ledger. Redemptions are
+// negative; entries with a FlightNumber were earned by flying.
+type MilesEntry struct {
+	BookingID    string
+	FlightNumber string
+	Miles        int
+	At           time.Time
+}
+
+type FrequentFlyerAccount struct {
+	PassengerID string
+	EnrolledAt  time.Time
+	Balance     int
+	Tier        FrequentFlyerTier
+	Entries     []MilesEntry
+	held        int
+}
+
+// FrequentFlyerProgram holds the accounts and the route distances miles are
+// earned on.
+type FrequentFlyerProgram struct {
+	accounts map[string]*FrequentFlyerAccount
+	// distances is keyed by both directions of a route, e.g. "DEL-BOM".
+	distances    map[string]int
+	milesPerUnit int
+	now          func() time.Time
+	mu           sync.RWMutex
+}
+
+func NewFrequentFlyerProgram() *FrequentFlyerProgram {
+	return &FrequentFlyerProgram{
+		accounts:     make(map[string]*FrequentFlyerAccount),
+		distances:    make(map[string]int),
+		milesPerUnit: defaultMilesPerUnit,
+		now:          time.Now,
+	}
+}
+
+// SetMilesConversionRate sets how many miles pay for one major currency
+// unit. Miles payments created earlier keep the rate they were made with.
+func (ams *AirlineManagementSystem) SetMilesConversionRate(milesPerUnit int) error {
+	if milesPerUnit <= 0 {
+		return ErrInvalidConversionRate
+	}
+	ams.loyalty.mu.Lock()
+	defer ams.loyalty.mu.Unlock()
+	ams.loyalty.milesPerUnit = milesPerUnit
+	return nil
+}
+
+// SetRouteDistance sets the miles earned for flying between two airports in
+// either direction.
+func (ams *AirlineManagementSystem) SetRouteDistance(source, destination string, miles int) error {
+	if miles <= 0 {
+		return ErrInvalidDistance
+	}
+	for _, code := range []string{source, destination} {
+		if _, err := ParseAirportCode(code); err != nil {
+			return err
+		}
+	}
+	source, destination = normalizeAirportCode(source), normalizeAirportCode(destination)
+	ams.loyalty.mu.Lock()
+	defer ams.loyalty.mu.Unlock()
+	ams.loyalty.distances[source+"-"+destination] = miles
+	ams.loyalty.distances[destination+"-"+source] = miles
+	return nil
+}
+
+// EnrollFrequentFlyer opens an account for a registered passenger.
+func (ams *AirlineManagementSystem) EnrollFrequentFlyer(passengerID string) (*FrequentFlyerAccount, error) {
+	if _, err := ams.passengers.GetPassenger(passengerID); err != nil {
+		return nil, err
+	}
+	ams.loyalty.mu.Lock()
+	defer ams.loyalty.mu.Unlock()
+	if _, ok := ams.loyalty.accounts[passengerID]; ok {
+		return nil, ErrAlreadyEnrolled
+	}
+	account := &FrequentFlyerAccount{PassengerID: passengerID, EnrolledAt: ams.clock()}
+	ams.loyalty.accounts[passengerID] = account
+	return account.snapshot(ams.clock()), nil
+}
+
+// GetAccount returns a copy of the account with its tier worked out from
+// the miles earned in the 12 months before now.
+func (ams *AirlineManagementSystem) GetAccount(passengerID string) (*FrequentFlyerAccount, error) {
+	ams.loyalty.mu.RLock()
+	defer ams.loyalty.mu.RUnlock()
+	account, ok := ams.loyalty.accounts[passengerID]
+	if !ok {
+		return nil, ErrNotEnrolled
+	}
+	return account.snapshot(ams.clock()), nil
+}
+
+func (a *FrequentFlyerAccount) snapshot(now time.Time) *FrequentFlyerAccount {
+	copied := *a
+	copied.Entries = append([]MilesEntry(nil), a.Entries...)
+	copied.Tier = a.tier(now)
+	return &copied
+}
+
+func (a *FrequentFlyerAccount) tier(now time.Time) FrequentFlyerTier {
+	since := now.AddDate(-1, 0, 0)
+	flown := 0
+	for _, entry := range a.Entries {
+		if entry.FlightNumber != "" && entry.At.After(since) && !entry.At.After(now) {
+			flown += entry.Miles
+		}
+	}
+	for _, threshold := range tierThresholds {
+		if flown >= threshold.miles {
+			return threshold.tier
+		}
+	}
+	return TierBlue
+}
+
+// creditMiles credits every completed booking on the flight whose passenger
+// is enrolled. A booking is only ever credited once, so closing a flight
+// again is harmless.
+func (ams *AirlineManagementSystem) creditMiles(flight *Flight) {
+	departure := flight.departureTime()
+	ams.loyalty.mu.RLock()
+	miles := ams.loyalty.distances[normalizeAirportCode(flight.Source)+"-"+normalizeAirportCode(flight.Destination)]
+	ams.loyalty.mu.RUnlock()
+	if miles == 0 {
+		return
+	}
+	type completed struct{ bookingID, passengerID string }
+	credits := make([]completed, 0)
+	ams.bookingManager.mu.RLock()
+	for _, booking := range ams.bookingManager.byFlight[flight.FlightNumber] {
+		if booking.Flight == flight && booking.Status == BookingCompleted && booking.Passenger != nil {
+			credits = append(credits, completed{booking.BookingID, booking.Passenger.PassengerID})
+		}
+	}
+	ams.bookingManager.mu.RUnlock()
+
+	ams.loyalty.mu.Lock()
+	defer ams.loyalty.mu.Unlock()
+	for _, credit := range credits {
+		account, ok := ams.loyalty.accounts[credit.passengerID]
+		if !ok || account.credited(credit.bookingID) {
+			continue
+		}
+		account.Balance += miles
+		account.Entries = append(account.Entries, MilesEntry{
+			BookingID:    credit.bookingID,
+			FlightNumber: flight.FlightNumber,
+			Miles:        miles,
+			At:           departure,
+		})
+	}
+}
+
+func (a *FrequentFlyerAccount) credited(bookingID string) bool {
+	for _, entry := range a.Entries {
+		if entry.BookingID == bookingID && entry.FlightNumber != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// File: gate.go
+// GateChange records a move from one departure gate to another. The From
+// fields are empty for the first assignment.
+type GateChange struct {
+	FromTerminal string
+	FromGate     string
+	ToTerminal   string
+	ToGate       string
+	At           time.Time
+}
+
+// AssignGate sets or changes the departure gate up until the flight leaves
+// and notes the change on every active booking. Boarding passes already
+// issued are superseded so they are reissued with the new gate.
+func (ams *AirlineManagementSystem) AssignGate(flightNumber, terminal, gate string) error {
+	terminal, gate = strings.TrimSpace(terminal), strings.TrimSpace(gate)
+	if terminal == "" || gate == "" {
+		return ErrGateRequired
+	}
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return err
+	}
+	if err := ams.checkBookable(flight); err != nil {
+		return err
+	}
+	change, changed := flight.assignGate(terminal, gate)
+	if !changed {
+		return nil
+	}
+	detail := fmt.Sprintf("terminal %s gate %s", terminal, gate)
+	if change.FromGate != "" {
+		detail = fmt.Sprintf("terminal %s gate %s -> %s", change.FromTerminal, change.FromGate, detail)
+	}
+	ams.bookingManager.recordFlightEvent(flight, ActionGateChanged, detail)
+	ams.bookingManager.supersedeBoardingPasses(flight, "gate changed")
+	return nil
+}
+
+func (f *Flight) assignGate(terminal, gate string) (GateChange, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Terminal == terminal && f.Gate == gate {
+		return GateChange{}, false
+	}
+	change := GateChange{FromTerminal: f.Terminal, FromGate: f.Gate, ToTerminal: terminal, ToGate: gate, At: f.now()}
+	f.GateChanges = append(f.GateChanges, change)
+	f.Terminal, f.Gate = terminal, gate
+	return change, true
+}
+
+// FlightsAtGate returns copies of the flights departing from the gate in
+// [from, to), earliest first, to spot double assignments.
+func (ams *AirlineManagementSystem) FlightsAtGate(terminal, gate string, from, to time.Time) []*Flight {
+	_, flights := ams.fleetSnapshot()
+	results := make([]*Flight, 0)
+	for _, flight := range flights {
+		snapshot := flight.clone()
+		if snapshot.Terminal != terminal || snapshot.Gate != gate || snapshot.Status == FlightCancelled {
+			continue
+		}
+		if !snapshot.Departure.Before(from) && snapshot.Departure.Before(to) {
+			results = append(results, snapshot)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Departure.Before(results[j].Departure)
+	})
+	return results
+}
+
+// File: group_booking.go
+type GroupBooking struct {
+	GroupID  string
+	Flight   *Flight
+	Bookings []*Booking
+	Payment  *Payment
+	// Shares is each member's part of Payment, in the order of Bookings.
+	Shares []Money
+}
+
+// CreateGroupBooking books one seat per passenger on the same flight under a
+// single reference and a combined payment. Either every seat is booked or
+// none are. When seats is empty the group is seated together, across an
+// aisle only if no single block fits. A group of children with no adult
+// needs WithUnaccompaniedMinor; the form covers every child in the group.
+// Each member is priced like a single booking of the same seat. Options
+// apply to the whole group as they would to BookFlight; a seat number, seat
+// lock or seat position cannot and is refused.
+func (ams *AirlineManagementSystem) CreateGroupBooking(flightNumber string, passengers []*Passenger, seats []int, opts ...BookingOption) (*GroupBooking, error) {
+	if len(passengers) == 0 || (len(seats) > 0 && len(passengers) != len(seats)) {
+		return nil, ErrGroupSizeMismatch
+	}
+	options := bookingOptions{paymentMethod: defaultPaymentMethod()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	switch {
+	case options.seatNumber != 0:
+		return nil, fmt.Errorf("%w: WithSeat, pass seats instead", ErrUnsupportedGroupOption)
+	case options.lockOwner != "":
+		return nil, fmt.Errorf("%w: WithSeatLockOwner", ErrUnsupportedGroupOption)
+	case options.preference != nil && options.preference.Position != AnyPosition:
+		return nil, fmt.Errorf("%w: seat position %s", ErrUnsupportedGroupOption, options.preference.Position)
+	case options.paymentMethod == nil:
+		return nil, ErrPaymentMethodRequired
+	}
+	if options.contact != nil {
+		if err := options.contact.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if options.preference != nil && options.preference.Class != nil {
+		options.class = options.preference.Class
+	}
+	members := make([]*Passenger, len(passengers))
+	for i, passenger := range passengers {
+		if passenger == nil {
+			return nil, fmt.Errorf("%w: group member %d", ErrPassengerRequired, i+1)
+		}
+		registered, err := ams.passengers.resolve(passenger)
+		if err != nil {
+			return nil, err
+		}
+		members[i] = registered
+	}
+	passengers = members
+	accompanied := false
+	for _, passenger := range passengers {
+		if passenger.Type == Infant {
+			return nil, ErrInfantRequiresAdult
+		}
+		accompanied = accompanied || passenger.Type == Adult
+		if !passenger.ExitRowEligible() {
+			options.allowExitRow = false
+		}
+	}
+	var minorForm *UnaccompaniedMinorForm
+	if !accompanied {
+		minorForm = options.minorForm
+	}
+	ams.ExpireHolds()
+	var flight *Flight
+	var err error
+	if options.date.IsZero() {
+		flight, err = ams.findFlight(flightNumber)
+	} else {
+		flight, err = ams.findFlightOn(flightNumber, options.date)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ams.checkBookable(flight); err != nil {
+		return nil, err
+	}
+	for _, passenger := range passengers {
+		if err := checkTravelDocument(flight, passenger); err != nil {
+			return nil, fmt.Errorf("passenger %s: %w", passenger.PassengerID, err)
+		}
+	}
+	if !accompanied {
+		if err := checkUnaccompaniedMinor(flight, minorForm); err != nil {
+			return nil, err
+		}
+	}
+	if len(seats) == 0 {
+		eligible := seatFilter(options)
+		seats, err = flight.reserveAdjacentSeats(len(passengers), false, eligible)
+		if errors.Is(err, ErrNoAdjacentSeats) {
+			seats, err = flight.reserveAdjacentSeats(len(passengers), true, eligible)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for i, passenger := range passengers {
+			if err := checkSeatClass(flight, seats[i], options); err != nil {
+				return nil, err
+			}
+			if err := checkSeatForPassenger(flight, seats[i], passenger); err != nil {
+				return nil, err
+			}
+		}
+		if err := flight.reserveSeats(seats); err != nil {
+			return nil, err
+		}
+	}
+	quotes := make([]*Quote, len(passengers))
+	var total Money
+	var lineItems []LineItem
+	for i := range passengers {
+		quote, err := newQuote(flight, seats[i])
+		if err == nil {
+			total, err = total.Add(quote.Total)
+		}
+		if err != nil {
+			flight.releaseSeats(seats)
+			return nil, err
+		}
+		quotes[i] = quote
+		lineItems = append(lineItems, quote.LineItems()...)
+	}
+	payment := NewPayment(ams.paymentProcessor.nextPaymentID(), total, options.paymentMethod, PaymentPending)
+	payment.LineItems = lineItems
+	group := &GroupBooking{
+		Flight:   flight,
+		Bookings: make([]*Booking, len(passengers)),
+		Payment:  payment,
+		Shares:   make([]Money, len(passengers)),
+	}
+	for i, passenger := range passengers {
+		group.Bookings[i] = NewBooking("", flight, passenger, seats[i])
+		group.Bookings[i].Payment = payment
+		group.Bookings[i].UnaccompaniedMinor = minorForm
+		group.Bookings[i].Contact = options.contact
+		group.Shares[i] = quotes[i].Total
+	}
+	ams.bookingManager.addGroup(group)
+	payment.BookingID = group.GroupID
+	if err := ams.paymentProcessor.ProcessPayment(payment); err != nil {
+		ams.bookingManager.removeGroup(group.GroupID)
+		flight.releaseSeats(seats)
+		return nil, err
+	}
+	for _, booking := range group.Bookings {
+		if err := ams.bookingManager.UpdateStatus(booking.BookingID, BookingConfirmed); err != nil {
+			return nil, err
+		}
+	}
+	return group, nil
+}
+
+// CancelGroupBooking cancels every booking in the group, releases their seats
+// and refunds the combined payment.
+func (ams *AirlineManagementSystem) CancelGroupBooking(groupID string) error {
+	group, err := ams.bookingManager.cancelGroup(groupID)
+	if err != nil {
+		return err
+	}
+	seats := make([]int, len(group.Bookings))
+	for i, booking := range group.Bookings {
+		seats[i] = booking.SeatNumber
+		if booking.Infant != nil {
+			group.Flight.releaseLapInfant()
+		}
+	}
+	group.Flight.releaseSeats(seats)
+	return ams.paymentProcessor.Refund(group.Payment.PaymentID)
+}
+
+func (bm *BookingManager) GetGroupBooking(groupID string) (*GroupBooking, error) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	group, ok := bm.groups[groupID]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	return group, nil
+}
+
+// groupShare returns the group payment covering a group member and the
+// member's share of it. ok is false for bookings outside a group.
+func (bm *BookingManager) groupShare(booking *Booking) (payment *Payment, share Money, ok bool) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	group, found := bm.groups[booking.GroupID]
+	if !found {
+		return nil, Money{}, false
+	}
+	for i, member := range group.Bookings {
+		if member == booking && i < len(group.Shares) {
+			return group.Payment, group.Shares[i], true
+		}
+	}
+	return nil, Money{}, false
+}
+
+func (bm *BookingManager) addGroup(group *GroupBooking) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	group.GroupID = bm.newBookingIDLocked()
+	bm.groups[group.GroupID] = group
+	for _, booking := range group.Bookings {
+		booking.BookingID = bm.newBookingIDLocked()
+		booking.GroupID = group.GroupID
+		booking.BookingTime = bm.now()
+		bm.storeLocked(booking)
+		bm.recordLocked(booking, ActionCreated, fmt.Sprintf("group %s flight %s seat %d", group.GroupID, booking.Flight.FlightNumber, booking.SeatNumber))
+	}
+}
+
+func (bm *BookingManager) removeGroup(groupID string) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	group, ok := bm.groups[groupID]
+	if !ok {
+		return
+	}
+	for _, booking := range group.Bookings {
+		bm.deleteLocked(booking)
+	}
+	delete(bm.groups, groupID)
+}
+
+func (bm *BookingManager) cancelGroup(groupID string) (*GroupBooking, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	group, ok := bm.groups[groupID]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	for _, booking := range group.Bookings {
+		if _, err := booking.Status.Transition(BookingCancelled); err != nil {
+			return nil, err
+		}
+	}
+	for _, booking := range group.Bookings {
+		bm.recordLocked(booking, ActionCancelled, fmt.Sprintf("%s -> %s", booking.Status, BookingCancelled))
+		booking.Status = BookingCancelled
+	}
+	return group, nil
+}
+
+// File: ledger.go
+// LedgerEntry is one line of a passenger's statement. Amount is negative for
+// refunds and zero for charges that never completed.
+type LedgerEntry struct {
+	Payment *Payment
+	Amount  Money
+	Balance Money
+}
+
+// GetPaymentsForPassenger returns the passenger's charges and refunds, oldest
+// first.
+func (pp *PaymentProcessor) GetPaymentsForPassenger(passengerID string) []*Payment {
+	pp.mu.RLock()
+	payments := make([]*Payment, len(pp.byPassenger[passengerID]))
+	copy(payments, pp.byPassenger[passengerID])
+	pp.mu.RUnlock()
+	sort.SliceStable(payments, func(i, j int) bool {
+		return payments[i].CreatedAt.Before(payments[j].CreatedAt)
+	})
+	return payments
+}
+
+// RunningBalance walks payments in order and totals what has actually been
+// paid, net of refunds.
+func RunningBalance(payments []*Payment) ([]LedgerEntry, error) {
+	entries := make([]LedgerEntry, 0, len(payments))
+	balance := Money{}
+	for _, payment := range payments {
+		amount := Money{Currency: payment.Amount.Currency}
+		switch {
+		case payment.RefundOf != "":
+			amount = payment.Amount.Multiply(-1)
+		case payment.Status != PaymentPending && payment.Status != PaymentProcessing && payment.Status != PaymentFailed:
+			amount = payment.Amount
+		}
+		next, err := balance.Add(amount)
+		if err != nil {
+			return nil, err
+		}
+		balance = next
+		entries = append(entries, LedgerEntry{Payment: payment, Amount: amount, Balance: balance})
+	}
+	return entries, nil
+}
+
+// File: maintenance.go
+type MaintenanceWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+func (w MaintenanceWindow) overlaps(from, to time.Time) bool {
+	return w.From.Before(to) && from.Before(w.To)
+}
+
+// AddMaintenanceWindow takes the aircraft out of service between from and
+// to. The window may not overlap another window for the aircraft or any of
+// its flights that have not been cancelled.
+func (ams *AirlineManagementSystem) AddMaintenanceWindow(tailNumber string, from, to time.Time) error {
+	if !from.Before(to) {
+		return ErrInvalidMaintenanceWindow
+	}
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	found := false
+	for _, aircraft := range ams.aircrafts {
+		found = found || aircraft.TailNumber == tailNumber
+	}
+	if !found {
+		return ErrAircraftNotFound
+	}
+	window := MaintenanceWindow{From: from, To: to}
+	for _, existing := range ams.maintenance[tailNumber] {
+		if existing.overlaps(from, to) {
+			return fmt.Errorf("%w: %s", ErrMaintenanceOverlap, tailNumber)
+		}
+	}
+	for _, flight := range ams.flights {
+		if flight.Aircraft.TailNumber != tailNumber || flight.CurrentStatus() == FlightCancelled {
+			continue
+		}
+		if window.overlaps(flight.departureTime(), flight.Arrival) {
+			return fmt.Errorf("%w: %s flies %s", ErrAircraftInUse, tailNumber, flight.FlightNumber)
+		}
+	}
+	ams.maintenance[tailNumber] = append(ams.maintenance[tailNumber], window)
+	return nil
+}
+
+// GetMaintenanceSchedule returns the aircraft's windows that have not yet
+// ended, earliest first.
+func (ams *AirlineManagementSystem) GetMaintenanceSchedule(tailNumber string) []MaintenanceWindow {
+	now := ams.clock()
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	upcoming := make([]MaintenanceWindow, 0)
+	for _, window := range ams.maintenance[tailNumber] {
+		if window.To.After(now) {
+			upcoming = append(upcoming, window)
+		}
+	}
+	sort.Slice(upcoming, func(i, j int) bool {
+		return upcoming[i].From.Before(upcoming[j].From)
+	})
+	return upcoming
+}
+
+func (ams *AirlineManagementSystem) checkMaintenanceLocked(tailNumber string, from, to time.Time) error {
+	for _, window := range ams.maintenance[tailNumber] {
+		if window.overlaps(from, to) {
+			return fmt.Errorf("%w: %s from %s to %s", ErrAircraftInMaintenance, tailNumber,
+				window.From.Format(time.RFC3339), window.To.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// File: manifest.go
+// Manifest lists everyone travelling on a flight, in seat order. Lap
+// infants follow the adult they travel with and share the adult's seat.
+type Manifest struct {
+	FlightNumber string
+	Source       string
+	Destination  string
+	Departure    time.Time
+	TailNumber   string
+	Terminal     string
+	Gate         string
+	GeneratedAt  time.Time
+	Entries      []ManifestEntry
+	// CheckedBags and CheckedBagWeightKg total the hold baggage for load
+	// planning.
+	CheckedBags        int
+	CheckedBagWeightKg float64
+	// ServiceRequests lists the booking IDs asking for each SSR code.
+	ServiceRequests map[SSRCode][]string
+}
+
+type ManifestEntry struct {
+	Seat              string
+	BookingID         string
+	PassengerID       string
+	Name              string
+	Type              PassengerType
+	Status            BookingStatus
+	SpecialAssistance []AssistanceNeed
+	SSRs              []SSRCode
+	// UnaccompaniedMinor flags a child travelling alone.
+	UnaccompaniedMinor bool
+	// LapInfant is set on the entry of an infant travelling on the adult's
+	// booking.
+	LapInfant bool
+}
+
+// GenerateManifest builds the manifest for the next departure of the flight
+// number. Cancelled bookings are left out; bumped passengers without a seat
+// come last.
+func (ams *AirlineManagementSystem) GenerateManifest(flightNumber string) (*Manifest, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	flight.mu.Lock()
+	manifest := &Manifest{
+		FlightNumber:    flight.FlightNumber,
+		Source:          flight.Source,
+		Destination:     flight.Destination,
+		Departure:       flight.Departure,
+		TailNumber:      flight.Aircraft.TailNumber,
+		Terminal:        flight.Terminal,
+		Gate:            flight.Gate,
+		GeneratedAt:     ams.clock(),
+		ServiceRequests: make(map[SSRCode][]string),
+	}
+	seats := make(map[int]*Seat, len(flight.Seats))
+	for _, seat := range flight.Seats {
+		copied := *seat
+		seats[seat.SeatNumber] = &copied
+	}
+	flight.mu.Unlock()
+
+	bookings := ams.bookingManager.activeBookingsOn(flight)
+	ams.bookingManager.mu.RLock()
+	defer ams.bookingManager.mu.RUnlock()
+	sort.SliceStable(bookings, func(i, j int) bool {
+		a, b := seats[bookings[i].SeatNumber], seats[bookings[j].SeatNumber]
+		switch {
+		case a == nil || b == nil:
+			return b == nil && a != nil
+		case a.Row != b.Row:
+			return a.Row < b.Row
+		}
+		return a.columnIndex < b.columnIndex
+	})
+	for _, booking := range bookings {
+		if booking.Status == BookingCancelled {
+			continue
+		}
+		label := ""
+		if seat, ok := seats[booking.SeatNumber]; ok {
+			label = seat.Label()
+		}
+		entry := manifestEntry(label, booking, booking.Passenger)
+		entry.SSRs = append([]SSRCode(nil), booking.SSRs...)
+		entry.UnaccompaniedMinor = booking.UnaccompaniedMinor != nil
+		manifest.Entries = append(manifest.Entries, entry)
+		for _, code := range booking.SSRs {
+			manifest.ServiceRequests[code] = append(manifest.ServiceRequests[code], booking.BookingID)
+		}
+		for _, bag := range booking.Baggage {
+			if bag.Type == CheckedBag {
+				manifest.CheckedBags++
+				manifest.CheckedBagWeightKg += bag.WeightKg
+			}
+		}
+		if booking.Infant != nil {
+			entry := manifestEntry(label, booking, booking.Infant)
+			entry.LapInfant = true
+			manifest.Entries = append(manifest.Entries, entry)
+		}
+	}
+	return manifest, nil
+}
+
+func manifestEntry(seat string, booking *Booking, passenger *Passenger) ManifestEntry {
+	return ManifestEntry{
+		Seat:              seat,
+		BookingID:         booking.BookingID,
+		PassengerID:       passenger.PassengerID,
+		Name:              passenger.Name,
+		Type:              passenger.Type,
+		Status:            booking.Status,
+		SpecialAssistance: append([]AssistanceNeed(nil), passenger.SpecialAssistance...),
+	}
+}
+
+func (e ManifestEntry) CheckedIn() bool {
+	return e.Status == BookingCheckedIn || e.Status == BookingCompleted
+}
+
+// WriteCSV writes the flight details as key/value rows, then one row per
+// passenger under a column header.
+func (m *Manifest) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{
+		{"Flight", m.FlightNumber},
+		{"Route", m.Source + "-" + m.Destination},
+		{"Departure", m.Departure.Format(time.RFC3339)},
+		{"Aircraft", m.TailNumber},
+		{"Gate", strings.TrimSpace(m.Terminal + " " + m.Gate)},
+		{"Generated", m.GeneratedAt.Format(time.RFC3339)},
+		{"Checked Bags", strconv.Itoa(m.CheckedBags)},
+		{"Checked Bag Weight (kg)", strconv.FormatFloat(m.CheckedBagWeightKg, 'f', 1, 64)},
+		{"Seat", "Booking", "Passenger ID", "Name", "Type", "Status", "Checked In", "Special Assistance", "SSR", "UM", "Lap Infant"},
+	}
+	for _, e := range m.Entries {
+		needs := make([]string, len(e.SpecialAssistance))
+		for i, need := range e.SpecialAssistance {
+			needs[i] = string(need)
+		}
+		codes := make([]string, len(e.SSRs))
+		for i, code := range e.SSRs {
+			codes[i] = string(code)
+		}
+		records = append(records, []string{
+			e.Seat,
+			e.BookingID,
+			e.PassengerID,
+			e.Name,
+			e.Type.String(),
+			e.Status.String(),
+			strconv.FormatBool(e.CheckedIn()),
+			strings.Join(needs, ";"),
+			strings.Join(codes, ";"),
+			strconv.FormatBool(e.UnaccompaniedMinor),
+			strconv.FormatBool(e.LapInfant),
+		})
+	}
+	return cw.WriteAll(records)
+}
+
+// File: money.go
+// Money is an amount in minor units (paise, cents) of an ISO 4217 currency.
+// Every supported currency has two decimal places. The zero value has no
+// currency and combines with any currency.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func NewMoney(minor int64, currency string) Money {
+	return Money{Amount: minor, Currency: strings.ToUpper(currency)}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.currencyWith(other)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	currency, err := m.currencyWith(other)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: currency}, nil
+}
+
+// Multiply scales m by factor, rounding half away from zero to the nearest
+// minor unit.
+func (m Money) Multiply(factor float64) Money {
+	scaled := float64(m.Amount) * factor
+	if scaled < 0 {
+		scaled -= 0.5
+	} else {
+		scaled += 0.5
+	}
+	return Money{Amount: int64(scaled), Currency: m.Currency}
+}
+
+func (m Money) currencyWith(other Money) (string, error) {
+	switch {
+	case m.Currency == other.Currency:
+		return m.Currency, nil
+	case m.Currency == "" && m.Amount == 0:
+		return other.Currency, nil
+	case other.Currency == "" && other.Amount == 0:
+		return m.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+}
+
+// String formats m as "INR 4,599.00".
+func (m Money) String() string {
+	minor := m.Amount
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	digits := strconv.FormatInt(minor/100, 10)
+	var grouped strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(d)
+	}
+	amount := fmt.Sprintf("%s%s.%02d", sign, grouped.String(), minor%100)
+	if m.Currency == "" {
+		return amount
+	}
+	return m.Currency + " " + amount
+}
+
+// ParseMoney reads the format String produces, e.g. "INR 4,599.00". The
+// fractional part may have zero, one or two digits.
+func ParseMoney(s string) (Money, error) {
+	currency, amount, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok || len(currency) != 3 {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	amount = strings.ReplaceAll(strings.TrimSpace(amount), ",", "")
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+	whole, fraction, _ := strings.Cut(amount, ".")
+	notDigit := func(r rune) bool { return r < '0' || r > '9' }
+	if whole == "" || len(fraction) > 2 || strings.ContainsFunc(whole+fraction, notDigit) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	cents, _ := strconv.ParseInt(fraction+strings.Repeat("0", 2-len(fraction)), 10, 64)
+	minor := major*100 + cents
+	if negative {
+		minor = -minor
+	}
+	return NewMoney(minor, currency), nil
+}
+
+// File: passenger.go
+type Passenger struct {
+	PassengerID string
+	Name        string
+	Email       string
+	Phone       string
+	Type        PassengerType
+	Document    *TravelDocument
+
+	SpecialAssistance []AssistanceNeed
+}
+
+type AssistanceNeed string
+
+const (
+	AssistWheelchair       AssistanceNeed = "Wheelchair"
+	AssistVisuallyImpaired AssistanceNeed = "VisuallyImpaired"
+	AssistHearingImpaired  AssistanceNeed = "HearingImpaired"
+	AssistReducedMobility  AssistanceNeed = "ReducedMobility"
+)
+
+// ExitRowEligible reports whether the passenger may sit in an exit row:
+// only adults who have not asked for special assistance.
+func (p *Passenger) ExitRowEligible() bool {
+	return p.Type == Adult && len(p.SpecialAssistance) == 0
+}
+
+type PassengerType int
+
+const (
+	Adult PassengerType = iota
+	Child
+	Infant
+)
+
+func (t PassengerType) String() string {
+	switch t {
+	case Adult:
+		return "Adult"
+	case Child:
+		return "Child"
+	case Infant:
+		return "Infant"
+	}
+	return fmt.Sprintf("PassengerType(%d)", int(t))
+}
+
+// NewPassenger trims the contact details and normalizes the phone number to
+// digits with an optional leading +. Email and phone may be left empty, as
+// for infants, but must be well formed when given.
+func NewPassenger(passengerID, name, email, phone string) (*Passenger, error) {
+	passenger := &Passenger{
+		PassengerID: passengerID,
+		Name:        strings.TrimSpace(name),
+		Email:       strings.TrimSpace(email),
+		Type:        Adult,
+	}
+	if phone != "" {
+		normalized, err := normalizePhone(phone)
+		if err != nil {
+			return nil, err
+		}
+		passenger.Phone = normalized
+	}
+	if err := passenger.Validate(); err != nil {
+		return nil, err
+	}
+	return passenger, nil
+}
+
+func (p *Passenger) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return ErrPassengerNameRequired
+	}
+	if p.Email != "" && !validEmail(p.Email) {
+		return fmt.Errorf("%w: %q", ErrInvalidEmail, p.Email)
+	}
+	if p.Phone != "" {
+		if _, err := normalizePhone(p.Phone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validEmail accepts local@domain where the domain has at least one dot
+// between non-empty labels.
+func validEmail(email string) bool {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" || strings.ContainsAny(email, " \t<>,;") || strings.Contains(domain, "@") {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizePhone strips spaces, dashes, dots and brackets and keeps a
+// leading +. The result must have between 7 and 15 digits.
+func normalizePhone(phone string) (string, error) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", fmt.Errorf("%w: %q", ErrInvalidPhone, phone)
+		}
+	}
+	normalized := b.String()
+	if digits := len(strings.TrimPrefix(normalized, "+")); digits < 7 || digits > 15 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPhone, phone)
+	}
+	return normalized, nil
+}
+
+// File: passenger_registry.go
+// PassengerRegistry keeps one record per person. Emails are compared
+// case-insensitively, so registering the same person twice under different
+// IDs is caught.
+type PassengerRegistry struct {
+	passengers map[string]*Passenger
+	byEmail    map[string]*Passenger
+	history    map[string][]ProfileChange
+	// bookings, when set, is checked and updated on erasure.
+	bookings *BookingManager
+	now      func() time.Time
+	mu       sync.RWMutex
+}
+
+func NewPassengerRegistry() *PassengerRegistry {
+	return &PassengerRegistry{
+		passengers: make(map[string]*Passenger),
+		byEmail:    make(map[string]*Passenger),
+		history:    make(map[string][]ProfileChange),
+		now:        time.Now,
+	}
+}
+
+func (ams *AirlineManagementSystem) Passengers() *PassengerRegistry {
+	return ams.passengers
+}
+
+// RegisterPassenger stores the passenger. If the ID or email is already
+// registered, the existing record is returned with ErrDuplicatePassenger.
+func (pr *PassengerRegistry) RegisterPassenger(passenger *Passenger) (*Passenger, error) {
+	if passenger.PassengerID == "" {
+		return nil, ErrPassengerIDRequired
+	}
+	if err := passenger.Validate(); err != nil {
+		return nil, err
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if existing, ok := pr.passengers[passenger.PassengerID]; ok {
+		return existing, fmt.Errorf("%w: %s", ErrDuplicatePassenger, passenger.PassengerID)
+	}
+	email := normalizeEmail(passenger.Email)
+	if existing, ok := pr.byEmail[email]; ok && email != "" {
+		return existing, fmt.Errorf("%w: %s is %s", ErrDuplicatePassenger, passenger.Email, existing.PassengerID)
+	}
+	pr.passengers[passenger.PassengerID] = passenger
+	if email != "" {
+		pr.byEmail[email] = passenger
+	}
+	return passenger, nil
+}
+
+func (pr *PassengerRegistry) GetPassenger(passengerID string) (*Passenger, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	passenger, ok := pr.passengers[passengerID]
+	if !ok {
+		return nil, ErrPassengerNotFound
+	}
+	return passenger, nil
+}
+
+func (pr *PassengerRegistry) FindByEmail(email string) (*Passenger, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	passenger, ok := pr.byEmail[normalizeEmail(email)]
+	if !ok {
+		return nil, ErrPassengerNotFound
+	}
+	return passenger, nil
+}
+
+// resolve returns the registered record for a passenger met during booking,
+// registering it first if it is new. Only the PassengerID picks an existing
+// record: a new ID whose email is already registered to someone else is
+// refused rather than swapped for that person.
+func (pr *PassengerRegistry) resolve(passenger *Passenger) (*Passenger, error) {
+	registered, err := pr.RegisterPassenger(passenger)
+	if errors.Is(err, ErrDuplicatePassenger) && registered.PassengerID != passenger.PassengerID {
+		return nil, err
+	}
+	if errors.Is(err, ErrDuplicatePassenger) {
+		return registered, nil
+	}
+	return registered, err
+}
+
+// ProfileUpdate lists the fields to change; nil fields are left alone.
+// PassengerID is only there to be refused.
+type ProfileUpdate struct {
+	PassengerID *string
+	Name        *string
+	Email       *string
+	Phone       *string
+}
+
+type ProfileChange struct {
+	Field string
+	From  string
+	To    string
+	At    time.Time
+}
+
+// UpdateProfile validates and applies the update in place. Bookings share
+// the passenger record, so they see the new details straight away.
+func (pr *PassengerRegistry) UpdateProfile(passengerID string, update ProfileUpdate) error {
+	if update.PassengerID != nil && *update.PassengerID != passengerID {
+		return ErrPassengerIDImmutable
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	passenger, ok := pr.passengers[passengerID]
+	if !ok {
+		return ErrPassengerNotFound
+	}
+	updated := *passenger
+	if update.Name != nil {
+		updated.Name = strings.TrimSpace(*update.Name)
+	}
+	if update.Email != nil {
+		updated.Email = strings.TrimSpace(*update.Email)
+	}
+	if update.Phone != nil {
+		updated.Phone = ""
+		if *update.Phone != "" {
+			phone, err := normalizePhone(*update.Phone)
+			if err != nil {
+				return err
+			}
+			updated.Phone = phone
+		}
+	}
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	oldEmail, newEmail := normalizeEmail(passenger.Email), normalizeEmail(updated.Email)
+	if newEmail != oldEmail {
+		if existing, ok := pr.byEmail[newEmail]; ok && newEmail != "" && existing != passenger {
+			return fmt.Errorf("%w: %s is %s", ErrDuplicatePassenger, updated.Email, existing.PassengerID)
+		}
+		delete(pr.byEmail, oldEmail)
+		if newEmail != "" {
+			pr.byEmail[newEmail] = passenger
+		}
+	}
+	at := pr.now()
+	for _, field := range []struct{ name, from, to string }{
+		{"Name", passenger.Name, updated.Name},
+		{"Email", passenger.Email, updated.Email},
+		{"Phone", passenger.Phone, updated.Phone},
+	} {
+		if field.from != field.to {
+			pr.history[passengerID] = append(pr.history[passengerID], ProfileChange{Field: field.name, From: field.from, To: field.to, At: at})
+		}
+	}
+	passenger.Name, passenger.Email, passenger.Phone = updated.Name, updated.Email, updated.Phone
+	return nil
+}
+
+func (pr *PassengerRegistry) ProfileHistory(passengerID string) []ProfileChange {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	return append([]ProfileChange(nil), pr.history[passengerID]...)
+}
+
+// erasedName replaces the name of an erased passenger.
+const erasedName = "Erased Passenger"
+
+type EraseOption func(*eraseOptions)
+
+type eraseOptions struct {
+	force bool
+}
+
+// ForceErase erases a passenger even with upcoming bookings. The bookings
+// stay valid but no longer identify who is travelling.
+func ForceErase() EraseOption {
+	return func(o *eraseOptions) {
+		o.force = true
+	}
+}
+
+// ErasePassenger scrubs the passenger's personal data, drops the record
+// and anonymizes their bookings; seats, payments and statuses are kept.
+// Bookings still to be flown block erasure unless ForceErase is given.
+func (pr *PassengerRegistry) ErasePassenger(passengerID string, opts ...EraseOption) error {
+	var options eraseOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	passenger, ok := pr.passengers[passengerID]
+	if !ok {
+		return ErrPassengerNotFound
+	}
+	if pr.bookings != nil {
+		if err := pr.bookings.anonymize(passengerID, options.force); err != nil {
+			return err
+		}
+	}
+	delete(pr.byEmail, normalizeEmail(passenger.Email))
+	delete(pr.passengers, passengerID)
+	delete(pr.history, passengerID)
+	passenger.Name = erasedName
+	passenger.Email = ""
+	passenger.Phone = ""
+	passenger.Document = nil
+	passenger.SpecialAssistance = nil
+	return nil
+}
+
+// anonymize marks the passenger's bookings as anonymized and drops their
+// contacts and minor forms. Without force it changes nothing while any
+// booking is still to be flown.
+func (bm *BookingManager) anonymize(passengerID string, force bool) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bookings := bm.byPassenger[passengerID]
+	if !force {
+		upcoming := 0
+		for _, booking := range bookings {
+			switch booking.Status {
+			case BookingPending, BookingConfirmed, BookingCheckedIn:
+				upcoming++
+			}
+		}
+		if upcoming > 0 {
+			return fmt.Errorf("%w: %s has %d", ErrPassengerHasBookings, passengerID, upcoming)
+		}
+	}
+	for _, booking := range bookings {
+		if booking.Anonymized {
+			continue
+		}
+		booking.Anonymized = true
+		booking.Contact = nil
+		booking.UnaccompaniedMinor = nil
+		bm.recordLocked(booking, ActionAnonymized, "")
+	}
+	return nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// File: payment.go
+type Payment struct {
+	PaymentID string
+	Amount    Money
+	Method    PaymentMethod
+	Status    PaymentStatus
+	// BookingID is the booking paid for, or the group ID for a group payment.
+	BookingID   string
+	PassengerID string
+	CreatedAt   time.Time
+	// TransactionID is the gateway's reference for the charge.
+	TransactionID string
+	// RefundOf is set on refund records to the payment being refunded.
+	RefundOf  string
+	Attempts  []PaymentAttempt
+	LineItems []LineItem
+}
+
+// Validate checks that the payment can be sent to the gateway.
+func (p *Payment) Validate() error {
+	if p.PaymentID == "" {
+		return ErrPaymentIDRequired
+	}
+	if p.Amount.Amount <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidPaymentAmount, p.Amount)
+	}
+	if p.Method == nil {
+		return ErrPaymentMethodRequired
+	}
+	return nil
+}
+
+// PaymentAttempt records one try at charging a payment.
+type PaymentAttempt struct {
+	Number int
+	At     time.Time
+	Err    error
+}
+
+func NewPayment(paymentID string, amount Money, method PaymentMethod, status PaymentStatus) *Payment {
+	return &Payment{
+		PaymentID: paymentID,
+		Amount:    amount,
+		Method:    method,
+		Status:    status,
+	}
+}
+
+// File: payment_gateway.go
+// PaymentGateway is the external service that moves money. ProcessPayment
+// charges through it and stores the returned transaction ID on the Payment.
+type PaymentGateway interface {
+	Charge(ctx context.Context, amount Money, method PaymentMethod) (string, error)
+	Refund(ctx context.Context, txnID string, amount Money) error
+}
+
+// DeclinedCardNumber is always declined by SimulatedGateway.
+const DeclinedCardNumber = "4000000000000002"
+
+// SimulatedGateway stands in for a real gateway. It waits Latency per call,
+// fails with ErrTransientPayment at FailureRate, declines DeclinedCardNumber
+// and otherwise authorizes and captures through the payment method.
+type SimulatedGateway struct {
+	Latency      time.Duration
+	FailureRate  float64
+	transactions map[string]*simulatedTransaction
+	sequence     int
+	rng          *rand.Rand
+	mu           sync.Mutex
+}
+
+type simulatedTransaction struct {
+	method    PaymentMethod
+	remaining Money
+}
+
+// refundTarget is implemented by payment methods that hold a balance the
+// gateway can credit a refund back to.
+type refundTarget interface {
+	TopUp(amount Money) error
+}
+
+func NewSimulatedGateway() *SimulatedGateway {
+	return &SimulatedGateway{
+		transactions: make(map[string]*simulatedTransaction),
+		rng:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (g *SimulatedGateway) Charge(ctx context.Context, amount Money, method PaymentMethod) (string, error) {
+	if err := g.wait(ctx); err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.FailureRate > 0 && g.rng.Float64() < g.FailureRate {
+		return "", ErrTransientPayment
+	}
+	if card, ok := method.(*CreditCard); ok && card.Number == DeclinedCardNumber {
+		return "", ErrCardDeclined
+	}
+	if err := method.Authorize(amount); err != nil {
+		return "", err
+	}
+	if err := method.Capture(); err != nil {
+		return "", err
+	}
+	g.sequence++
+	txnID := fmt.Sprintf("TXN%06d", g.sequence)
+	g.transactions[txnID] = &simulatedTransaction{method: method, remaining: amount}
+	return txnID, nil
+}
+
+func (g *SimulatedGateway) Refund(ctx context.Context, txnID string, amount Money) error {
+	if err := g.wait(ctx); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	txn, ok := g.transactions[txnID]
+	if !ok {
+		return ErrTransactionNotFound
+	}
+	left, err := txn.remaining.Sub(amount)
+	if err != nil {
+		return err
+	}
+	if left.Amount < 0 {
+		return ErrRefundExceedsPayment
+	}
+	if target, ok := txn.method.(refundTarget); ok {
+		if err := target.TopUp(amount); err != nil {
+			return err
+		}
+	}
+	txn.remaining = left
+	return nil
+}
+
+func (g *SimulatedGateway) wait(ctx context.Context) error {
+	if g.Latency <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(g.Latency)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// File: payment_method.go
+// PaymentMethod authorizes an amount and then captures it. The gateway calls
+// both while the payment is Processing, without the processor's lock held.
+type PaymentMethod interface {
+	Name() string
+	Authorize(amount Money) error
+	Capture() error
+}
+
+func defaultPaymentMethod() PaymentMethod {
+	return &CreditCard{}
+}
+
+type CreditCard struct {
+	Number     string
+	Holder     string
+	authorized Money
+}
+
+func NewCreditCard(number, holder string) *CreditCard {
+	return &CreditCard{Number: number, Holder: holder}
+}
+
+func (c *CreditCard) Name() string {
+	return "CreditCard"
+}
+
+func (c *CreditCard) Masked() string {
+	if len(c.Number) < 4 {
+		return "****"
+	}
+	return "**** " + c.Number[len(c.Number)-4:]
+}
+
+func (c *CreditCard) Authorize(amount Money) error {
+	c.authorized = amount
+	return nil
+}
+
+func (c *CreditCard) Capture() error {
+	if c.authorized.Amount <= 0 {
+		return ErrNothingAuthorized
+	}
+	c.authorized = Money{}
+	return nil
+}
+
+type UPI struct {
+	VPA        string
+	authorized Money
+}
+
+func NewUPI(vpa string) *UPI {
+	return &UPI{VPA: vpa}
+}
+
+func (u *UPI) Name() string {
+	return "UPI"
+}
+
+func (u *UPI) Masked() string {
+	user, handle, ok := strings.Cut(u.VPA, "@")
+	if !ok || len(user) <= 2 {
+		return "***@" + handle
+	}
+	return user[:2] + "***@" + handle
+}
+
+func (u *UPI) Authorize(amount Money) error {
+	u.authorized = amount
+	return nil
+}
+
+func (u *UPI) Capture() error {
+	if u.authorized.Amount <= 0 {
+		return ErrNothingAuthorized
+	}
+	u.authorized = Money{}
+	return nil
+}
+
+// Wallet pays from a passenger's stored balance. Authorize holds the amount
+// so concurrent charges cannot overdraw it; Capture deducts the held funds.
+type Wallet struct {
+	PassengerID string
+	balance     Money
+	held        Money
+	mu          sync.Mutex
+}
+
+func NewWallet(passengerID string, balance Money) *Wallet {
+	return &Wallet{PassengerID: passengerID, balance: balance}
+}
+
+func (w *Wallet) Name() string {
+	return "Wallet"
+}
+
+func (w *Wallet) Balance() Money {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.balance
+}
+
+func (w *Wallet) TopUp(amount Money) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	balance, err := w.balance.Add(amount)
+	if err != nil {
+		return err
+	}
+	w.balance = balance
+	return nil
+}
+
+func (w *Wallet) Authorize(amount Money) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	available, err := w.balance.Sub(w.held)
+	if err != nil {
+		return err
+	}
+	if available, err = available.Sub(amount); err != nil {
+		return err
+	}
+	if available.Amount < 0 {
+		return ErrInsufficientBalance
+	}
+	w.held, _ = w.held.Add(amount)
+	return nil
+}
+
+func (w *Wallet) Capture() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.held.Amount <= 0 {
+		return ErrNothingAuthorized
+	}
+	w.balance, _ = w.balance.Sub(w.held)
+	w.held = Money{}
+	return nil
+}
+
+// MilesPayment pays with frequent flyer miles at the rate set when it was
+// created. Authorize holds the miles against the account and Capture debits
+// them; a refund credits them back.
+type MilesPayment struct {
+	PassengerID  string
+	program      *FrequentFlyerProgram
+	milesPerUnit int
+	authorized   int
+}
+
+func (ams *AirlineManagementSystem) NewMilesPayment(passengerID string) (*MilesPayment, error) {
+	ams.loyalty.mu.RLock()
+	defer ams.loyalty.mu.RUnlock()
+	if _, ok := ams.loyalty.accounts[passengerID]; !ok {
+		return nil, ErrNotEnrolled
+	}
+	return &MilesPayment{PassengerID: passengerID, program: ams.loyalty, milesPerUnit: ams.loyalty.milesPerUnit}, nil
+}
+
+func (m *MilesPayment) Name() string {
+	return "Miles"
+}
+
+// Miles converts an amount to miles, rounding up to a whole mile.
+func (m *MilesPayment) Miles(amount Money) int {
+	return int((amount.Amount*int64(m.milesPerUnit) + 99) / 100)
+}
+
+func (m *MilesPayment) Authorize(amount Money) error {
+	miles := m.Miles(amount)
+	m.program.mu.Lock()
+	defer m.program.mu.Unlock()
+	account, ok := m.program.accounts[m.PassengerID]
+	if !ok {
+		return ErrNotEnrolled
+	}
+	if account.Balance-account.held < miles {
+		return fmt.Errorf("%w: %d needed, %d available", ErrInsufficientMiles, miles, account.Balance-account.held)
+	}
+	account.held += miles
+	m.authorized += miles
+	return nil
+}
+
+func (m *MilesPayment) Capture() error {
+	m.program.mu.Lock()
+	defer m.program.mu.Unlock()
+	if m.authorized <= 0 {
+		return ErrNothingAuthorized
+	}
+	account, ok := m.program.accounts[m.PassengerID]
+	if !ok {
+		return ErrNotEnrolled
+	}
+	account.held -= m.authorized
+	account.Balance -= m.authorized
+	account.Entries = append(account.Entries, MilesEntry{Miles: -m.authorized, At: m.program.now()})
+	m.authorized = 0
+	return nil
+}
+
+// TopUp credits refunded money back to the account as miles.
+func (m *MilesPayment) TopUp(amount Money) error {
+	miles := m.Miles(amount)
+	m.program.mu.Lock()
+	defer m.program.mu.Unlock()
+	account, ok := m.program.accounts[m.PassengerID]
+	if !ok {
+		return ErrNotEnrolled
+	}
+	account.Balance += miles
+	account.Entries = append(account.Entries, MilesEntry{Miles: miles, At: m.program.now()})
+	return nil
+}
+
+// File: payment_processor.go
+type PaymentProcessor struct {
+	payments    map[string]*Payment
+	refunds     map[string][]*Payment
+	idempotency map[string]*Payment
+	byBooking   map[string][]*Payment
+	byPassenger map[string][]*Payment
+	gateway     PaymentGateway
+	sequence    int
+	now         func() time.Time
+	mu          sync.RWMutex
+}
+
+var (
+	paymentProcessorInstance *PaymentProcessor
+	oncePaymentProcessor     sync.Once
+	paymentProcessorMu       sync.RWMutex
+)
+
+func NewPaymentProcessor() *PaymentProcessor {
+	return NewPaymentProcessorWith(NewSimulatedGateway())
+}
+
+func NewPaymentProcessorWith(gateway PaymentGateway) *PaymentProcessor {
+	return &PaymentProcessor{
+		payments:    make(map[string]*Payment),
+		refunds:     make(map[string][]*Payment),
+		idempotency: make(map[string]*Payment),
+		byBooking:   make(map[string][]*Payment),
+		byPassenger: make(map[string][]*Payment),
+		gateway:     gateway,
+		now:         time.Now,
+	}
+}
+
+func GetPaymentProcessor() *PaymentProcessor {
+	oncePaymentProcessor.Do(func() {
+		paymentProcessorInstance = NewPaymentProcessor()
+	})
+	paymentProcessorMu.RLock()
+	defer paymentProcessorMu.RUnlock()
+	return paymentProcessorInstance
+}
+
+// ResetPaymentProcessor swaps the singleton for a fresh instance so tests can
+// start from a clean slate. Systems built earlier keep their old instance.
+func ResetPaymentProcessor() *PaymentProcessor {
+	oncePaymentProcessor.Do(func() {})
+	paymentProcessorMu.Lock()
+	defer paymentProcessorMu.Unlock()
+	paymentProcessorInstance = NewPaymentProcessor()
+	return paymentProcessorInstance
+}
+
+func (pp *PaymentProcessor) nextPaymentID() string {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.nextPaymentIDLocked()
+}
+
+func (pp *PaymentProcessor) nextPaymentIDLocked() string {
+	for {
+		pp.sequence++
+		id := fmt.Sprintf("PAY%06d", pp.sequence)
+		if _, exists := pp.payments[id]; !exists {
+			return id
+		}
+	}
+}
+
+func (pp *PaymentProcessor) ProcessPayment(payment *Payment) error {
+	if err := pp.admit(payment); err != nil {
+		return err
+	}
+	return pp.settle(context.Background(), payment)
+}
+
+// ProcessPaymentIdempotent charges payment once per key. A retry with a key
+// that was already seen returns the recorded payment, and its outcome, without
+// charging again.
+func (pp *PaymentProcessor) ProcessPaymentIdempotent(key string, payment *Payment) (*Payment, error) {
+	if key == "" {
+		return nil, ErrIdempotencyKeyRequired
+	}
+	pp.mu.Lock()
+	if recorded, ok := pp.idempotency[key]; ok {
+		defer pp.mu.Unlock()
+		switch recorded.Status {
+		case PaymentFailed:
+			return recorded, ErrPaymentDeclined
+		case PaymentPending, PaymentProcessing:
+			return recorded, ErrPaymentInProgress
+		}
+		return recorded, nil
+	}
+	if err := pp.admitLocked(payment); err != nil {
+		pp.mu.Unlock()
+		return nil, err
+	}
+	pp.idempotency[key] = payment
+	pp.mu.Unlock()
+	return payment, pp.settle(context.Background(), payment)
+}
+
+// admitLocked validates a new payment and records it as Pending. A payment
+// ID that is already known is rejected rather than overwritten.
+func (pp *PaymentProcessor) admitLocked(payment *Payment) error {
+	if err := payment.Validate(); err != nil {
+		return err
+	}
+	if _, exists := pp.payments[payment.PaymentID]; exists {
+		return ErrDuplicatePayment
+	}
+	pp.storeLocked(payment)
+	return nil
+}
+
+// settle charges an admitted payment once and marks it Completed or Failed.
+func (pp *PaymentProcessor) settle(ctx context.Context, payment *Payment) error {
+	if err := pp.charge(ctx, payment); err != nil {
+		return pp.failAttempt(payment, err)
+	}
+	return nil
+}
+
+// charge makes one attempt at a Pending payment. The payment is marked
+// Processing and the lock released for the gateway call, so a slow gateway
+// does not hold up other payments; the lock is taken again to record the
+// attempt. A successful charge completes the payment and a failed one puts
+// it back to Pending.
+func (pp *PaymentProcessor) charge(ctx context.Context, payment *Payment) error {
+	pp.mu.Lock()
+	err := pp.transitionLocked(payment, PaymentProcessing)
+	pp.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	txnID, err := pp.gateway.Charge(ctx, payment.Amount, payment.Method)
+	if err != nil {
+		err = fmt.Errorf("%w: %s: %w", ErrPaymentDeclined, payment.Method.Name(), err)
+	}
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	payment.Attempts = append(payment.Attempts, PaymentAttempt{
+		Number: len(payment.Attempts) + 1,
+		At:     pp.now(),
+		Err:    err,
+	})
+	if err != nil {
+		if resetErr := pp.transitionLocked(payment, PaymentPending); resetErr != nil {
+			return errors.Join(err, resetErr)
+		}
+		return err
+	}
+	payment.TransactionID = txnID
+	return pp.transitionLocked(payment, PaymentCompleted)
+}
+
+func (pp *PaymentProcessor) GetPayment(paymentID string) (*Payment, error) {
+	pp.mu.RLock()
+	defer pp.mu.RUnlock()
+	payment, ok := pp.payments[paymentID]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	return payment, nil
+}
+
+func (pp *PaymentProcessor) MarkCompleted(paymentID string) error {
+	return pp.transition(paymentID, PaymentCompleted)
+}
+
+func (pp *PaymentProcessor) MarkFailed(paymentID string) error {
+	return pp.transition(paymentID, PaymentFailed)
+}
+
+// Refund returns whatever is left of a completed payment.
+func (pp *PaymentProcessor) Refund(paymentID string) error {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	payment, ok := pp.payments[paymentID]
+	if !ok {
+		return ErrPaymentNotFound
+	}
+	remaining, err := payment.Amount.Sub(pp.refundedLocked(payment))
+	if err != nil {
+		return err
+	}
+	_, err = pp.refundLocked(payment, remaining)
+	return err
+}
+
+// RefundPayment records a refund of amount against a completed payment.
+// Several partial refunds may be issued until the original amount is used up.
+func (pp *PaymentProcessor) RefundPayment(paymentID string, amount Money) (*Payment, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	payment, ok := pp.payments[paymentID]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	return pp.refundLocked(payment, amount)
+}
+
+// GetRefundsForPayment lists the refunds issued against a payment, oldest
+// first.
+func (pp *PaymentProcessor) GetRefundsForPayment(paymentID string) ([]*Payment, error) {
+	pp.mu.RLock()
+	defer pp.mu.RUnlock()
+	if _, ok := pp.payments[paymentID]; !ok {
+		return nil, ErrPaymentNotFound
+	}
+	refunds := make([]*Payment, len(pp.refunds[paymentID]))
+	copy(refunds, pp.refunds[paymentID])
+	return refunds, nil
+}
+
+func (pp *PaymentProcessor) refundLocked(payment *Payment, amount Money) (*Payment, error) {
+	if payment.Status != PaymentCompleted && payment.Status != PaymentPartiallyRefunded {
+		return nil, fmt.Errorf("%w: payment is %s", ErrPaymentNotRefundable, payment.Status)
+	}
+	if amount.Amount <= 0 {
+		return nil, ErrInvalidRefundAmount
+	}
+	remaining, err := payment.Amount.Sub(pp.refundedLocked(payment))
+	if err != nil {
+		return nil, err
+	}
+	left, err := remaining.Sub(amount)
+	if err != nil {
+		return nil, err
+	}
+	if left.Amount < 0 {
+		return nil, fmt.Errorf("%w: %s requested, %s remaining", ErrRefundExceedsPayment, amount, remaining)
+	}
+	if err := pp.gateway.Refund(context.Background(), payment.TransactionID, amount); err != nil {
+		return nil, err
+	}
+	next := PaymentPartiallyRefunded
+	if left.Amount == 0 {
+		next = PaymentRefunded
+	}
+	if err := pp.transitionLocked(payment, next); err != nil {
+		return nil, err
+	}
+	refund := NewPayment(pp.nextPaymentIDLocked(), amount, payment.Method, PaymentCompleted)
+	refund.RefundOf = payment.PaymentID
+	refund.BookingID = payment.BookingID
+	refund.PassengerID = payment.PassengerID
+	pp.storeLocked(refund)
+	pp.refunds[payment.PaymentID] = append(pp.refunds[payment.PaymentID], refund)
+	return refund, nil
+}
+
+// GetPaymentsForBooking returns every charge and refund recorded against a
+// booking, in the order they were made.
+func (pp *PaymentProcessor) GetPaymentsForBooking(bookingID string) []*Payment {
+	pp.mu.RLock()
+	defer pp.mu.RUnlock()
+	payments := make([]*Payment, len(pp.byBooking[bookingID]))
+	copy(payments, pp.byBooking[bookingID])
+	return payments
+}
+
+// refundedFor totals the refunds issued against the payments, looked up by
+// payment ID so a group payment's refunds count wherever they are filed.
+func (pp *PaymentProcessor) refundedFor(payments []*Payment) Money {
+	pp.mu.RLock()
+	defer pp.mu.RUnlock()
+	total := Money{}
+	for _, payment := range payments {
+		if sum, err := total.Add(pp.refundedLocked(payment)); err == nil {
+			total = sum
+		}
+	}
+	return total
+}
+
+func (pp *PaymentProcessor) storeLocked(payment *Payment) {
+	if pp.payments[payment.PaymentID] == payment {
+		return
+	}
+	if payment.CreatedAt.IsZero() {
+		payment.CreatedAt = pp.now()
+	}
+	pp.payments[payment.PaymentID] = payment
+	if payment.BookingID != "" {
+		pp.byBooking[payment.BookingID] = append(pp.byBooking[payment.BookingID], payment)
+	}
+	if payment.PassengerID != "" {
+		pp.byPassenger[payment.PassengerID] = append(pp.byPassenger[payment.PassengerID], payment)
+	}
+}
+
+func (pp *PaymentProcessor) refundedLocked(payment *Payment) Money {
+	total := Money{Currency: payment.Amount.Currency}
+	for _, refund := range pp.refunds[payment.PaymentID] {
+		total.Amount += refund.Amount.Amount
+	}
+	return total
+}
+
+func (pp *PaymentProcessor) transition(paymentID string, next PaymentStatus) error {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	payment, ok := pp.payments[paymentID]
+	if !ok {
+		return ErrPaymentNotFound
+	}
+	return pp.transitionLocked(payment, next)
+}
+
+func (pp *PaymentProcessor) transitionLocked(payment *Payment, next PaymentStatus) error {
+	status, err := payment.Status.Transition(next)
+	if err != nil {
+		return err
+	}
+	payment.Status = status
+	return nil
+}
+
+// File: payment_retry.go
+// RetryPolicy bounds how often a payment is retried. Backoff[i] is the wait
+// before attempt i+2; the last entry repeats if there are more attempts than
+// entries.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     []time.Duration
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if len(p.Backoff) == 0 {
+		return 0
+	}
+	if attempt > len(p.Backoff) {
+		attempt = len(p.Backoff)
+	}
+	return p.Backoff[attempt-1]
+}
+
+// ProcessPaymentWithRetry charges payment, retrying errors that wrap
+// ErrTransientPayment until policy.MaxAttempts is reached. The payment stays
+// Pending between attempts and is marked Failed once the processor gives up
+// or ctx is done. The processor's lock is not held while waiting.
+func (pp *PaymentProcessor) ProcessPaymentWithRetry(ctx context.Context, payment *Payment, policy RetryPolicy) error {
+	if err := pp.admit(payment); err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return pp.failAttempt(payment, err)
+		}
+		err := pp.charge(ctx, payment)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrTransientPayment) || attempt >= policy.MaxAttempts {
+			return pp.failAttempt(payment, err)
+		}
+		timer := time.NewTimer(policy.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return pp.failAttempt(payment, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (pp *PaymentProcessor) admit(payment *Payment) error {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.admitLocked(payment)
+}
+
+func (pp *PaymentProcessor) failAttempt(payment *Payment, err error) error {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if failErr := pp.transitionLocked(payment, PaymentFailed); failErr != nil {
+		return errors.Join(err, failErr)
+	}
+	return err
+}
+
+// File: payment_status.go
+type PaymentStatus int
+
+const (
+	PaymentPending PaymentStatus = iota
+	PaymentCompleted
+	PaymentFailed
+	PaymentRefunded
+	PaymentPartiallyRefunded
+	PaymentProcessing
+)
+
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentPending:           {PaymentProcessing, PaymentCompleted, PaymentFailed},
+	PaymentProcessing:        {PaymentCompleted, PaymentPending},
+	PaymentCompleted:         {PaymentRefunded, PaymentPartiallyRefunded},
+	PaymentPartiallyRefunded: {PaymentPartiallyRefunded, PaymentRefunded},
+}
+
+func (s PaymentStatus) String() string {
+	switch s {
+	case PaymentPending:
+		return "Pending"
+	case PaymentCompleted:
+		return "Completed"
+	case PaymentFailed:
+		return "Failed"
+	case PaymentRefunded:
+		return "Refunded"
+	case PaymentPartiallyRefunded:
+		return "PartiallyRefunded"
+	case PaymentProcessing:
+		return "Processing"
+	}
+	return fmt.Sprintf("PaymentStatus(%d)", int(s))
+}
+
+// Transition returns next if moving from s to next is a legal step in the
+// payment lifecycle.
+func (s PaymentStatus) Transition(next PaymentStatus) (PaymentStatus, error) {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == next {
+			return next, nil
+		}
+	}
+	return s, fmt.Errorf("%w: %s -> %s", ErrInvalidPaymentTransition, s, next)
+}
+
+// File: quote.go
+// Quote itemizes what a booking will cost before it is made.
+type Quote struct {
+	FlightNumber string
+	SeatNumber   int
+	BaseFare     Money
+	SeatFee      Money
+	Taxes        Money
+	Total        Money
+}
+
+// LineItems lists the non-zero parts of the quote for a receipt.
+func (q *Quote) LineItems() []LineItem {
+	items := []LineItem{{Kind: LineBaseFare, Amount: q.BaseFare}}
+	if !q.SeatFee.IsZero() {
+		items = append(items, LineItem{Kind: LineSeatFee, Amount: q.SeatFee})
+	}
+	if !q.Taxes.IsZero() {
+		items = append(items, LineItem{Kind: LineTax, Amount: q.Taxes})
+	}
+	return items
+}
+
+func newQuote(flight *Flight, seatNumber int) (*Quote, error) {
+	seatFee, err := flight.seatSurcharge(seatNumber)
+	if err != nil {
+		return nil, err
+	}
+	class, err := flight.seatClass(seatNumber)
+	if err != nil {
+		return nil, err
+	}
+	baseFare, ok := flight.FareFor(class)
+	if !ok {
+		baseFare = flight.Fare
+	}
+	return priceQuote(flight, seatNumber, baseFare, seatFee)
+}
+
+// classQuote prices a seat in class without a seat fee, as a booking in that
+// class would be charged. Searches filter and sort on it.
+func classQuote(flight *Flight, class CabinClass) (*Quote, error) {
+	baseFare, ok := flight.FareFor(class)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFareNotConfigured, class)
+	}
+	return priceQuote(flight, 0, baseFare, Money{})
+}
+
+func priceQuote(flight *Flight, seatNumber int, baseFare, seatFee Money) (*Quote, error) {
+	subtotal, err := baseFare.Add(seatFee)
+	if err != nil {
+		return nil, err
+	}
+	taxes := subtotal.Multiply(flight.TaxPercent / 100)
+	total, err := subtotal.Add(taxes)
+	if err != nil {
+		return nil, err
+	}
+	return &Quote{
+		FlightNumber: flight.FlightNumber,
+		SeatNumber:   seatNumber,
+		BaseFare:     baseFare,
+		SeatFee:      seatFee,
+		Taxes:        taxes,
+		Total:        total,
+	}, nil
+}
+
+// QuoteBooking prices a booking with the same options BookFlight accepts,
+// without reserving anything. For auto-assigned seats it quotes the seat
+// that would currently be picked.
+func (ams *AirlineManagementSystem) QuoteBooking(flightNumber string, opts ...BookingOption) (*Quote, error) {
+	var options bookingOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	seatNumber := options.seatNumber
+	if seatNumber == 0 {
+		seatNumber, err = assignSeat(options, flight.findAnySeat)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := checkSeatClass(flight, seatNumber, options); err != nil {
+		return nil, err
+	}
+	return newQuote(flight, seatNumber)
+}
+
+// File: receipt.go
+type LineItemKind string
+
+const (
+	LineBaseFare       LineItemKind = "Base fare"
+	LineSeatFee        LineItemKind = "Seat fee"
+	LineBaggageFee     LineItemKind = "Baggage fee"
+	LineTax            LineItemKind = "Taxes"
+	LineFareDifference LineItemKind = "Fare difference"
+	LineCabinUpgrade   LineItemKind = "Cabin upgrade"
+	LineRefund         LineItemKind = "Refund"
+)
+
+type LineItem struct {
+	Kind   LineItemKind
+	Amount Money
+}
+
+// Receipt itemizes a completed payment. A credit note is issued for a refund
+// and carries negative amounts.
+type Receipt struct {
+	PaymentID  string
+	BookingID  string
+	Items      []LineItem
+	Total      Money
+	Method     string
+	MaskedID   string
+	CreditNote bool
+}
+
+// maskedIdentifier is implemented by payment methods that can show which
+// card or account was charged without revealing it.
+type maskedIdentifier interface {
+	Masked() string
+}
+
+// GenerateReceipt builds a receipt for a completed payment, or a credit note
+// for a refund record. A refund of the whole payment reverses each original
+// line item; a partial refund is a single refund line.
+func (pp *PaymentProcessor) GenerateReceipt(paymentID string) (*Receipt, error) {
+	pp.mu.RLock()
+	defer pp.mu.RUnlock()
+	payment, ok := pp.payments[paymentID]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	if payment.Status == PaymentPending || payment.Status == PaymentProcessing || payment.Status == PaymentFailed {
+		return nil, fmt.Errorf("%w: payment is %s", ErrPaymentNotCompleted, payment.Status)
+	}
+	receipt := &Receipt{
+		PaymentID: payment.PaymentID,
+		BookingID: payment.BookingID,
+		Items:     paymentLineItems(payment),
+		Total:     payment.Amount,
+	}
+	if payment.Method != nil {
+		receipt.Method = payment.Method.Name()
+		if masked, ok := payment.Method.(maskedIdentifier); ok {
+			receipt.MaskedID = masked.Masked()
+		}
+	}
+	if payment.RefundOf == "" {
+		return receipt, nil
+	}
+	receipt.CreditNote = true
+	receipt.Total = payment.Amount.Multiply(-1)
+	receipt.Items = []LineItem{{Kind: LineRefund, Amount: receipt.Total}}
+	if original, ok := pp.payments[payment.RefundOf]; ok && original.Amount == payment.Amount {
+		receipt.Items = paymentLineItems(original)
+		for i := range receipt.Items {
+			receipt.Items[i].Amount = receipt.Items[i].Amount.Multiply(-1)
+		}
+	}
+	return receipt, nil
+}
+
+// paymentLineItems returns a copy of the recorded line items, or a single
+// base fare line for payments made without them.
+func paymentLineItems(payment *Payment) []LineItem {
+	if len(payment.LineItems) == 0 {
+		return []LineItem{{Kind: LineBaseFare, Amount: payment.Amount}}
+	}
+	items := make([]LineItem, len(payment.LineItems))
+	copy(items, payment.LineItems)
+	return items
+}
+
+// String renders the receipt as plain text for email.
+func (r *Receipt) String() string {
+	var sb strings.Builder
+	title, paidBy := "Receipt", "Paid by"
+	if r.CreditNote {
+		title, paidBy = "Credit note", "Refunded to"
+	}
+	fmt.Fprintf(&sb, "%s %s\n", title, r.PaymentID)
+	if r.BookingID != "" {
+		fmt.Fprintf(&sb, "Booking %s\n", r.BookingID)
+	}
+	for _, item := range r.Items {
+		fmt.Fprintf(&sb, "  %-18s %16s\n", item.Kind, item.Amount)
+	}
+	fmt.Fprintf(&sb, "  %-18s %16s\n", "Total", r.Total)
+	if r.Method != "" {
+		fmt.Fprintf(&sb, "%s %s", paidBy, r.Method)
+		if r.MaskedID != "" {
+			fmt.Fprintf(&sb, " %s", r.MaskedID)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// File: refund_rules.go
+// RefundBand refunds Percent of the amount paid, less a flat Fee, when a
+// booking is cancelled at least MinNotice before departure.
+type RefundBand struct {
+	MinNotice time.Duration
+	Percent   float64
+	Fee       Money
+}
+
+// RefundRules picks the band with the longest notice the cancellation still
+// meets; each band runs up to the next longer one. Cancelling with less
+// notice than every band refunds nothing.
+type RefundRules struct {
+	bands []RefundBand
+}
+
+// NewRefundRules validates the bands. Two bands starting at the same notice
+// would overlap and are rejected.
+func NewRefundRules(bands ...RefundBand) (*RefundRules, error) {
+	sorted := make([]RefundBand, len(bands))
+	copy(sorted, bands)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].MinNotice > sorted[j].MinNotice
+	})
+	for i, band := range sorted {
+		if band.MinNotice < 0 || band.Percent < 0 || band.Percent > 100 || band.Fee.Amount < 0 {
+			return nil, fmt.Errorf("%w: %+v", ErrInvalidRefundBand, band)
+		}
+		if i > 0 && band.MinNotice == sorted[i-1].MinNotice {
+			return nil, fmt.Errorf("%w: two bands start at %s", ErrOverlappingRefundBands, band.MinNotice)
+		}
+	}
+	return &RefundRules{bands: sorted}, nil
+}
+
+// clone copies the rules' bands.
+func (r *RefundRules) clone() *RefundRules {
+	if r == nil {
+		return nil
+	}
+	return &RefundRules{bands: append([]RefundBand(nil), r.bands...)}
+}
+
+// DefaultRefundRules refunds in full from 72 hours out, half from 24 hours
+// out and nothing after that.
+func DefaultRefundRules() *RefundRules {
+	rules, _ := NewRefundRules(
+		RefundBand{MinNotice: 72 * time.Hour, Percent: 100},
+		RefundBand{MinNotice: 24 * time.Hour, Percent: 50},
+	)
+	return rules
+}
+
+// Evaluate splits paid into the amount refunded and the fee kept. The flat
+// fee never takes the refund below zero. A fee in a different currency from
+// paid withholds the whole refund.
+func (r *RefundRules) Evaluate(departure, now time.Time, paid Money) (Money, Money) {
+	none := Money{Currency: paid.Currency}
+	notice := departure.Sub(now)
+	for _, band := range r.bands {
+		if notice < band.MinNotice {
+			continue
+		}
+		refund, err := paid.Multiply(band.Percent / 100).Sub(band.Fee)
+		if err != nil {
+			return none, paid
+		}
+		if refund.Amount < 0 {
+			refund = none
+		}
+		fee, _ := paid.Sub(refund)
+		return refund, fee
+	}
+	return none, paid
+}
+
+// WithRefundRules attaches refund rules to every booking on the flight.
+func WithRefundRules(rules *RefundRules) FlightOption {
+	return func(f *Flight) {
+		f.RefundRules = rules
+	}
+}
+
+// WithClassRefundRules attaches refund rules to one cabin class, overriding
+// the flight's rules for bookings in that cabin.
+func WithClassRefundRules(class CabinClass, rules *RefundRules) FlightOption {
+	return func(f *Flight) {
+		if f.ClassRefundRules == nil {
+			f.ClassRefundRules = make(map[CabinClass]*RefundRules)
+		}
+		f.ClassRefundRules[class] = rules
+	}
+}
+
+// SetRefundRules sets the rules used when a flight has none of its own. With
+// no rules at all cancellations are refunded in full.
+func (ams *AirlineManagementSystem) SetRefundRules(rules *RefundRules) {
+	ams.mu.Lock()
+	defer ams.mu.Unlock()
+	ams.refundRules = rules
+}
+
+// refundRulesFor looks for rules on the booking's cabin class, then its
+// flight, then the system default.
+func (ams *AirlineManagementSystem) refundRulesFor(booking *Booking) *RefundRules {
+	flight := booking.Flight
+	if class, err := flight.seatClass(booking.SeatNumber); err == nil {
+		if rules, ok := flight.ClassRefundRules[class]; ok {
+			return rules
+		}
+	}
+	if flight.RefundRules != nil {
+		return flight.RefundRules
+	}
+	ams.mu.RLock()
+	defer ams.mu.RUnlock()
+	return ams.refundRules
+}
+
+// File: round_trip.go
+// BookRoundTrip books an outbound and a return leg under a shared trip ID.
+// opts apply to both legs, except that outSeat and retSeat pick each leg's
+// seat (0 auto-assigns). Each leg is quoted and paid separately; if the
+// return leg fails the outbound leg is cancelled and refunded.
+func (ams *AirlineManagementSystem) BookRoundTrip(outboundFlightNumber, returnFlightNumber string, passenger *Passenger, outSeat, retSeat int, opts ...BookingOption) (*Booking, *Booking, error) {
+	options := bookingOptions{paymentMethod: defaultPaymentMethod()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.paymentMethod == nil {
+		return nil, nil, ErrPaymentMethodRequired
+	}
+	if passenger != nil {
+		registered, err := ams.passengers.resolve(passenger)
+		if err != nil {
+			return nil, nil, err
+		}
+		passenger = registered
+	}
+	outOptions, retOptions := options, options
+	outOptions.seatNumber, retOptions.seatNumber = outSeat, retSeat
+	outBooking, outQuote, err := ams.prepareBooking(outboundFlightNumber, passenger, outOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	retBooking, retQuote, err := ams.prepareBooking(returnFlightNumber, passenger, retOptions)
+	if err != nil {
+		outBooking.Flight.ReleaseSeat(outBooking.SeatNumber)
+		return nil, nil, err
+	}
+	if !retBooking.Flight.Departure.After(outBooking.Flight.Arrival) {
+		outBooking.Flight.ReleaseSeat(outBooking.SeatNumber)
+		retBooking.Flight.ReleaseSeat(retBooking.SeatNumber)
+		return nil, nil, ErrInvalidReturnFlight
+	}
+
+	tripID := ams.bookingManager.newTripID()
+	outBooking.TripID, retBooking.TripID = tripID, tripID
+	outPayment := NewPayment(ams.paymentProcessor.nextPaymentID(), outQuote.Total, options.paymentMethod, PaymentPending)
+	outPayment.LineItems = outQuote.LineItems()
+	if _, err := ams.completeBooking(outBooking, outPayment); err != nil {
+		retBooking.Flight.ReleaseSeat(retBooking.SeatNumber)
+		return nil, nil, err
+	}
+	retPayment := NewPayment(ams.paymentProcessor.nextPaymentID(), retQuote.Total, options.paymentMethod, PaymentPending)
+	retPayment.LineItems = retQuote.LineItems()
+	if _, err := ams.completeBooking(retBooking, retPayment); err != nil {
+		if cancelErr := ams.cancelBooking(outBooking.BookingID, false); cancelErr != nil {
+			return nil, nil, errors.Join(err, cancelErr)
+		}
+		return nil, nil, err
+	}
+	return outBooking, retBooking, nil
+}
+
+// CancelTripLeg cancels one leg of a round trip and, when cascade is set, the
+// other leg as well.
+func (ams *AirlineManagementSystem) CancelTripLeg(bookingID string, cascade bool) error {
+	booking, err := ams.bookingManager.GetBooking(bookingID)
+	if err != nil {
+		return err
+	}
+	if err := ams.cancelBooking(bookingID, true); err != nil {
+		return err
+	}
+	if !cascade || booking.TripID == "" {
+		return nil
+	}
+	for _, leg := range ams.bookingManager.GetBookingsByTrip(booking.TripID) {
+		if leg.BookingID == bookingID || leg.Status == BookingCancelled {
+			continue
+		}
+		if err := ams.cancelBooking(leg.BookingID, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// File: ssr.go
+// SSRCode is an IATA special service request code.
+type SSRCode string
+
+const (
+	SSRWheelchairRamp  SSRCode = "WCHR"
+	SSRWheelchairSteps SSRCode = "WCHS"
+	SSRWheelchairCabin SSRCode = "WCHC"
+	SSRBlind           SSRCode = "BLND"
+	SSRDeaf            SSRCode = "DEAF"
+	SSRVegetarianMeal  SSRCode = "VGML"
+	SSRKosherMeal      SSRCode = "KSML"
+	SSRDiabeticMeal    SSRCode = "DBML"
+	SSRBassinet        SSRCode = "BSCT"
+)
+
+// ssrRules lists the known codes with any check the booking has to pass.
+var ssrRules = map[SSRCode]func(*Booking) error{
+	SSRWheelchairRamp:  nil,
+	SSRWheelchairSteps: nil,
+	SSRWheelchairCabin: nil,
+	SSRBlind:           nil,
+	SSRDeaf:            nil,
+	SSRVegetarianMeal:  nil,
+	SSRKosherMeal:      nil,
+	SSRDiabeticMeal:    nil,
+	SSRBassinet: func(b *Booking) error {
+		if b.Infant == nil {
+			return fmt.Errorf("%w: %s needs a lap infant on the booking", ErrSSRNotAllowed, SSRBassinet)
+		}
+		return nil
+	},
+}
+
+// AddSSR attaches a special service request to the booking. Adding a code
+// the booking already has is a no-op.
+func (ams *AirlineManagementSystem) AddSSR(bookingID string, code SSRCode) error {
+	code = SSRCode(strings.ToUpper(strings.TrimSpace(string(code))))
+	check, ok := ssrRules[code]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownSSR, code)
+	}
+	return ams.bookingManager.addSSR(bookingID, code, check)
+}
+
+// RemoveSSR drops a special service request. Removing a code the booking
+// does not have is a no-op.
+func (ams *AirlineManagementSystem) RemoveSSR(bookingID string, code SSRCode) error {
+	code = SSRCode(strings.ToUpper(strings.TrimSpace(string(code))))
+	if _, ok := ssrRules[code]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownSSR, code)
+	}
+	return ams.bookingManager.removeSSR(bookingID, code)
+}
+
+func (bm *BookingManager) addSSR(bookingID string, code SSRCode, check func(*Booking) error) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return ErrBookingNotFound
+	}
+	if booking.Status == BookingCancelled {
+		return ErrBookingAlreadyCancelled
+	}
+	for _, existing := range booking.SSRs {
+		if existing == code {
+			return nil
+		}
+	}
+	if check != nil {
+		if err := check(booking); err != nil {
+			return err
+		}
+	}
+	booking.SSRs = append(booking.SSRs, code)
+	bm.recordLocked(booking, ActionSSRAdded, string(code))
+	return nil
+}
+
+func (bm *BookingManager) removeSSR(bookingID string, code SSRCode) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return ErrBookingNotFound
+	}
+	for i, existing := range booking.SSRs {
+		if existing == code {
+			booking.SSRs = append(booking.SSRs[:i], booking.SSRs[i+1:]...)
+			bm.recordLocked(booking, ActionSSRRemoved, string(code))
+			return nil
+		}
+	}
+	return nil
+}
+
+// File: schedule.go
+// ScheduleTemplate describes a recurring flight such as "daily at 07:30".
+// DepartureTime is the offset from local midnight in Location, which
+// defaults to the location of the range passed to GenerateFlights. Empty Days
+// means every day of the week.
+type ScheduleTemplate struct {
+	FlightNumber  string
+	Source        string
+	Destination   string
+	DepartureTime time.Duration
+	Duration      time.Duration
+	Days          []time.Weekday
+	Aircraft      *Aircraft
+	Location      *time.Location
+	Options       []FlightOption
+}
+
+func (t ScheduleTemplate) runsOn(day time.Weekday) bool {
+	if len(t.Days) == 0 {
+		return true
+	}
+	for _, d := range t.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateFlights adds a flight for every scheduled departure between from
+// and to, inclusive. Nothing is added if any of them already exists or fails
+// validation.
+func (ams *AirlineManagementSystem) GenerateFlights(template ScheduleTemplate, from, to time.Time) ([]*Flight, error) {
+	if template.DepartureTime < 0 || template.DepartureTime >= 24*time.Hour {
+		return nil, fmt.Errorf("%w: departure time %s is not within a day", ErrInvalidSchedule, template.DepartureTime)
+	}
+	if template.Duration <= 0 {
+		return nil, fmt.Errorf("%w: duration must be positive", ErrInvalidSchedule)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("%w: range ends before it starts", ErrInvalidSchedule)
+	}
+	loc := template.Location
+	if loc == nil {
+		loc = from.Location()
+	}
+	start := from.In(loc)
+	flights := make([]*Flight, 0)
+	for day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc); !day.After(to); day = day.AddDate(0, 0, 1) {
+		departure := day.Add(template.DepartureTime)
+		if departure.Before(from) || departure.After(to) || !template.runsOn(departure.Weekday()) {
+			continue
+		}
+		flight, err := NewFlight(template.FlightNumber, template.Source, template.Destination,
+			departure, departure.Add(template.Duration), template.Aircraft, template.Options...)
+		if err != nil {
+			return nil, err
+		}
+		flights = append(flights, flight)
+	}
+	ams.mu.RLock()
+	for _, flight := range flights {
+		if ams.hasFlightLocked(flight.FlightNumber, ams.flightSearch.localDeparture(flight)) {
+			ams.mu.RUnlock()
+			return nil, fmt.Errorf("%w: %s on %s", ErrDuplicateFlight, flight.FlightNumber, flight.Departure.Format(time.DateOnly))
+		}
+	}
+	ams.mu.RUnlock()
+	for i, flight := range flights {
+		if err := ams.AddFlight(flight); err != nil {
+			for _, added := range flights[:i] {
+				ams.dropFlight(added)
+			}
+			return nil, err
+		}
+	}
+	return flights, nil
+}
+
+// File: seat.go
+type Seat struct {
+	SeatNumber  int
+	Row         int
+	Column      string
+	Class       CabinClass
+	Position    SeatPosition
+	Surcharge   Money
+	ExitRow     bool
+	IsBooked    bool
+	Held        bool
+	Blocked     bool
+	columnIndex int
+	block       int
+}
+
+type SeatPosition int
+
+const (
+	AnyPosition SeatPosition = iota
+	Window
+	Middle
+	Aisle
+)
+
+func (p SeatPosition) String() string {
+	switch p {
+	case AnyPosition:
+		return "Any"
+	case Window:
+		return "Window"
+	case Middle:
+		return "Middle"
+	case Aisle:
+		return "Aisle"
+	}
+	return fmt.Sprintf("SeatPosition(%d)", int(p))
+}
+
+// SeatPreference asks for a seat position and optionally a cabin. With
+// Strict unset, any free seat in the cabin is acceptable when no seat in the
+// preferred position is left.
+type SeatPreference struct {
+	Position SeatPosition
+	Class    *CabinClass
+	Strict   bool
+}
+
+// columnPositions derives window, aisle and middle positions from the block
+// of each column: the outermost columns are windows and columns at the edge
+// of a block are on the aisle.
+func columnPositions(blocks []int) []SeatPosition {
+	positions := make([]SeatPosition, len(blocks))
+	for i := range blocks {
+		switch {
+		case i == 0 || i == len(blocks)-1:
+			positions[i] = Window
+		case blocks[i-1] != blocks[i] || blocks[i+1] != blocks[i]:
+			positions[i] = Aisle
+		default:
+			positions[i] = Middle
+		}
+	}
+	return positions
+}
+
+// columnBlocks maps each column position in a layout such as "ABC DEF" to
+// the aisle-separated block it sits in.
+func columnBlocks(layout string) []int {
+	blocks := make([]int, 0, len(layout))
+	block := 0
+	for _, r := range layout {
+		if r == ' ' {
+			block++
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func (s *Seat) Label() string {
+	return strconv.Itoa(s.Row) + s.Column
+}
+
+// parseSeatLabel splits a label such as "12a" into its row and upper-case
+// column letter.
+func parseSeatLabel(label string) (int, string, error) {
+	label = strings.ToUpper(strings.TrimSpace(label))
+	split := strings.IndexFunc(label, func(r rune) bool { return r < '0' || r > '9' })
+	if split <= 0 || split != len(label)-1 {
+		return 0, "", ErrInvalidSeatLabel
+	}
+	row, err := strconv.Atoi(label[:split])
+	if err != nil || row < 1 {
+		return 0, "", ErrInvalidSeatLabel
+	}
+	column := label[split:]
+	if column[0] < 'A' || column[0] > 'Z' {
+		return 0, "", ErrInvalidSeatLabel
+	}
+	return row, column, nil
+}
+
+// File: seat_block.go
+// BlockSeat takes a seat out of sale, e.g. for a broken recline or crew rest.
+// A booked seat cannot be blocked at the flight level; see
+// AirlineManagementSystem.BlockSeat for reaccommodating its passenger.
+func (f *Flight) BlockSeat(seatNumber int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seatNumber < 1 || seatNumber > len(f.Seats) {
+		return ErrInvalidSeatNumber
+	}
+	seat := f.Seats[seatNumber-1]
+	if seat.IsBooked {
+		return ErrSeatUnavailable
+	}
+	if !seat.Blocked {
+		seat.Blocked = true
+		delete(f.locks, seatNumber)
+		f.version++
+	}
+	return nil
+}
+
+func (f *Flight) UnblockSeat(seatNumber int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seatNumber < 1 || seatNumber > len(f.Seats) {
+		return ErrInvalidSeatNumber
+	}
+	if !f.Seats[seatNumber-1].Blocked {
+		return ErrSeatNotBlocked
+	}
+	f.Seats[seatNumber-1].Blocked = false
+	f.version++
+	return nil
+}
+
+// BlockSeat blocks a seat on the flight. If the seat is booked the call fails
+// unless force is set, in which case the passenger is first moved to another
+// free seat in the same cabin.
+func (ams *AirlineManagementSystem) BlockSeat(flightNumber string, seatNumber int, force bool) error {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return err
+	}
+	err = flight.BlockSeat(seatNumber)
+	if !errors.Is(err, ErrSeatUnavailable) || !force {
+		return err
+	}
+	for _, booking := range ams.bookingManager.activeBookingsOn(flight) {
+		if booking.SeatNumber != seatNumber {
+			continue
+		}
+		class, err := flight.seatClass(seatNumber)
+		if err != nil {
+			return err
+		}
+		newSeat, err := flight.findAnySeat(func(seat *Seat) bool { return seat.Class == class })
+		if err != nil {
+			return err
+		}
+		if err := ams.ChangeSeat(booking.BookingID, newSeat, AgentOverride()); err != nil {
+			return err
+		}
+		break
+	}
+	return flight.BlockSeat(seatNumber)
+}
+
+func (ams *AirlineManagementSystem) UnblockSeat(flightNumber string, seatNumber int) error {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return err
+	}
+	return flight.UnblockSeat(seatNumber)
+}
+
+// File: seat_hold.go
+type SeatHold struct {
+	HoldID     string
+	Flight     *Flight
 	SeatNumber int
-	IsBooked   bool
+	ExpiresAt  time.Time
+}
+
+// HoldSeat reserves a seat for ttl while the customer completes payment.
+// Holds that are not confirmed in time are released lazily.
+func (ams *AirlineManagementSystem) HoldSeat(flightNumber string, seatNumber int, ttl time.Duration) (string, error) {
+	ams.ExpireHolds()
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return "", err
+	}
+	if err := ams.checkBookable(flight); err != nil {
+		return "", err
+	}
+	if err := flight.holdSeat(seatNumber); err != nil {
+		return "", err
+	}
+	ams.holdsMu.Lock()
+	defer ams.holdsMu.Unlock()
+	ams.holdSequence++
+	hold := &SeatHold{
+		HoldID:     fmt.Sprintf("HLD%06d", ams.holdSequence),
+		Flight:     flight,
+		SeatNumber: seatNumber,
+		ExpiresAt:  ams.clock().Add(ttl),
+	}
+	ams.holds[hold.HoldID] = hold
+	return hold.HoldID, nil
+}
+
+// ConfirmHold turns a live hold into a booking for the passenger.
+func (ams *AirlineManagementSystem) ConfirmHold(holdID string, passenger *Passenger, payment *Payment) (*Booking, error) {
+	if err := payment.Validate(); err != nil {
+		return nil, err
+	}
+	ams.holdsMu.Lock()
+	hold, ok := ams.holds[holdID]
+	if !ok {
+		ams.holdsMu.Unlock()
+		return nil, ErrHoldNotFound
+	}
+	delete(ams.holds, holdID)
+	ams.holdsMu.Unlock()
+
+	if !ams.clock().Before(hold.ExpiresAt) {
+		hold.Flight.ReleaseSeat(hold.SeatNumber)
+		return nil, ErrHoldExpired
+	}
+	hold.Flight.clearHold(hold.SeatNumber)
+	booking := NewBooking(ams.bookingManager.NewBookingID(), hold.Flight, passenger, hold.SeatNumber)
+	return ams.completeBooking(booking, payment)
+}
+
+// holdSeat reserves the seat and flags it as held so seat maps can tell a
+// pending checkout apart from a completed booking.
+func (f *Flight) holdSeat(seatNumber int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reserveSeatLocked(seatNumber); err != nil {
+		return err
+	}
+	f.Seats[seatNumber-1].Held = true
+	return nil
+}
+
+func (f *Flight) clearHold(seatNumber int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seatNumber >= 1 && seatNumber <= len(f.Seats) {
+		f.Seats[seatNumber-1].Held = false
+	}
+}
+
+// ExpireHolds releases every hold whose TTL has elapsed. It is called lazily
+// by the hold APIs and can also be driven from a ticker.
+func (ams *AirlineManagementSystem) ExpireHolds() int {
+	now := ams.clock()
+	ams.holdsMu.Lock()
+	expired := make([]*SeatHold, 0)
+	for id, hold := range ams.holds {
+		if !now.Before(hold.ExpiresAt) {
+			expired = append(expired, hold)
+			delete(ams.holds, id)
+		}
+	}
+	ams.holdsMu.Unlock()
+	for _, hold := range expired {
+		hold.Flight.ReleaseSeat(hold.SeatNumber)
+	}
+	return len(expired)
+}
+
+// File: seat_lock.go
+type seatLock struct {
+	owner     string
+	expiresAt time.Time
+}
+
+// LockSeat soft-locks a free seat for owner while they are choosing seats.
+// A locked seat is skipped by auto-assignment and can only be booked by its
+// owner until the lock expires. Locking again as the same owner extends it.
+func (f *Flight) LockSeat(seatNumber int, owner string, ttl time.Duration) error {
+	if owner == "" {
+		return ErrLockOwnerRequired
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seatNumber < 1 || seatNumber > len(f.Seats) {
+		return ErrInvalidSeatNumber
+	}
+	if f.Seats[seatNumber-1].IsBooked {
+		return ErrSeatUnavailable
+	}
+	if f.lockedByOtherLocked(seatNumber, owner) {
+		return ErrSeatLocked
+	}
+	if f.locks == nil {
+		f.locks = make(map[int]seatLock)
+	}
+	f.locks[seatNumber] = seatLock{owner: owner, expiresAt: f.clockLocked().Add(ttl)}
+	return nil
+}
+
+func (f *Flight) UnlockSeat(seatNumber int, owner string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lock, ok := f.locks[seatNumber]
+	if !ok || lock.owner != owner || !f.clockLocked().Before(lock.expiresAt) {
+		return ErrSeatLockNotHeld
+	}
+	delete(f.locks, seatNumber)
+	return nil
+}
+
+// BookSeatAs books a seat on behalf of owner, honouring seat locks.
+func (f *Flight) BookSeatAs(seatNumber int, owner string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reserveSeatAsLocked(seatNumber, owner)
+}
+
+// lockedByOtherLocked reports whether a live lock held by someone other than
+// owner covers the seat. Expired locks are swept as they are found.
+func (f *Flight) lockedByOtherLocked(seatNumber int, owner string) bool {
+	lock, ok := f.locks[seatNumber]
+	if !ok {
+		return false
+	}
+	if !f.clockLocked().Before(lock.expiresAt) {
+		delete(f.locks, seatNumber)
+		return false
+	}
+	return lock.owner != owner
+}
+
+// assignableLocked reports whether auto-assignment may hand out the seat.
+func (f *Flight) assignableLocked(seat *Seat) bool {
+	return !seat.IsBooked && !seat.Blocked && !f.lockedByOtherLocked(seat.SeatNumber, "")
+}
+
+// clockLocked reads the flight's time source, which the system points at
+// its own injectable clock when the flight is added.
+func (f *Flight) clockLocked() time.Time {
+	if f.now == nil {
+		return time.Now()
+	}
+	return f.now()
+}
+
+func (ams *AirlineManagementSystem) LockSeat(flightNumber string, seatNumber int, owner string, ttl time.Duration) error {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return err
+	}
+	return flight.LockSeat(seatNumber, owner, ttl)
+}
+
+func (ams *AirlineManagementSystem) UnlockSeat(flightNumber string, seatNumber int, owner string) error {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return err
+	}
+	return flight.UnlockSeat(seatNumber, owner)
+}
+
+// File: seat_map.go
+const seatMapLegend = "Legend: [ ] free  [X] booked  [H] held  [#] blocked"
+
+// RenderSeatMap draws the seat map row by row using the aircraft's column
+// layout, e.g. "Row 12: [X][ ][ ]  [ ][X][ ]". Seats soft-locked during
+// selection are shown as held. A short last row leaves its missing seats
+// blank so the aisles stay aligned.
+func (f *Flight) RenderSeatMap() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	byLabel := make(map[string]*Seat, len(f.Seats))
+	lastRow := 0
+	for _, seat := range f.Seats {
+		byLabel[seat.Label()] = seat
+		if seat.Row > lastRow {
+			lastRow = seat.Row
+		}
+	}
+	width := len(strconv.Itoa(lastRow))
+	prefix := strings.Repeat(" ", len("Row : ")+width)
+
+	var b strings.Builder
+	row := 0
+	for _, cabin := range f.Aircraft.cabinLayouts() {
+		layout := f.Aircraft.columnsFor(cabin)
+		columns := len(strings.ReplaceAll(layout, " ", ""))
+		fmt.Fprintf(&b, "%s\n", cabin.Class)
+		header := prefix
+		for _, r := range layout {
+			if r == ' ' {
+				header += "  "
+			} else {
+				header += " " + string(r) + " "
+			}
+		}
+		b.WriteString(strings.TrimRight(header, " ") + "\n")
+		for i := 0; i < (cabin.Seats+columns-1)/columns; i++ {
+			row++
+			line := fmt.Sprintf("Row %*d: ", width, row)
+			for _, r := range layout {
+				if r == ' ' {
+					line += "  "
+					continue
+				}
+				seat, ok := byLabel[strconv.Itoa(row)+string(r)]
+				if !ok {
+					line += "   "
+					continue
+				}
+				line += "[" + f.seatGlyphLocked(seat) + "]"
+			}
+			b.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+	}
+	b.WriteString(seatMapLegend + "\n")
+	return b.String()
+}
+
+func (f *Flight) seatGlyphLocked(seat *Seat) string {
+	switch {
+	case seat.Blocked:
+		return "#"
+	case seat.Held:
+		return "H"
+	case seat.IsBooked:
+		return "X"
+	case f.lockedByOtherLocked(seat.SeatNumber, ""):
+		return "H"
+	}
+	return " "
+}
+
+// File: split_payment.go
+// PaymentSplit is one portion of a booking paid with a given method.
+type PaymentSplit struct {
+	Method PaymentMethod
+	Amount Money
+}
+
+// SplitPaymentResult reports each portion's payment and how much was kept in
+// the end. After a failure, portions captured earlier show as Refunded and
+// Charged is zero.
+type SplitPaymentResult struct {
+	Payments []*Payment
+	Charged  Money
+}
+
+// BookFlightSplit books like BookFlight but pays the quoted total with
+// several methods, charged in the order given. The split amounts must add up
+// to the quote exactly.
+func (ams *AirlineManagementSystem) BookFlightSplit(flightNumber string, passenger *Passenger, splits []PaymentSplit, opts ...BookingOption) (*Booking, *SplitPaymentResult, error) {
+	if len(splits) == 0 {
+		return nil, nil, ErrPaymentMethodRequired
+	}
+	options := bookingOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	booking, quote, err := ams.prepareBooking(flightNumber, passenger, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	total := Money{}
+	for _, split := range splits {
+		if total, err = total.Add(split.Amount); err != nil {
+			booking.Flight.ReleaseSeat(booking.SeatNumber)
+			return nil, nil, err
+		}
+	}
+	if total != quote.Total {
+		booking.Flight.ReleaseSeat(booking.SeatNumber)
+		return nil, nil, fmt.Errorf("%w: %s split, %s quoted", ErrSplitTotalMismatch, total, quote.Total)
+	}
+	result := &SplitPaymentResult{Payments: make([]*Payment, len(splits))}
+	for i, split := range splits {
+		result.Payments[i] = NewPayment(ams.paymentProcessor.nextPaymentID(), split.Amount, split.Method, PaymentPending)
+	}
+	result.Payments[0].LineItems = quote.LineItems()
+	_, err = ams.completeBooking(booking, result.Payments...)
+	result.Charged = Money{Currency: quote.Total.Currency}
+	for _, payment := range result.Payments {
+		if payment.Status == PaymentCompleted {
+			result.Charged.Amount += payment.Amount.Amount
+		}
+	}
+	if err != nil {
+		return nil, result, err
+	}
+	return booking, result, nil
+}
+
+// File: standby.go
+type StandbyEntry struct {
+	Passenger *Passenger
+	JoinedAt  time.Time
+}
+
+// CheckInCloseSummary reports the outcome of closing check-in. NotCleared
+// lists the standby passengers left without a seat, to be rebooked.
+type CheckInCloseSummary struct {
+	FlightNumber string
+	NoShows      int
+	Cleared      []*Booking
+	NotCleared   []*Passenger
+}
+
+// AddToStandby puts a passenger on the standby list of a full flight and
+// returns their place in the current clearing order.
+func (ams *AirlineManagementSystem) AddToStandby(flightNumber string, passenger *Passenger) (int, error) {
+	if passenger == nil {
+		return 0, ErrPassengerIDRequired
+	}
+	passenger, err := ams.passengers.resolve(passenger)
+	if err != nil {
+		return 0, err
+	}
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return 0, err
+	}
+	if err := ams.checkBookable(flight); err != nil {
+		return 0, err
+	}
+	if flight.AvailableSeatCount() > 0 {
+		return 0, ErrFlightNotFull
+	}
+	if err := flight.addStandby(StandbyEntry{Passenger: passenger, JoinedAt: ams.clock()}); err != nil {
+		return 0, err
+	}
+	for i, entry := range ams.standbyOrder(flight.standbyList()) {
+		if entry.Passenger == passenger {
+			return i + 1, nil
+		}
+	}
+	return 0, ErrPassengerNotFound
+}
+
+// StandbyList returns the standby passengers of a flight in clearing order.
+func (ams *AirlineManagementSystem) StandbyList(flightNumber string) ([]StandbyEntry, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	return ams.standbyOrder(flight.standbyList()), nil
+}
+
+// CloseCheckIn ends check-in for the flight. Confirmed passengers who have
+// not checked in become no-shows and give up their seats, then every free
+// seat is offered to the standby list, highest frequent flyer tier first and
+// earliest joined within a tier. Cleared passengers are booked at the fare
+// of their seat and checked in straight away.
+func (ams *AirlineManagementSystem) CloseCheckIn(flightNumber string) (*CheckInCloseSummary, error) {
+	flight, err := ams.findFlight(flightNumber)
+	if err != nil {
+		return nil, err
+	}
+	if err := ams.checkBookable(flight); err != nil {
+		return nil, err
+	}
+	standby := ams.standbyOrder(flight.closeCheckIn())
+	noShows, released := ams.bookingManager.markNoShows(flight)
+	flight.releaseSeats(released)
+
+	summary := &CheckInCloseSummary{
+		FlightNumber: flight.FlightNumber,
+		NoShows:      noShows,
+		Cleared:      make([]*Booking, 0),
+		NotCleared:   make([]*Passenger, 0),
+	}
+	for _, entry := range standby {
+		booking, err := ams.clearStandby(flight, entry.Passenger)
+		if err != nil {
+			summary.NotCleared = append(summary.NotCleared, entry.Passenger)
+			continue
+		}
+		summary.Cleared = append(summary.Cleared, booking)
+	}
+	return summary, nil
+}
+
+// clearStandby books and checks in one standby passenger. The seat is taken
+// under the flight lock, so two passengers never clear into the same seat.
+func (ams *AirlineManagementSystem) clearStandby(flight *Flight, passenger *Passenger) (*Booking, error) {
+	options := bookingOptions{allowPaidSeat: true, allowExitRow: passenger.ExitRowEligible()}
+	seatNumber, err := assignSeat(options, flight.reserveAnySeat)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := newQuote(flight, seatNumber)
+	if err != nil {
+		flight.ReleaseSeat(seatNumber)
+		return nil, err
+	}
+	payment := NewPayment(ams.paymentProcessor.nextPaymentID(), quote.Total, defaultPaymentMethod(), PaymentPending)
+	payment.LineItems = quote.LineItems()
+	booking := NewBooking(ams.bookingManager.NewBookingID(), flight, passenger, seatNumber)
+	booking.MarketingNumber = flight.FlightNumber
+	if _, err := ams.completeBooking(booking, payment); err != nil {
+		return nil, err
+	}
+	group := ams.boardingGroupFor(flight, seatNumber, passenger)
+	if _, err := ams.bookingManager.checkIn(booking.BookingID, 0, group, ams.clock()); err != nil {
+		return nil, err
+	}
+	return booking, nil
+}
+
+// standbyOrder sorts entries by frequent flyer tier, then by join time.
+// Passengers outside the program rank with Blue members.
+func (ams *AirlineManagementSystem) standbyOrder(entries []StandbyEntry) []StandbyEntry {
+	tiers := make(map[*Passenger]FrequentFlyerTier, len(entries))
+	for _, entry := range entries {
+		if account, err := ams.GetAccount(entry.Passenger.PassengerID); err == nil {
+			tiers[entry.Passenger] = account.Tier
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if tiers[a.Passenger] != tiers[b.Passenger] {
+			return tiers[a.Passenger] > tiers[b.Passenger]
+		}
+		return a.JoinedAt.Before(b.JoinedAt)
+	})
+	return entries
+}
+
+func (f *Flight) addStandby(entry StandbyEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.checkInClosed {
+		return ErrCheckInClosed
+	}
+	for _, existing := range f.standby {
+		if existing.Passenger.PassengerID == entry.Passenger.PassengerID {
+			return ErrAlreadyOnStandby
+		}
+	}
+	f.standby = append(f.standby, entry)
+	return nil
+}
+
+func (f *Flight) standbyList() []StandbyEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]StandbyEntry(nil), f.standby...)
+}
+
+// closeCheckIn marks check-in closed and hands over the standby list.
+func (f *Flight) closeCheckIn() []StandbyEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checkInClosed = true
+	standby := f.standby
+	f.standby = nil
+	return standby
+}
+
+// markNoShows moves confirmed bookings that never checked in to NoShow and
+// returns how many there were and the seats they held.
+func (bm *BookingManager) markNoShows(flight *Flight) (int, []int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	released := make([]int, 0)
+	for _, booking := range bm.byFlight[flight.FlightNumber] {
+		if booking.Flight != flight || booking.Status != BookingConfirmed {
+			continue
+		}
+		bm.recordLocked(booking, ActionCheckInClosed, fmt.Sprintf("%s -> %s", booking.Status, BookingNoShow))
+		booking.Status = BookingNoShow
+		released = append(released, booking.SeatNumber)
+	}
+	return len(released), released
+}
+
+// File: travel_document.go
+// passportValidityMonths is how long a passport must stay valid after arrival.
+const passportValidityMonths = 6
+
+type TravelDocument struct {
+	Number      string
+	Nationality string
+	Expiry      time.Time
+}
+
+// checkTravelDocument requires passengers on international flights to carry
+// a passport valid for six months after the scheduled arrival. A passport
+// expiring exactly six months after arrival is accepted.
+func checkTravelDocument(flight *Flight, passenger *Passenger) error {
+	if passenger == nil || !flight.International {
+		return nil
+	}
+	document := passenger.Document
+	if document == nil || document.Number == "" {
+		return ErrTravelDocumentRequired
+	}
+	validUntil := flight.Arrival.AddDate(0, passportValidityMonths, 0)
+	if document.Expiry.Before(validUntil) {
+		return fmt.Errorf("%w: %s expires %s, must be valid until %s", ErrTravelDocumentExpiring,
+			document.Number, document.Expiry.Format(time.DateOnly), validUntil.Format(time.DateOnly))
+	}
+	return nil
+}
+
+// File: unaccompanied_minor.go
+type Guardian struct {
+	Name  string
+	Phone string
+}
+
+// UnaccompaniedMinorForm names who hands the child over at departure and who
+// collects them on arrival.
+type UnaccompaniedMinorForm struct {
+	AtOrigin      Guardian
+	AtDestination Guardian
+}
+
+func (f *UnaccompaniedMinorForm) Validate() error {
+	for _, guardian := range []Guardian{f.AtOrigin, f.AtDestination} {
+		if strings.TrimSpace(guardian.Name) == "" {
+			return fmt.Errorf("%w: guardian name is required", ErrUnaccompaniedMinorForm)
+		}
+		if _, err := normalizePhone(guardian.Phone); err != nil {
+			return fmt.Errorf("%w: %w", ErrUnaccompaniedMinorForm, err)
+		}
+	}
+	return nil
+}
+
+func checkUnaccompaniedMinor(flight *Flight, form *UnaccompaniedMinorForm) error {
+	if flight.RefuseMinors {
+		return ErrMinorNotAccepted
+	}
+	if form == nil {
+		return ErrUnaccompaniedMinorForm
+	}
+	return form.Validate()
+}
+
+// RequiresAirportCheckIn reports whether the booking has to be checked in
+// by an agent, as unaccompanied minors are.
+func (b *Booking) RequiresAirportCheckIn() bool {
+	return b.UnaccompaniedMinor != nil
+}
+
+// File: upgrade.go
+// UpgradeBooking moves a booking into a higher cabin on the same flight and
+// charges the fare difference. The new seat is secured and paid for before
+// the old one is released, so a failed payment leaves the booking untouched.
+func (ams *AirlineManagementSystem) UpgradeBooking(bookingID string, targetClass CabinClass, opts ...ChangeOption) (*Payment, error) {
+	booking, err := ams.bookingManager.GetBooking(bookingID)
+	if err != nil {
+		return nil, err
+	}
+	status := ams.bookingManager.statusOf(booking)
+	if err := checkChangeable(status); err != nil {
+		return nil, err
+	}
+	if err := checkUnlocked(status, opts); err != nil {
+		return nil, err
+	}
+	flight := booking.Flight
+	currentClass, err := flight.seatClass(booking.SeatNumber)
+	if err != nil {
+		return nil, err
+	}
+	if targetClass <= currentClass {
+		return nil, ErrInvalidUpgrade
+	}
+	targetFare, ok := flight.FareFor(targetClass)
+	if !ok {
+		return nil, ErrFareNotConfigured
+	}
+	currentFare, ok := flight.FareFor(currentClass)
+	if !ok {
+		currentFare = flight.Fare
+	}
+	difference, err := targetFare.Sub(currentFare)
+	if err != nil {
+		return nil, err
+	}
+
+	options := bookingOptions{class: &targetClass, allowPaidSeat: true}
+	newSeat, err := assignSeat(options, flight.reserveAnySeat)
+	if errors.Is(err, ErrNoSeatsAvailable) {
+		return nil, ErrCabinFull
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	method := defaultPaymentMethod()
+	if booking.Payment != nil {
+		method = booking.Payment.Method
+	}
+	payment := NewPayment(ams.paymentProcessor.nextPaymentID(), difference, method, PaymentPending)
+	payment.BookingID = bookingID
+	payment.PassengerID = booking.Passenger.PassengerID
+	payment.LineItems = []LineItem{{Kind: LineCabinUpgrade, Amount: difference}}
+	if err := ams.paymentProcessor.ProcessPayment(payment); err != nil {
+		flight.ReleaseSeat(newSeat)
+		return nil, err
+	}
+	oldSeat, err := ams.bookingManager.reseat(bookingID, newSeat)
+	if err != nil {
+		flight.ReleaseSeat(newSeat)
+		return nil, errors.Join(err, ams.paymentProcessor.Refund(payment.PaymentID))
+	}
+	flight.ReleaseSeat(oldSeat)
+	ams.refreshBoardingGroup(bookingID)
+	return payment, nil
+}
+
+// checkChangeable allows changes only to bookings that are still going to
+// fly: confirmed or checked in.
+func checkChangeable(status BookingStatus) error {
+	switch status {
+	case BookingConfirmed, BookingCheckedIn:
+		return nil
+	case BookingCancelled:
+		return ErrBookingAlreadyCancelled
+	}
+	return fmt.Errorf("%w: booking is %s", ErrBookingNotChangeable, status)
+}
+
+// reseat points the booking at a seat the caller has already reserved and
+// returns the seat it held before.
+func (bm *BookingManager) reseat(bookingID string, newSeat int) (int, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	booking, ok := bm.bookings[bookingID]
+	if !ok {
+		return 0, ErrBookingNotFound
+	}
+	if err := checkChangeable(booking.Status); err != nil {
+		return 0, err
+	}
+	oldSeat := booking.SeatNumber
+	bm.recordLocked(booking, ActionSeatChanged, fmt.Sprintf("seat %d -> %d", oldSeat, newSeat))
+	booking.SeatNumber = newSeat
+	bm.supersedeBoardingPassLocked(booking, "upgraded")
+	return oldSeat, nil
 }
@@ -0,0 +1,2445 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2031, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestFlight(t *testing.T, flightNumber string, departure time.Time, aircraft *Aircraft, opts ...FlightOption) *Flight {
+	t.Helper()
+	flight, err := NewFlight(flightNumber, "DEL", "BOM", departure, departure.Add(2*time.Hour), aircraft, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flight
+}
+
+// newTestSystem returns a system whose clock reads *now.
+func newTestSystem() (*AirlineManagementSystem, *time.Time) {
+	now := testStart
+	ams := NewAirlineManagementSystemWith(NewBookingManager(), NewPaymentProcessor())
+	ams.SetClock(func() time.Time { return now })
+	return ams, &now
+}
+
+func addTestFlight(t *testing.T, ams *AirlineManagementSystem, flightNumber string, departure time.Time, seats int, opts ...FlightOption) *Flight {
+	t.Helper()
+	flight := newTestFlight(t, flightNumber, departure, NewAircraft("VT-"+flightNumber, "A320", seats), opts...)
+	flight.Fare = NewMoney(500000, "INR")
+	if err := ams.AddFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+	return flight
+}
+
+func newTestPassenger(t *testing.T, passengerID, name string) *Passenger {
+	t.Helper()
+	passenger, err := NewPassenger(passengerID, name, strings.ToLower(passengerID)+"@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return passenger
+}
+
+// flightNumbers lists the flights' numbers in order, for comparing search
+// results.
+func flightNumbers(flights []*Flight) string {
+	numbers := make([]string, len(flights))
+	for i, flight := range flights {
+		numbers[i] = flight.FlightNumber
+	}
+	return strings.Join(numbers, " ")
+}
+
+func TestBookSeatConcurrent(t *testing.T) {
+	flight := newTestFlight(t, "AI101", testStart, NewAircraft("VT-ALA", "A320", 10))
+	const callers = 100
+	wins := make([]int, len(flight.Seats)+1)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(seatNumber int) {
+			defer wg.Done()
+			err := flight.BookSeat(seatNumber)
+			if err != nil && !errors.Is(err, ErrSeatUnavailable) {
+				t.Error(err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				wins[seatNumber]++
+				mu.Unlock()
+			}
+		}(i%len(flight.Seats) + 1)
+	}
+	wg.Wait()
+	for seatNumber := 1; seatNumber < len(wins); seatNumber++ {
+		if wins[seatNumber] != 1 {
+			t.Errorf("seat %d booked %d times, want 1", seatNumber, wins[seatNumber])
+		}
+	}
+}
+
+func TestBookingStatusTransition(t *testing.T) {
+	statuses := []BookingStatus{BookingPending, BookingConfirmed, BookingCancelled, BookingCheckedIn, BookingCompleted, BookingNoShow}
+	legal := map[[2]BookingStatus]bool{
+		{BookingPending, BookingConfirmed}:   true,
+		{BookingPending, BookingCancelled}:   true,
+		{BookingConfirmed, BookingCancelled}: true,
+		{BookingConfirmed, BookingCheckedIn}: true,
+		{BookingConfirmed, BookingNoShow}:    true,
+		{BookingCheckedIn, BookingCompleted}: true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			t.Run(from.String()+"->"+to.String(), func(t *testing.T) {
+				got, err := from.Transition(to)
+				if legal[[2]BookingStatus{from, to}] {
+					if err != nil || got != to {
+						t.Fatalf("Transition = %s, %v; want %s", got, err, to)
+					}
+					return
+				}
+				if !errors.Is(err, ErrInvalidStatusTransition) || got != from {
+					t.Fatalf("Transition = %s, %v; want %s, ErrInvalidStatusTransition", got, err, from)
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	bm := NewBookingManager()
+	flight := newTestFlight(t, "AI101", testStart, NewAircraft("VT-ALA", "A320", 10))
+	booking := NewBooking(bm.NewBookingID(), flight, nil, 1)
+	if err := bm.AddBooking(booking); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		to      BookingStatus
+		wantErr error
+	}{
+		{BookingCheckedIn, ErrInvalidStatusTransition},
+		{BookingConfirmed, nil},
+		{BookingCheckedIn, nil},
+		{BookingCancelled, ErrInvalidStatusTransition},
+		{BookingCompleted, nil},
+		{BookingConfirmed, ErrInvalidStatusTransition},
+	}
+	for _, tt := range tests {
+		err := bm.UpdateStatus(booking.BookingID, tt.to)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("UpdateStatus(%s) = %v, want %v", tt.to, err, tt.wantErr)
+		}
+	}
+	if booking.Status != BookingCompleted {
+		t.Fatalf("status = %s, want Completed", booking.Status)
+	}
+	if err := bm.UpdateStatus("NOPE", BookingCancelled); !errors.Is(err, ErrBookingNotFound) {
+		t.Fatal(err)
+	}
+}
+
+func TestRebookToFlightChecksTarget(t *testing.T) {
+	ams, _ := newTestSystem()
+	addTestFlight(t, ams, "AI101", testStart.Add(48*time.Hour), 10)
+	target := addTestFlight(t, ams, "AI103", testStart.Add(50*time.Hour), 10)
+	booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ams.CancelFlight("AI103", "crew shortage"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.RebookToFlight(booking.BookingID, "AI103", 1, false); !errors.Is(err, ErrFlightCancelled) {
+		t.Fatalf("RebookToFlight onto cancelled flight = %v, want ErrFlightCancelled", err)
+	}
+	if target.Seats[0].IsBooked {
+		t.Fatal("seat on cancelled flight was reserved")
+	}
+}
+
+func TestSeatHoldExpiry(t *testing.T) {
+	ams, now := newTestSystem()
+	flight := addTestFlight(t, ams, "AI101", testStart.Add(48*time.Hour), 10)
+	payment := func(id string) *Payment {
+		return NewPayment(id, flight.Fare, defaultPaymentMethod(), PaymentPending)
+	}
+
+	holdID, err := ams.HoldSeat("AI101", 3, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flight.BookSeat(3); !errors.Is(err, ErrSeatUnavailable) {
+		t.Fatalf("BookSeat on held seat = %v, want ErrSeatUnavailable", err)
+	}
+	*now = now.Add(10*time.Minute - time.Nanosecond)
+	booking, err := ams.ConfirmHold(holdID, newTestPassenger(t, "P1", "Asha Rao"), payment("PAY-1"))
+	if err != nil || booking.SeatNumber != 3 {
+		t.Fatalf("ConfirmHold just before expiry = %v, %v", booking, err)
+	}
+
+	holdID, err = ams.HoldSeat("AI101", 4, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(10 * time.Minute)
+	if _, err := ams.ConfirmHold(holdID, newTestPassenger(t, "P2", "Ravi Iyer"), payment("PAY-2")); !errors.Is(err, ErrHoldExpired) {
+		t.Fatalf("ConfirmHold at expiry = %v, want ErrHoldExpired", err)
+	}
+	if err := flight.BookSeat(4); err != nil {
+		t.Fatalf("seat not bookable after hold expired: %v", err)
+	}
+
+	if _, err := ams.HoldSeat("AI101", 5, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(time.Minute)
+	if n := ams.ExpireHolds(); n != 1 {
+		t.Fatalf("ExpireHolds = %d, want 1", n)
+	}
+	if flight.Seats[4].IsBooked || flight.Seats[4].Held {
+		t.Fatal("expired hold still occupies seat 5")
+	}
+}
+
+func TestGroupBookingPricesEachSeat(t *testing.T) {
+	ams, _ := newTestSystem()
+	aircraft := NewAircraftWithCabins("VT-GRP", "A320",
+		CabinLayout{Class: Business, Seats: 4, Columns: "AB CD"},
+		CabinLayout{Class: Economy, Seats: 12, Columns: "ABC DEF"})
+	flight := newTestFlight(t, "AI201", testStart.Add(48*time.Hour), aircraft)
+	flight.Fare = NewMoney(500000, "INR")
+	flight.Fares = map[CabinClass]Money{Business: NewMoney(2000000, "INR"), Economy: NewMoney(500000, "INR")}
+	if err := ams.AddFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+	passengers := []*Passenger{newTestPassenger(t, "P1", "Asha Rao"), newTestPassenger(t, "P2", "Ravi Iyer")}
+	group, err := ams.CreateGroupBooking("AI201", passengers, []int{1, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := NewMoney(2500000, "INR"); group.Payment.Amount != want {
+		t.Fatalf("group payment = %s, want %s", group.Payment.Amount, want)
+	}
+	if group.Shares[0] != NewMoney(2000000, "INR") || group.Shares[1] != NewMoney(500000, "INR") {
+		t.Fatalf("shares = %v", group.Shares)
+	}
+	if _, err := ams.Passengers().GetPassenger("P2"); err != nil {
+		t.Fatalf("group member not registered: %v", err)
+	}
+	if _, err := ams.CreateGroupBooking("AI201", []*Passenger{passengers[0], nil}, nil); !errors.Is(err, ErrPassengerRequired) {
+		t.Fatalf("nil member = %v, want ErrPassengerRequired", err)
+	}
+}
+
+func TestNewBookingIDUnique(t *testing.T) {
+	bm := NewBookingManager()
+	flight := newTestFlight(t, "AI101", testStart, NewAircraft("VT-ALA", "A320", 10))
+	const n = 100000
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		id := bm.NewBookingID()
+		if len(id) != pnrLength || strings.Trim(id, pnrAlphabet) != "" {
+			t.Fatalf("NewBookingID = %q, want %d characters from %q", id, pnrLength, pnrAlphabet)
+		}
+		if seen[id] {
+			t.Fatalf("NewBookingID repeated %q after %d IDs", id, i)
+		}
+		seen[id] = true
+		// IDs are only checked against stored bookings, as the booking APIs
+		// store each one before asking for the next.
+		if err := bm.AddBooking(NewBooking(id, flight, nil, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSeatLockExpiry(t *testing.T) {
+	ams, now := newTestSystem()
+	flight := addTestFlight(t, ams, "AI101", testStart.Add(48*time.Hour), 10)
+	if err := ams.LockSeat("AI101", 3, "session-a", 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.LockSeat("AI101", 3, "session-b", 2*time.Minute); !errors.Is(err, ErrSeatLocked) {
+		t.Fatalf("LockSeat by second owner = %v, want ErrSeatLocked", err)
+	}
+	if err := flight.BookSeatAs(3, "session-b"); !errors.Is(err, ErrSeatLocked) {
+		t.Fatalf("BookSeatAs by second owner = %v, want ErrSeatLocked", err)
+	}
+	if seat, err := flight.AutoAssignSeat(nil); err != nil || seat == 3 {
+		t.Fatalf("AutoAssignSeat = %d, %v; locked seat must be skipped", seat, err)
+	}
+	*now = now.Add(2*time.Minute - time.Second)
+	if err := flight.BookSeatAs(3, "session-b"); !errors.Is(err, ErrSeatLocked) {
+		t.Fatalf("BookSeatAs before expiry = %v, want ErrSeatLocked", err)
+	}
+	*now = now.Add(time.Second)
+	if err := flight.BookSeatAs(3, "session-b"); err != nil {
+		t.Fatalf("BookSeatAs after expiry = %v", err)
+	}
+	if err := ams.UnlockSeat("AI101", 3, "session-a"); !errors.Is(err, ErrSeatLockNotHeld) {
+		t.Fatalf("UnlockSeat after expiry = %v, want ErrSeatLockNotHeld", err)
+	}
+}
+
+func TestRenderSeatMapGolden(t *testing.T) {
+	aircraft := NewAircraftWithCabins("VT-MAP", "A320",
+		CabinLayout{Class: Business, Seats: 4, Columns: "AC DF"},
+		CabinLayout{Class: Economy, Seats: 8, Columns: "ABC DEF"})
+	flight := newTestFlight(t, "AI301", testStart, aircraft)
+	if err := flight.BookSeat(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := flight.BlockSeat(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := flight.LockSeat(6, "session-a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := flight.BookSeat(12); err != nil {
+		t.Fatal(err)
+	}
+	const want = `Business
+        A  C    D  F
+Row 1: [ ][X]  [ ][ ]
+Economy
+        A  B  C    D  E  F
+Row 2: [#][H][ ]  [ ][ ][ ]
+Row 3: [ ][X]
+Legend: [ ] free  [X] booked  [H] held  [#] blocked
+`
+	if got := flight.RenderSeatMap(); got != want {
+		t.Fatalf("RenderSeatMap mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUpgradeBookingRequiresActiveBooking(t *testing.T) {
+	ams, _ := newTestSystem()
+	aircraft := NewAircraftWithCabins("VT-UPG", "A320",
+		CabinLayout{Class: Business, Seats: 4, Columns: "AB CD"},
+		CabinLayout{Class: Economy, Seats: 12, Columns: "ABC DEF"})
+	flight := newTestFlight(t, "AI401", testStart.Add(48*time.Hour), aircraft)
+	flight.Fare = NewMoney(500000, "INR")
+	flight.Fares = map[CabinClass]Money{Business: NewMoney(2000000, "INR"), Economy: NewMoney(500000, "INR")}
+	if err := ams.AddFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+	booking, _, err := ams.BookFlight("AI401", newTestPassenger(t, "P1", "Asha Rao"), WithSeat(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.bookingManager.UpdateStatus(booking.BookingID, BookingNoShow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ams.UpgradeBooking(booking.BookingID, Business); !errors.Is(err, ErrBookingNotChangeable) {
+		t.Fatalf("UpgradeBooking on no-show = %v, want ErrBookingNotChangeable", err)
+	}
+	if got := len(ams.paymentProcessor.GetPaymentsForBooking(booking.BookingID)); got != 1 {
+		t.Fatalf("%d payments on booking, want only the fare", got)
+	}
+}
+
+func TestPaymentStatusTransition(t *testing.T) {
+	statuses := []PaymentStatus{PaymentPending, PaymentProcessing, PaymentCompleted, PaymentFailed, PaymentRefunded, PaymentPartiallyRefunded}
+	legal := map[[2]PaymentStatus]bool{
+		{PaymentPending, PaymentProcessing}:                  true,
+		{PaymentProcessing, PaymentCompleted}:                true,
+		{PaymentProcessing, PaymentPending}:                  true,
+		{PaymentPending, PaymentCompleted}:                   true,
+		{PaymentPending, PaymentFailed}:                      true,
+		{PaymentCompleted, PaymentRefunded}:                  true,
+		{PaymentCompleted, PaymentPartiallyRefunded}:         true,
+		{PaymentPartiallyRefunded, PaymentPartiallyRefunded}: true,
+		{PaymentPartiallyRefunded, PaymentRefunded}:          true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			t.Run(from.String()+"->"+to.String(), func(t *testing.T) {
+				got, err := from.Transition(to)
+				if legal[[2]PaymentStatus{from, to}] {
+					if err != nil || got != to {
+						t.Fatalf("Transition = %s, %v; want %s", got, err, to)
+					}
+					return
+				}
+				if !errors.Is(err, ErrInvalidPaymentTransition) || got != from {
+					t.Fatalf("Transition = %s, %v; want %s, ErrInvalidPaymentTransition", got, err, from)
+				}
+			})
+		}
+	}
+}
+
+func TestPaymentProcessorTransitions(t *testing.T) {
+	pp := NewPaymentProcessor()
+	amount := NewMoney(100000, "INR")
+
+	failed := NewPayment("PAY-F", amount, NewCreditCard(DeclinedCardNumber, "Asha Rao"), PaymentPending)
+	if err := pp.ProcessPayment(failed); !errors.Is(err, ErrPaymentDeclined) {
+		t.Fatalf("ProcessPayment with declined card = %v", err)
+	}
+	completed := NewPayment("PAY-C", amount, defaultPaymentMethod(), PaymentPending)
+	if err := pp.ProcessPayment(completed); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		apply   func() error
+		wantErr error
+		payment *Payment
+		want    PaymentStatus
+	}{
+		{"refund failed", func() error { return pp.Refund("PAY-F") }, ErrPaymentNotRefundable, failed, PaymentFailed},
+		{"complete failed", func() error { return pp.MarkCompleted("PAY-F") }, ErrInvalidPaymentTransition, failed, PaymentFailed},
+		{"fail completed", func() error { return pp.MarkFailed("PAY-C") }, ErrInvalidPaymentTransition, completed, PaymentCompleted},
+		{"partial refund", func() error {
+			_, err := pp.RefundPayment("PAY-C", NewMoney(40000, "INR"))
+			return err
+		}, nil, completed, PaymentPartiallyRefunded},
+		{"refund rest", func() error { return pp.Refund("PAY-C") }, nil, completed, PaymentRefunded},
+		{"refund refunded", func() error { return pp.Refund("PAY-C") }, ErrPaymentNotRefundable, completed, PaymentRefunded},
+		{"unknown payment", func() error { return pp.MarkCompleted("PAY-X") }, ErrPaymentNotFound, completed, PaymentRefunded},
+	}
+	for _, tt := range tests {
+		if err := tt.apply(); !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if tt.payment.Status != tt.want {
+			t.Fatalf("%s: status = %s, want %s", tt.name, tt.payment.Status, tt.want)
+		}
+	}
+}
+
+// blockingGateway holds every charge until release is closed.
+type blockingGateway struct {
+	*SimulatedGateway
+	charging chan struct{}
+	release  chan struct{}
+}
+
+func (g *blockingGateway) Charge(ctx context.Context, amount Money, method PaymentMethod) (string, error) {
+	g.charging <- struct{}{}
+	<-g.release
+	return g.SimulatedGateway.Charge(ctx, amount, method)
+}
+
+func TestProcessPaymentReleasesLockDuringCharge(t *testing.T) {
+	gateway := &blockingGateway{NewSimulatedGateway(), make(chan struct{}), make(chan struct{})}
+	pp := NewPaymentProcessorWith(gateway)
+	payment := NewPayment("PAY-1", NewMoney(100000, "INR"), defaultPaymentMethod(), PaymentPending)
+	done := make(chan error)
+	go func() { done <- pp.ProcessPayment(payment) }()
+	<-gateway.charging
+
+	got, err := pp.GetPayment("PAY-1")
+	if err != nil || got.Status != PaymentProcessing {
+		t.Fatalf("GetPayment during charge = %v, %v; want Processing", got, err)
+	}
+	if _, err := pp.GenerateReceipt("PAY-1"); !errors.Is(err, ErrPaymentNotCompleted) {
+		t.Fatalf("GenerateReceipt during charge = %v, want ErrPaymentNotCompleted", err)
+	}
+	close(gateway.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != PaymentCompleted || payment.TransactionID == "" {
+		t.Fatalf("after charge: status %s, transaction %q", payment.Status, payment.TransactionID)
+	}
+}
+
+func TestRefundRulesBoundaries(t *testing.T) {
+	rules := DefaultRefundRules()
+	departure := testStart.Add(30 * 24 * time.Hour)
+	paid := NewMoney(1000000, "INR")
+	tests := []struct {
+		notice     time.Duration
+		wantRefund int64
+	}{
+		{72*time.Hour + time.Nanosecond, 1000000},
+		{72 * time.Hour, 1000000},
+		{72*time.Hour - time.Nanosecond, 500000},
+		{24 * time.Hour, 500000},
+		{24*time.Hour - time.Nanosecond, 0},
+		{0, 0},
+		{-time.Hour, 0},
+	}
+	for _, tt := range tests {
+		refund, fee := rules.Evaluate(departure, departure.Add(-tt.notice), paid)
+		if refund.Amount != tt.wantRefund || fee.Amount != paid.Amount-tt.wantRefund {
+			t.Errorf("notice %s: refund %s fee %s, want refund %d", tt.notice, refund, fee, tt.wantRefund)
+		}
+	}
+}
+
+func TestCancelBookingAtBandBoundary(t *testing.T) {
+	ams, now := newTestSystem()
+	ams.SetRefundRules(DefaultRefundRules())
+	departure := testStart.Add(7 * 24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10)
+	tests := []struct {
+		notice  time.Duration
+		wantFee int64
+	}{
+		{72 * time.Hour, 0},
+		{72*time.Hour - time.Second, 250000},
+		{24 * time.Hour, 250000},
+		{24*time.Hour - time.Second, 500000},
+	}
+	for i, tt := range tests {
+		*now = testStart
+		booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, fmt.Sprintf("P%d", i), "Asha Rao"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		*now = departure.Add(-tt.notice)
+		if err := ams.CancelBooking(booking.BookingID); err != nil {
+			t.Fatal(err)
+		}
+		if booking.CancellationFee.Amount != tt.wantFee {
+			t.Errorf("notice %s: fee %s, want %d", tt.notice, booking.CancellationFee, tt.wantFee)
+		}
+	}
+}
+
+func TestBookingReceiptItemisesTaxes(t *testing.T) {
+	ams, _ := newTestSystem()
+	addTestFlight(t, ams, "AI101", testStart.Add(7*24*time.Hour), 10, WithTaxPercent(5))
+	_, payment, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := NewMoney(525000, "INR"); payment.Amount != want {
+		t.Fatalf("payment = %s, want %s", payment.Amount, want)
+	}
+	receipt, err := ams.paymentProcessor.GenerateReceipt(payment.PaymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var taxes Money
+	for _, item := range receipt.Items {
+		if item.Kind == LineTax {
+			taxes = item.Amount
+		}
+	}
+	if want := NewMoney(25000, "INR"); taxes != want {
+		t.Errorf("taxes line = %s, want %s\n%s", taxes, want, receipt)
+	}
+}
+
+// benchmarkFlights spreads n flights over the routes between 20 airports
+// and the 30 days after testStart.
+func benchmarkFlights(b *testing.B, n int) []*Flight {
+	b.Helper()
+	aircraft := NewAircraft("VT-BEN", "A320", 10)
+	flights := make([]*Flight, n)
+	for i := range flights {
+		source, destination := i%20, (i/20)%19
+		if destination >= source {
+			destination++
+		}
+		departure := testStart.Add(time.Duration(i%720) * time.Hour)
+		flight, err := NewFlight(fmt.Sprintf("BN%d", i), benchmarkAirport(source), benchmarkAirport(destination), departure, departure.Add(2*time.Hour), aircraft)
+		if err != nil {
+			b.Fatal(err)
+		}
+		flights[i] = flight
+	}
+	return flights
+}
+
+func benchmarkAirport(i int) string {
+	return fmt.Sprintf("A%c%c", 'A'+i/26, 'A'+i%26)
+}
+
+func BenchmarkSearchFlights(b *testing.B) {
+	fs := NewFlightSearch(func() time.Time { return testStart })
+	for _, flight := range benchmarkFlights(b, 100000) {
+		fs.addFlight(flight)
+	}
+	date := testStart.Add(10 * 24 * time.Hour)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs.SearchFlights("AAA", "AAB", date)
+	}
+}
+
+// BenchmarkSearchFlightsLinear scans every flight per query, as searches did
+// before the route index.
+func BenchmarkSearchFlightsLinear(b *testing.B) {
+	flights := benchmarkFlights(b, 100000)
+	date := testStart.Add(10 * 24 * time.Hour)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results := make([]*Flight, 0)
+		for _, flight := range flights {
+			if flight.Source == "AAA" && flight.Destination == "AAB" && flight.CurrentStatus() != FlightCancelled && sameDay(flight.departureTime(), date) {
+				results = append(results, flight.clone())
+			}
+		}
+	}
+}
+
+func TestSearchFlightsConcurrentAdd(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		flight := newTestFlight(t, fmt.Sprintf("AI%d", 100+i), departure, NewAircraft(fmt.Sprintf("VT-%d", i), "A320", 10))
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := ams.AddFlight(flight); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			ams.SearchFlights("DEL", "BOM", departure)
+		}()
+	}
+	wg.Wait()
+	if got := len(ams.SearchFlights("DEL", "BOM", departure)); got != 50 {
+		t.Fatalf("found %d flights, want 50", got)
+	}
+}
+
+func TestSearchConnectionsSkipsCancelledSecondLeg(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	legs := []struct {
+		number, source, destination string
+		departure                   time.Time
+	}{
+		{"AI101", "DEL", "BOM", departure},
+		{"AI201", "BOM", "GOI", departure.Add(3 * time.Hour)},
+		{"AI203", "BOM", "GOI", departure.Add(4 * time.Hour)},
+	}
+	for _, leg := range legs {
+		flight, err := NewFlight(leg.number, leg.source, leg.destination, leg.departure, leg.departure.Add(2*time.Hour), NewAircraft("VT-"+leg.number, "A320", 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ams.AddFlight(flight); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ams.CancelFlight("AI201", "crew shortage"); err != nil {
+		t.Fatal(err)
+	}
+	connections, err := ams.SearchConnections("DEL", "GOI", departure, 30*time.Minute, 6*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 1 || connections[0].Second.FlightNumber != "AI203" {
+		t.Fatalf("connections = %v, want only via AI203", connections)
+	}
+}
+
+func TestSearchFlightsFlexibleSkipsCancelled(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(3 * 24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10)
+	addTestFlight(t, ams, "AI103", departure.Add(24*time.Hour), 10)
+	if _, err := ams.CancelFlight("AI101", "weather"); err != nil {
+		t.Fatal(err)
+	}
+	results := ams.SearchFlightsFlexible("DEL", "BOM", departure, 1)
+	if got := results[departure.Format(time.DateOnly)]; len(got) != 0 {
+		t.Errorf("cancelled day = %d flights, want none", len(got))
+	}
+	if got := results[departure.AddDate(0, 0, 1).Format(time.DateOnly)]; len(got) != 1 {
+		t.Errorf("next day = %d flights, want 1", len(got))
+	}
+}
+
+func TestSearchMaxPriceUsesQuotedPrice(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10)
+	addTestFlight(t, ams, "AI103", departure.Add(time.Hour), 10, WithTaxPercent(5))
+	businessOnly := newTestFlight(t, "AI105", departure.Add(2*time.Hour), NewAircraft("VT-AI105", "A320", 10))
+	businessOnly.Fares = map[CabinClass]Money{Business: NewMoney(2000000, "INR")}
+	if err := ams.AddFlight(businessOnly); err != nil {
+		t.Fatal(err)
+	}
+	criteria, err := NewSearchCriteria("DEL", "BOM", departure, WithMaxPrice(NewMoney(500000, "INR")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ams.Search(criteria)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Flights) != 1 || result.Flights[0].FlightNumber != "AI101" {
+		t.Errorf("flights = %v, want only AI101", result.Flights)
+	}
+	if result.MissingFare != 1 {
+		t.Errorf("MissingFare = %d, want 1", result.MissingFare)
+	}
+}
+
+func TestSearchFlightsAirportMidnight(t *testing.T) {
+	ist := time.FixedZone("IST", 5*60*60+30*60)
+	tests := []struct {
+		departure time.Time
+		wantDay   int
+	}{
+		{time.Date(2031, 1, 10, 18, 29, 0, 0, time.UTC), 10},
+		{time.Date(2031, 1, 10, 18, 30, 0, 0, time.UTC), 11},
+		{time.Date(2031, 1, 10, 23, 59, 0, 0, time.UTC), 11},
+		{time.Date(2031, 1, 11, 0, 0, 0, 0, time.UTC), 11},
+	}
+	for i, tt := range tests {
+		ams, _ := newTestSystem()
+		if err := ams.SetAirportTimezone("DEL", ist); err != nil {
+			t.Fatal(err)
+		}
+		addTestFlight(t, ams, fmt.Sprintf("AI%d", 101+i), tt.departure, 10)
+		for _, day := range []int{10, 11} {
+			found := len(ams.SearchFlights("DEL", "BOM", time.Date(2031, 1, day, 0, 0, 0, 0, ist))) == 1
+			if found != (day == tt.wantDay) {
+				t.Errorf("departure %s: found on %d Jan IST = %t", tt.departure.Format(time.RFC3339), day, found)
+			}
+		}
+	}
+}
+
+func TestCreateBookingRejectsUnbookableFlight(t *testing.T) {
+	ams, now := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10)
+	addTestFlight(t, ams, "AI103", departure, 10)
+	if _, err := ams.CancelFlight("AI103", "weather"); err != nil {
+		t.Fatal(err)
+	}
+	payment := func(id string) *Payment {
+		return NewPayment(id, NewMoney(500000, "INR"), defaultPaymentMethod(), PaymentPending)
+	}
+	passenger := newTestPassenger(t, "P1", "Asha Rao")
+	if _, err := ams.CreateBooking("AI103", passenger, 0, payment("PAY-1")); !errors.Is(err, ErrFlightCancelled) {
+		t.Errorf("CreateBooking on cancelled flight = %v, want ErrFlightCancelled", err)
+	}
+	*now = departure
+	if _, err := ams.CreateBooking("AI101", passenger, 0, payment("PAY-2")); !errors.Is(err, ErrFlightDeparted) {
+		t.Errorf("CreateBooking at departure = %v, want ErrFlightDeparted", err)
+	}
+}
+
+func TestCancelFlightRefundsEveryBooking(t *testing.T) {
+	ams, now := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10)
+	single, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkedIn, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P2", "Ravi Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = departure.Add(-2 * time.Hour)
+	if _, err := ams.CheckIn(checkedIn.BookingID, *now); err != nil {
+		t.Fatal(err)
+	}
+	group, err := ams.CreateGroupBooking("AI101", []*Passenger{
+		newTestPassenger(t, "P3", "Meera Iyer"),
+		newTestPassenger(t, "P4", "Kiran Iyer"),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ams.CancelFlight("AI101", "aircraft on ground")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failures) != 0 {
+		t.Fatalf("failures = %v", report.Failures)
+	}
+	if len(report.Cancelled) != 4 {
+		t.Errorf("cancelled %v, want 4 bookings", report.Cancelled)
+	}
+	if want := NewMoney(2000000, "INR"); report.Refunded != want {
+		t.Errorf("refunded %s, want %s", report.Refunded, want)
+	}
+	for _, booking := range []*Booking{single, checkedIn, group.Bookings[0], group.Bookings[1]} {
+		if booking.Status != BookingCancelled {
+			t.Errorf("booking %s is %s", booking.BookingID, booking.Status)
+		}
+	}
+	if group.Payment.Status != PaymentRefunded {
+		t.Errorf("group payment is %s, want Refunded", group.Payment.Status)
+	}
+}
+
+func TestChangeAircraftChecksConflictsAndKeepsBlockedSeats(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	flight := addTestFlight(t, ams, "AI101", departure, 10)
+	busy := NewAircraft("VT-BSY", "A320", 10)
+	spare := NewAircraft("VT-SPR", "A320", 10)
+	for _, aircraft := range []*Aircraft{busy, spare} {
+		if err := ams.AddAircraft(aircraft); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ams.AddFlight(newTestFlight(t, "AI201", departure.Add(time.Hour), busy)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ams.ChangeAircraft("AI101", "VT-BSY", false); !errors.Is(err, ErrAircraftConflict) {
+		t.Fatalf("ChangeAircraft onto a busy aircraft = %v, want ErrAircraftConflict", err)
+	}
+
+	if err := ams.BlockSeat("AI101", 4, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ams.ChangeAircraft("AI101", "VT-SPR", false); err != nil {
+		t.Fatal(err)
+	}
+	if !flight.Seats[3].Blocked {
+		t.Error("seat 4 was unblocked by the aircraft change")
+	}
+}
+
+func TestBookFlightRefusesEmailOfAnotherPassenger(t *testing.T) {
+	ams, _ := newTestSystem()
+	addTestFlight(t, ams, "AI101", testStart.Add(24*time.Hour), 10)
+	first := newTestPassenger(t, "P1", "Asha Rao")
+	if _, _, err := ams.BookFlight("AI101", first); err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Passenger != first {
+		t.Error("rebooking by the same PassengerID did not reuse the registered record")
+	}
+	impostor, err := NewPassenger("P2", "Ravi Kumar", first.Email, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ams.BookFlight("AI101", impostor); !errors.Is(err, ErrDuplicatePassenger) {
+		t.Fatalf("BookFlight with another passenger's email = %v, want ErrDuplicatePassenger", err)
+	}
+}
+
+func TestCheckTravelDocumentExpiryBoundary(t *testing.T) {
+	departure := testStart.Add(24 * time.Hour)
+	international := newTestFlight(t, "AI101", departure, NewAircraft("VT-AI101", "A320", 10), International())
+	domestic := newTestFlight(t, "AI103", departure, NewAircraft("VT-AI103", "A320", 10))
+	validUntil := international.Arrival.AddDate(0, passportValidityMonths, 0)
+	tests := []struct {
+		name     string
+		flight   *Flight
+		document *TravelDocument
+		wantErr  error
+	}{
+		{"exactly six months", international, &TravelDocument{Number: "Z1", Expiry: validUntil}, nil},
+		{"one second short", international, &TravelDocument{Number: "Z1", Expiry: validUntil.Add(-time.Second)}, ErrTravelDocumentExpiring},
+		{"one day short", international, &TravelDocument{Number: "Z1", Expiry: validUntil.AddDate(0, 0, -1)}, ErrTravelDocumentExpiring},
+		{"one day spare", international, &TravelDocument{Number: "Z1", Expiry: validUntil.AddDate(0, 0, 1)}, nil},
+		{"no document", international, nil, ErrTravelDocumentRequired},
+		{"no number", international, &TravelDocument{Expiry: validUntil}, ErrTravelDocumentRequired},
+		{"domestic without document", domestic, nil, nil},
+	}
+	for _, tt := range tests {
+		passenger := newTestPassenger(t, "P1", "Asha Rao")
+		passenger.Document = tt.document
+		if err := checkTravelDocument(tt.flight, passenger); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestErasePassengerClearsDocumentAndMinorForm(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10)
+	child := newTestPassenger(t, "P1", "Anu Rao")
+	child.Type = Child
+	child.Document = &TravelDocument{Number: "Z1234567", Nationality: "IN", Expiry: departure.AddDate(5, 0, 0)}
+	form := &UnaccompaniedMinorForm{
+		AtOrigin:      Guardian{Name: "Asha Rao", Phone: "+91 98765-43210"},
+		AtDestination: Guardian{Name: "Ravi Rao", Phone: "+91 98765-43211"},
+	}
+	booking, _, err := ams.BookFlight("AI101", child, WithUnaccompaniedMinor(form))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.Passengers().ErasePassenger("P1", ForceErase()); err != nil {
+		t.Fatal(err)
+	}
+	if child.Document != nil {
+		t.Error("travel document kept after erasure")
+	}
+	if booking.UnaccompaniedMinor != nil {
+		t.Error("guardian form kept after erasure")
+	}
+}
+
+func TestCheckInWindowBoundaries(t *testing.T) {
+	departure := testStart.Add(7 * 24 * time.Hour)
+	tests := []struct {
+		name    string
+		opts    []FlightOption
+		before  time.Duration
+		wantErr error
+	}{
+		{"before default opening", nil, defaultCheckInOpens + time.Second, ErrCheckInNotOpen},
+		{"default opening", nil, defaultCheckInOpens, nil},
+		{"default closing", nil, defaultCheckInCloses, nil},
+		{"after default closing", nil, defaultCheckInCloses - time.Second, ErrCheckInClosed},
+		{"before custom opening", []FlightOption{WithCheckInWindow(24*time.Hour, 2*time.Hour)}, 24*time.Hour + time.Second, ErrCheckInNotOpen},
+		{"custom opening", []FlightOption{WithCheckInWindow(24*time.Hour, 2*time.Hour)}, 24 * time.Hour, nil},
+		{"custom closing", []FlightOption{WithCheckInWindow(24*time.Hour, 2*time.Hour)}, 2 * time.Hour, nil},
+		{"after custom closing", []FlightOption{WithCheckInWindow(24*time.Hour, 2*time.Hour)}, 2*time.Hour - time.Second, ErrCheckInClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ams, _ := newTestSystem()
+			addTestFlight(t, ams, "AI101", departure, 10, tt.opts...)
+			booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ams.CheckIn(booking.BookingID, departure.Add(-tt.before))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckIn %s before departure = %v, want %v", tt.before, err, tt.wantErr)
+			}
+			if want := tt.wantErr == nil; (booking.Status == BookingCheckedIn) != want {
+				t.Errorf("status = %s after CheckIn", booking.Status)
+			}
+		})
+	}
+}
+
+func TestBoardingPassBarcodeGolden(t *testing.T) {
+	pass := &BoardingPass{
+		BookingID:     "ABC123",
+		PassengerName: "Asha Rao",
+		FlightNumber:  "AI101",
+		Source:        "DEL",
+		Destination:   "BOM",
+		Departure:     time.Date(2031, 1, 8, 6, 30, 0, 0, time.UTC),
+		Seat:          "12C",
+		BoardingGroup: 3,
+		Terminal:      "T3",
+		Gate:          "42",
+	}
+	const barcode = "M1ASHA RAO            EABC123 DELBOMAI101   20310108012C03"
+	if got := pass.barcodeFields().encode(); got != barcode {
+		t.Fatalf("barcode:\n got %q\nwant %q", got, barcode)
+	}
+	fields, err := ParseBarcode(barcode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BarcodeFields{
+		PassengerName: "ASHA RAO",
+		BookingID:     "ABC123",
+		Source:        "DEL",
+		Destination:   "BOM",
+		FlightNumber:  "AI101",
+		Date:          time.Date(2031, 1, 8, 0, 0, 0, 0, time.UTC),
+		Seat:          "12C",
+		BoardingGroup: 3,
+	}
+	if *fields != want {
+		t.Errorf("ParseBarcode = %+v, want %+v", *fields, want)
+	}
+
+	pass.Barcode = barcode
+	const rendered = `BOARDING PASS  ABC123
+  Passenger  Asha Rao
+  Flight     AI101  08 Jan 2031 06:30
+  Route      DEL -> BOM
+  Seat       12C  Group 3
+  Gate       T3 42
+  M1ASHA RAO            EABC123 DELBOMAI101   20310108012C03
+`
+	if got := pass.Render(); got != rendered {
+		t.Errorf("Render:\n%s\nwant:\n%s", got, rendered)
+	}
+}
+
+func TestBaggageLoadReportCountsOffloadedBags(t *testing.T) {
+	ams, _ := newTestSystem()
+	addTestFlight(t, ams, "AI101", testStart.Add(24*time.Hour), 10)
+	booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := make([]string, 2)
+	for i := range tags {
+		if tags[i], err = ams.AddBaggage(booking.BookingID, Baggage{WeightKg: 5, Type: CheckedBag}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ams.UpdateBaggageStatus(tags[i], BaggageLoaded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := ams.BaggageLoadReport("AI101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Mismatch() {
+		t.Fatalf("all bags loaded but Mismatch: %+v", report)
+	}
+	if err := ams.UpdateBaggageStatus(tags[1], BaggageOffloaded); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = ams.BaggageLoadReport("AI101"); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Mismatch() || report.Offloaded != 1 || len(report.NotLoaded) != 1 || report.NotLoaded[0] != tags[1] {
+		t.Errorf("after offloading %s: %+v", tags[1], report)
+	}
+}
+
+func TestAddFlightIsSearchable(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	if got := ams.SearchFlights("DEL", "BOM", departure); len(got) != 0 {
+		t.Fatalf("found %d flights before any were added", len(got))
+	}
+	addTestFlight(t, ams, "AI101", departure, 10)
+	got := ams.SearchFlights("DEL", "BOM", departure)
+	if len(got) != 1 || got[0].FlightNumber != "AI101" {
+		t.Fatalf("SearchFlights after AddFlight = %v, want AI101", got)
+	}
+}
+
+func TestLookupsReportNotFound(t *testing.T) {
+	ams, _ := newTestSystem()
+	addTestFlight(t, ams, "AI101", testStart.Add(24*time.Hour), 10)
+	booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ams.bookingManager.GetBooking(booking.BookingID); err != nil || got != booking {
+		t.Fatalf("GetBooking(%s) = %v, %v", booking.BookingID, got, err)
+	}
+	tests := []struct {
+		name    string
+		lookup  func() error
+		wantErr error
+	}{
+		{"booking", func() error { _, err := ams.bookingManager.GetBooking("NOPE42"); return err }, ErrBookingNotFound},
+		{"group", func() error { _, err := ams.bookingManager.GetGroupBooking("NOPE42"); return err }, ErrGroupNotFound},
+		{"payment", func() error { _, err := ams.paymentProcessor.GetPayment("PAY-X"); return err }, ErrPaymentNotFound},
+		{"aircraft", func() error { _, err := ams.GetAircraft("VT-XXX"); return err }, ErrAircraftNotFound},
+		{"cancel", func() error { return ams.CancelBooking("NOPE42") }, ErrBookingNotFound},
+	}
+	for _, tt := range tests {
+		if err := tt.lookup(); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAddFlightRejectsDuplicates(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10)
+	duplicate := newTestFlight(t, "AI101", departure.Add(3*time.Hour), NewAircraft("VT-DUP", "A320", 10))
+	if err := ams.AddFlight(duplicate); !errors.Is(err, ErrDuplicateFlight) {
+		t.Fatalf("AddFlight with a duplicate number = %v, want ErrDuplicateFlight", err)
+	}
+
+	flights := make([]*Flight, 20)
+	for i := range flights {
+		flights[i] = newTestFlight(t, "AI202", departure, NewAircraft(fmt.Sprintf("VT-C%02d", i), "A320", 10))
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	added := 0
+	for _, flight := range flights {
+		wg.Add(1)
+		go func(flight *Flight) {
+			defer wg.Done()
+			err := ams.AddFlight(flight)
+			if err != nil && !errors.Is(err, ErrDuplicateFlight) {
+				t.Error(err)
+			}
+			if err == nil {
+				mu.Lock()
+				added++
+				mu.Unlock()
+			}
+		}(flight)
+	}
+	wg.Wait()
+	if added != 1 {
+		t.Fatalf("%d concurrent adds of AI202 succeeded, want 1", added)
+	}
+}
+
+func TestAddAircraft(t *testing.T) {
+	ams, _ := newTestSystem()
+	aircraft := NewAircraft("VT-ABC", "A320", 180)
+	if err := ams.AddAircraft(aircraft); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ams.GetAircraft("VT-ABC"); err != nil || got != aircraft {
+		t.Fatalf("GetAircraft = %v, %v; want the registered aircraft", got, err)
+	}
+	tests := []struct {
+		name     string
+		aircraft *Aircraft
+		wantErr  error
+	}{
+		{"duplicate tail", NewAircraft("VT-ABC", "A321", 220), ErrDuplicateAircraft},
+		{"no seats", NewAircraft("VT-ZRO", "A320", 0), ErrInvalidSeatCount},
+		{"negative seats", NewAircraft("VT-NEG", "A320", -5), ErrInvalidSeatCount},
+	}
+	for _, tt := range tests {
+		if err := ams.AddAircraft(tt.aircraft); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if _, err := ams.GetAircraft("VT-ZRO"); !errors.Is(err, ErrAircraftNotFound) {
+		t.Errorf("rejected aircraft was registered: %v", err)
+	}
+}
+
+func TestCreateBookingErrors(t *testing.T) {
+	ams, _ := newTestSystem()
+	flight := addTestFlight(t, ams, "AI101", testStart.Add(24*time.Hour), 10)
+	payment := func(id string, method PaymentMethod) *Payment {
+		return NewPayment(id, NewMoney(500000, "INR"), method, PaymentPending)
+	}
+	if _, err := ams.CreateBooking("AI999", newTestPassenger(t, "P1", "Asha Rao"), 1, payment("PAY-1", defaultPaymentMethod())); !errors.Is(err, ErrFlightNotFound) {
+		t.Errorf("unknown flight: err = %v, want ErrFlightNotFound", err)
+	}
+	if _, err := ams.CreateBooking("AI101", newTestPassenger(t, "P1", "Asha Rao"), 1, payment("PAY-2", defaultPaymentMethod())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ams.CreateBooking("AI101", newTestPassenger(t, "P2", "Ravi Rao"), 1, payment("PAY-3", defaultPaymentMethod())); !errors.Is(err, ErrSeatUnavailable) {
+		t.Errorf("taken seat: err = %v, want ErrSeatUnavailable", err)
+	}
+	declined := NewCreditCard(DeclinedCardNumber, "Ravi Rao")
+	if _, err := ams.CreateBooking("AI101", newTestPassenger(t, "P2", "Ravi Rao"), 2, payment("PAY-4", declined)); !errors.Is(err, ErrPaymentDeclined) {
+		t.Errorf("declined card: err = %v, want ErrPaymentDeclined", err)
+	}
+	if flight.Seats[1].IsBooked {
+		t.Error("seat 2 still booked after the payment was declined")
+	}
+	if got := len(ams.bookingManager.GetBookingsByFlight("AI101")); got != 1 {
+		t.Errorf("%d bookings on AI101, want 1", got)
+	}
+}
+
+func TestBookSeatIfVersion(t *testing.T) {
+	flight := newTestFlight(t, "AI101", testStart.Add(24*time.Hour), NewAircraft("VT-AI101", "A320", 10))
+	seen := flight.Version()
+	if err := flight.BookSeatIfVersion(3, seen); err != nil {
+		t.Fatal(err)
+	}
+	if got := flight.Version(); got <= seen {
+		t.Fatalf("version %d after booking, want more than %d", got, seen)
+	}
+	if err := flight.BookSeatIfVersion(4, seen); !errors.Is(err, ErrStaleVersion) {
+		t.Fatalf("BookSeatIfVersion with a stale version = %v, want ErrStaleVersion", err)
+	}
+	if flight.Seats[3].IsBooked {
+		t.Error("stale write booked seat 4")
+	}
+	if err := flight.BookSeatIfVersion(4, flight.Version()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchResultsAreCopies(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	flight := addTestFlight(t, ams, "AI101", departure, 10)
+	results := ams.SearchFlights("DEL", "BOM", departure)
+	if len(results) != 1 {
+		t.Fatalf("found %d flights, want 1", len(results))
+	}
+	results[0].Seats[0].IsBooked = true
+	results[0].Departure = departure.Add(time.Hour)
+	if flight.Seats[0].IsBooked || !flight.Departure.Equal(departure) {
+		t.Fatal("mutating a search result changed the system's flight")
+	}
+	if got := flight.AvailableSeatCount(); got != 10 {
+		t.Errorf("AvailableSeatCount = %d, want 10", got)
+	}
+}
+
+func TestCancelBookingFreesSeatAndRefunds(t *testing.T) {
+	ams, _ := newTestSystem()
+	addTestFlight(t, ams, "AI101", testStart.Add(7*24*time.Hour), 10)
+	booking, payment, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"), WithSeat(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.CancelBooking(booking.BookingID); err != nil {
+		t.Fatal(err)
+	}
+	if booking.Status != BookingCancelled {
+		t.Errorf("status = %s, want Cancelled", booking.Status)
+	}
+	refunds, err := ams.paymentProcessor.GetRefundsForPayment(payment.PaymentID)
+	if err != nil || len(refunds) != 1 || refunds[0].Amount != payment.Amount {
+		t.Errorf("refunds = %v, %v; want one full refund", refunds, err)
+	}
+	if err := ams.CancelBooking(booking.BookingID); !errors.Is(err, ErrBookingAlreadyCancelled) {
+		t.Errorf("second cancel = %v, want ErrBookingAlreadyCancelled", err)
+	}
+	if err := ams.CancelBooking("NOPE42"); !errors.Is(err, ErrBookingNotFound) {
+		t.Errorf("unknown booking = %v, want ErrBookingNotFound", err)
+	}
+	if _, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P2", "Ravi Rao"), WithSeat(5)); err != nil {
+		t.Fatalf("rebooking the freed seat: %v", err)
+	}
+}
+
+func TestChangeSeatConcurrent(t *testing.T) {
+	ams, _ := newTestSystem()
+	flight := addTestFlight(t, ams, "AI101", testStart.Add(24*time.Hour), 30)
+	bookings := make([]*Booking, 10)
+	for i := range bookings {
+		booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, fmt.Sprintf("P%d", i), "Asha Rao"), WithSeat(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bookings[i] = booking
+	}
+	const target = 20
+	var wg sync.WaitGroup
+	errs := make([]error, len(bookings))
+	for i, booking := range bookings {
+		wg.Add(1)
+		go func(i int, bookingID string) {
+			defer wg.Done()
+			errs[i] = ams.ChangeSeat(bookingID, target)
+		}(i, booking.BookingID)
+	}
+	wg.Wait()
+	winners := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			winners++
+		case !errors.Is(err, ErrSeatUnavailable):
+			t.Errorf("booking %d: %v", i, err)
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("%d bookings moved into seat %d, want 1", winners, target)
+	}
+	for i, booking := range bookings {
+		seat := booking.SeatNumber
+		if errs[i] == nil && seat != target {
+			t.Errorf("winner %s is in seat %d", booking.BookingID, seat)
+		}
+		if errs[i] != nil && seat != i+1 {
+			t.Errorf("loser %s moved to seat %d", booking.BookingID, seat)
+		}
+		if !flight.Seats[seat-1].IsBooked {
+			t.Errorf("seat %d of %s is not held", seat, booking.BookingID)
+		}
+	}
+	if got := flight.AvailableSeatCount(); got != 20 {
+		t.Errorf("AvailableSeatCount = %d, want 20", got)
+	}
+}
+
+func TestGetBookingsByFlightDepartingOn(t *testing.T) {
+	ams, _ := newTestSystem()
+	first := testStart.Add(24 * time.Hour)
+	addTestFlight(t, ams, "AI101", first, 10)
+	flight := newTestFlight(t, "AI101", first.AddDate(0, 0, 1), NewAircraft("VT-AI101B", "A320", 10))
+	flight.Fare = NewMoney(500000, "INR")
+	if err := ams.AddFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+	for i, date := range []time.Time{first, first, first.AddDate(0, 0, 1)} {
+		if _, _, err := ams.BookFlight("AI101", newTestPassenger(t, fmt.Sprintf("P%d", i), "Asha Rao"), OnDate(date)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bm := ams.bookingManager
+	if got := len(bm.GetBookingsByFlight("AI101")); got != 3 {
+		t.Errorf("all dates: %d bookings, want 3", got)
+	}
+	if got := len(bm.GetBookingsByFlight("AI101", DepartingOn(first))); got != 2 {
+		t.Errorf("first day: %d bookings, want 2", got)
+	}
+	if got := len(bm.GetBookingsByFlight("AI101", DepartingOn(first.AddDate(0, 0, 1)))); got != 1 {
+		t.Errorf("second day: %d bookings, want 1", got)
+	}
+	if got := len(bm.activeBookingsOn(flight)); got != 1 {
+		t.Errorf("activeBookingsOn second departure: %d bookings, want 1", got)
+	}
+}
+
+func TestRebookToFlightChargesQuotedDifference(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10)
+	addTestFlight(t, ams, "AI103", departure.Add(3*time.Hour), 10, WithTaxPercent(10))
+	cheaper := addTestFlight(t, ams, "AI105", departure.Add(6*time.Hour), 10)
+	cheaper.Fare = NewMoney(400000, "INR")
+	booking, payment, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp := ams.paymentProcessor
+
+	if err := ams.RebookToFlight(booking.BookingID, "AI103", 2, false); err != nil {
+		t.Fatal(err)
+	}
+	charges := pp.GetPaymentsForBooking(booking.BookingID)
+	if len(charges) != 2 || charges[1].Amount != NewMoney(50000, "INR") {
+		t.Fatalf("payments after rebooking onto a taxed flight = %v, want a 500.00 difference", charges)
+	}
+
+	if err := ams.RebookToFlight(booking.BookingID, "AI105", 3, false); err != nil {
+		t.Fatal(err)
+	}
+	refunds, err := pp.GetRefundsForPayment(payment.PaymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 1 || refunds[0].Amount != NewMoney(150000, "INR") {
+		t.Fatalf("refunds after rebooking onto a cheaper flight = %v, want 1,500.00", refunds)
+	}
+}
+
+func TestBookRoundTrip(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	out := addTestFlight(t, ams, "AI101", departure, 10)
+	ret := addTestFlight(t, ams, "AI102", departure.Add(3*24*time.Hour), 10)
+	early := addTestFlight(t, ams, "AI104", departure.Add(time.Hour), 10)
+	passenger := newTestPassenger(t, "P1", "Asha Rao")
+	registered, err := ams.passengers.RegisterPassenger(passenger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card := NewCreditCard("4111111111111111", "Asha Rao")
+
+	outBooking, retBooking, err := ams.BookRoundTrip("AI101", "AI102", newTestPassenger(t, "P1", "Asha Rao"), 3, 0, WithPaymentMethod(card))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outBooking.TripID == "" || outBooking.TripID != retBooking.TripID {
+		t.Fatalf("trip IDs = %q, %q, want one shared ID", outBooking.TripID, retBooking.TripID)
+	}
+	if _, err := ams.bookingManager.GetBooking(outBooking.TripID); !errors.Is(err, ErrBookingNotFound) {
+		t.Fatalf("trip ID %q also names a booking", outBooking.TripID)
+	}
+	if outBooking.Passenger != registered || retBooking.Passenger != registered {
+		t.Fatal("round trip did not book the registered passenger")
+	}
+	if outBooking.SeatNumber != 3 || retBooking.SeatNumber == 0 {
+		t.Fatalf("seats = %d, %d, want 3 and an assigned seat", outBooking.SeatNumber, retBooking.SeatNumber)
+	}
+	for _, booking := range []*Booking{outBooking, retBooking} {
+		if booking.Payment.Method != card {
+			t.Fatalf("booking %s paid with %v, want the chosen card", booking.BookingID, booking.Payment.Method)
+		}
+	}
+
+	if _, _, err := ams.BookRoundTrip("AI101", "AI104", passenger, 4, 4); !errors.Is(err, ErrInvalidReturnFlight) {
+		t.Fatalf("return before arrival: err = %v, want ErrInvalidReturnFlight", err)
+	}
+	if out.AvailableSeatCount() != 9 || early.AvailableSeatCount() != 10 || ret.AvailableSeatCount() != 9 {
+		t.Fatal("refused round trip kept its seats")
+	}
+}
+
+func TestBookingTimesFollowSystemClock(t *testing.T) {
+	ams, now := newTestSystem()
+	addTestFlight(t, ams, "AI101", testStart.Add(7*24*time.Hour), 10)
+	booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"), WithSeat(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = testStart.Add(time.Hour)
+	if err := ams.ChangeSeat(booking.BookingID, 5); err != nil {
+		t.Fatal(err)
+	}
+	if !booking.BookingTime.Equal(testStart) {
+		t.Fatalf("BookingTime = %v, want %v", booking.BookingTime, testStart)
+	}
+	history, err := ams.bookingManager.GetBookingHistory(booking.BookingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) < 2 || !history[0].Timestamp.Equal(testStart) || !history[len(history)-1].Timestamp.Equal(*now) {
+		t.Fatalf("history = %+v, want events stamped by the system clock", history)
+	}
+}
+
+func TestLapInfantCap(t *testing.T) {
+	ams, _ := newTestSystem()
+	flight := addTestFlight(t, ams, "AI101", testStart.Add(7*24*time.Hour), 10)
+	flight.MaxLapInfants = 1
+	first, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P2", "Ravi Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infant := func(id string) *Passenger {
+		passenger := newTestPassenger(t, id, "Baby "+id)
+		passenger.Type = Infant
+		return passenger
+	}
+
+	if _, _, err := ams.BookFlight("AI101", infant("I0")); !errors.Is(err, ErrInfantRequiresAdult) {
+		t.Fatalf("infant with own seat: err = %v, want ErrInfantRequiresAdult", err)
+	}
+	if err := ams.AddLapInfant(first.BookingID, newTestPassenger(t, "P3", "Meera Rao")); !errors.Is(err, ErrNotAnInfant) {
+		t.Fatalf("adult on a lap: err = %v, want ErrNotAnInfant", err)
+	}
+	if err := ams.AddLapInfant(first.BookingID, infant("I1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.AddLapInfant(second.BookingID, infant("I2")); !errors.Is(err, ErrLapInfantLimit) {
+		t.Fatalf("infant over the cap: err = %v, want ErrLapInfantLimit", err)
+	}
+	if err := ams.CancelBooking(first.BookingID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.AddLapInfant(second.BookingID, infant("I2")); err != nil {
+		t.Fatalf("cancelling the first booking did not free its lap slot: %v", err)
+	}
+}
+
+func TestAvailableSeatCountConcurrent(t *testing.T) {
+	ams, _ := newTestSystem()
+	const seats, callers = 20, 30
+	flight := addTestFlight(t, ams, "AI101", testStart.Add(7*24*time.Hour), seats)
+	passengers := make([]*Passenger, callers)
+	for i := range passengers {
+		passengers[i] = newTestPassenger(t, fmt.Sprintf("P%d", i), "Passenger")
+	}
+	var booked, soldOut int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, passenger := range passengers {
+		wg.Add(1)
+		go func(passenger *Passenger) {
+			defer wg.Done()
+			_, _, err := ams.BookFlight("AI101", passenger)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				booked++
+			case errors.Is(err, ErrNoSeatsAvailable):
+				soldOut++
+			default:
+				t.Errorf("BookFlight: %v", err)
+			}
+		}(passenger)
+	}
+	done := make(chan struct{})
+	counts := make(chan []int)
+	go func() {
+		observed := make([]int, 0)
+		for {
+			select {
+			case <-done:
+				counts <- observed
+				return
+			default:
+				observed = append(observed, flight.AvailableSeatCount())
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+	observed := <-counts
+
+	if booked != seats || soldOut != callers-seats {
+		t.Fatalf("booked %d, sold out %d, want %d and %d", booked, soldOut, seats, callers-seats)
+	}
+	if got := flight.AvailableSeatCount(); got != 0 {
+		t.Fatalf("AvailableSeatCount = %d after selling out, want 0", got)
+	}
+	for i := 1; i < len(observed); i++ {
+		if observed[i] > observed[i-1] || observed[i] < 0 {
+			t.Fatalf("seat count went from %d to %d while only booking", observed[i-1], observed[i])
+		}
+	}
+}
+
+func TestReleaseSeatTwice(t *testing.T) {
+	flight := newTestFlight(t, "AI101", testStart, NewAircraft("VT-ALA", "A320", 10))
+	if err := flight.BookSeat(4); err != nil {
+		t.Fatal(err)
+	}
+	version := flight.Version()
+	if err := flight.ReleaseSeat(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := flight.ReleaseSeat(4); !errors.Is(err, ErrSeatNotBooked) {
+		t.Fatalf("second release: err = %v, want ErrSeatNotBooked", err)
+	}
+	if got := flight.Version(); got != version+1 {
+		t.Fatalf("version = %d after one release and one refusal, want %d", got, version+1)
+	}
+	if got := flight.AvailableSeatCount(); got != 10 {
+		t.Fatalf("AvailableSeatCount = %d, want 10", got)
+	}
+	if err := flight.ReleaseSeat(11); !errors.Is(err, ErrInvalidSeatNumber) {
+		t.Fatalf("release of a missing seat: err = %v, want ErrInvalidSeatNumber", err)
+	}
+}
+
+func TestBookRoundTripChargesQuotes(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 10, WithRowSurcharge(1, NewMoney(100000, "INR")))
+	addTestFlight(t, ams, "AI102", departure.Add(3*24*time.Hour), 10, WithTaxPercent(10))
+	outBooking, retBooking, err := ams.BookRoundTrip("AI101", "AI102", newTestPassenger(t, "P1", "Asha Rao"), 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		booking *Booking
+		want    Money
+		items   int
+	}{
+		{outBooking, NewMoney(600000, "INR"), 2},
+		{retBooking, NewMoney(550000, "INR"), 2},
+	}
+	for _, tt := range tests {
+		payment := tt.booking.Payment
+		if payment.Amount != tt.want || len(payment.LineItems) != tt.items {
+			t.Errorf("%s charged %s with %d line items, want %s with %d", tt.booking.Flight.FlightNumber, payment.Amount, len(payment.LineItems), tt.want, tt.items)
+		}
+	}
+}
+
+func TestAutoAssignSeatConcurrent(t *testing.T) {
+	flight := newTestFlight(t, "AI101", testStart, NewAircraft("VT-ALA", "A320", 24))
+	const callers = 30
+	var mu sync.Mutex
+	assigned := make(map[int]int)
+	soldOut := 0
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seatNumber, err := flight.AutoAssignSeat(nil)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				assigned[seatNumber]++
+			case errors.Is(err, ErrNoSeatsAvailable):
+				soldOut++
+			default:
+				t.Errorf("AutoAssignSeat: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(assigned) != 24 || soldOut != callers-24 {
+		t.Fatalf("assigned %d distinct seats with %d sold out, want 24 and %d", len(assigned), soldOut, callers-24)
+	}
+	for seatNumber, count := range assigned {
+		if count != 1 {
+			t.Fatalf("seat %d assigned %d times", seatNumber, count)
+		}
+	}
+}
+
+func TestExitRowEligibility(t *testing.T) {
+	ams, _ := newTestSystem()
+	aircraft := NewAircraft("VT-ALA", "A320", 12)
+	aircraft.ExitRows = []int{1}
+	flight := newTestFlight(t, "AI101", testStart.Add(7*24*time.Hour), aircraft)
+	flight.Fare = NewMoney(500000, "INR")
+	if err := ams.AddFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+	child := newTestPassenger(t, "P1", "Kabir Rao")
+	child.Type = Child
+	wheelchair := newTestPassenger(t, "P2", "Ravi Rao")
+	wheelchair.SpecialAssistance = []AssistanceNeed{AssistWheelchair}
+	form := WithUnaccompaniedMinor(&UnaccompaniedMinorForm{
+		AtOrigin:      Guardian{Name: "Asha Rao", Phone: "+91 98765-43210"},
+		AtDestination: Guardian{Name: "Ravi Rao", Phone: "+91 98765-43211"},
+	})
+
+	for _, passenger := range []*Passenger{child, wheelchair} {
+		if _, _, err := ams.BookFlight("AI101", passenger, WithSeat(1), form); !errors.Is(err, ErrIneligibleForExitRow) {
+			t.Fatalf("%s in an exit row: err = %v, want ErrIneligibleForExitRow", passenger.PassengerID, err)
+		}
+		booking, _, err := ams.BookFlight("AI101", passenger, WithExitRowSeat(), form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exitRow, _ := flight.isExitRow(booking.SeatNumber); exitRow {
+			t.Fatalf("%s was auto-assigned exit-row seat %d", passenger.PassengerID, booking.SeatNumber)
+		}
+	}
+	if flight.Seats[0].IsBooked {
+		t.Fatal("refused exit-row booking kept its seat")
+	}
+	if _, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P3", "Asha Rao"), WithSeat(1)); err != nil {
+		t.Fatalf("adult in an exit row: %v", err)
+	}
+}
+
+func TestPartialRefunds(t *testing.T) {
+	pp := NewPaymentProcessor()
+	payment := NewPayment("PAY-1", NewMoney(100000, "INR"), defaultPaymentMethod(), PaymentPending)
+	if err := pp.ProcessPayment(payment); err != nil {
+		t.Fatal(err)
+	}
+	for _, amount := range []int64{30000, 30000} {
+		if _, err := pp.RefundPayment("PAY-1", NewMoney(amount, "INR")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if payment.Status != PaymentPartiallyRefunded {
+		t.Fatalf("status after two partial refunds = %s, want PartiallyRefunded", payment.Status)
+	}
+	if _, err := pp.RefundPayment("PAY-1", NewMoney(50000, "INR")); !errors.Is(err, ErrRefundExceedsPayment) {
+		t.Fatalf("over-refund: err = %v, want ErrRefundExceedsPayment", err)
+	}
+	if _, err := pp.RefundPayment("PAY-1", Money{Currency: "INR"}); !errors.Is(err, ErrInvalidRefundAmount) {
+		t.Fatalf("zero refund: err = %v, want ErrInvalidRefundAmount", err)
+	}
+	if _, err := pp.RefundPayment("PAY-1", NewMoney(40000, "INR")); err != nil {
+		t.Fatal(err)
+	}
+	refunds, err := pp.GetRefundsForPayment("PAY-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 3 || payment.Status != PaymentRefunded {
+		t.Fatalf("%d refunds, status %s, want 3 and Refunded", len(refunds), payment.Status)
+	}
+	if _, err := pp.RefundPayment("PAY-1", NewMoney(1, "INR")); !errors.Is(err, ErrPaymentNotRefundable) {
+		t.Fatalf("refund of a refunded payment: err = %v, want ErrPaymentNotRefundable", err)
+	}
+}
+
+// failingMethod refuses every capture, as a wallet with no balance would.
+type failingMethod struct {
+	captureErr error
+}
+
+func (m *failingMethod) Name() string                 { return "Wallet" }
+func (m *failingMethod) Authorize(amount Money) error { return nil }
+func (m *failingMethod) Capture() error               { return m.captureErr }
+
+func TestFailingPaymentMethod(t *testing.T) {
+	ams, _ := newTestSystem()
+	flight := addTestFlight(t, ams, "AI101", testStart.Add(7*24*time.Hour), 10)
+	errNoBalance := errors.New("wallet has no balance")
+	method := &failingMethod{captureErr: errNoBalance}
+
+	_, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"), WithPaymentMethod(method))
+	if !errors.Is(err, ErrPaymentDeclined) || !errors.Is(err, errNoBalance) {
+		t.Fatalf("err = %v, want ErrPaymentDeclined wrapping the method's error", err)
+	}
+	if got := flight.AvailableSeatCount(); got != 10 {
+		t.Fatalf("AvailableSeatCount = %d after a declined payment, want 10", got)
+	}
+	payments := ams.paymentProcessor.GetPaymentsForPassenger("P1")
+	if len(payments) != 1 || payments[0].Status != PaymentFailed {
+		t.Fatalf("payments = %v, want one Failed payment", payments)
+	}
+}
+
+func TestProcessPaymentIdempotentConcurrent(t *testing.T) {
+	pp := NewPaymentProcessor()
+	const callers = 20
+	results := make([]*Payment, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payment := NewPayment(fmt.Sprintf("PAY-%d", i), NewMoney(100000, "INR"), defaultPaymentMethod(), PaymentPending)
+			payment.PassengerID = "P1"
+			results[i], errs[i] = pp.ProcessPaymentIdempotent("checkout-1", payment)
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		if errs[i] != nil && !errors.Is(errs[i], ErrPaymentInProgress) {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		if results[i] != results[0] {
+			t.Fatalf("caller %d got payment %s, caller 0 got %s", i, results[i].PaymentID, results[0].PaymentID)
+		}
+	}
+	if payments := pp.GetPaymentsForPassenger("P1"); len(payments) != 1 || payments[0].Status != PaymentCompleted {
+		t.Fatalf("payments = %v, want exactly one Completed payment", payments)
+	}
+}
+
+// flakyGateway fails its first few charges with ErrTransientPayment and
+// then hands over to the simulated gateway.
+type flakyGateway struct {
+	*SimulatedGateway
+	failures int
+	calls    int
+	mu       sync.Mutex
+}
+
+func (g *flakyGateway) Charge(ctx context.Context, amount Money, method PaymentMethod) (string, error) {
+	g.mu.Lock()
+	g.calls++
+	fail := g.calls <= g.failures
+	g.mu.Unlock()
+	if fail {
+		return "", ErrTransientPayment
+	}
+	return g.SimulatedGateway.Charge(ctx, amount, method)
+}
+
+func TestProcessPaymentWithRetryRecovers(t *testing.T) {
+	gateway := &flakyGateway{SimulatedGateway: NewSimulatedGateway(), failures: 2}
+	pp := NewPaymentProcessorWith(gateway)
+	pp.now = func() time.Time { return testStart }
+	payment := NewPayment("PAY-1", NewMoney(100000, "INR"), defaultPaymentMethod(), PaymentPending)
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: []time.Duration{time.Millisecond}}
+	if err := pp.ProcessPaymentWithRetry(context.Background(), payment, policy); err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != PaymentCompleted || len(payment.Attempts) != 3 {
+		t.Fatalf("status %s after %d attempts, want Completed after 3", payment.Status, len(payment.Attempts))
+	}
+	for i, attempt := range payment.Attempts {
+		if !attempt.At.Equal(testStart) {
+			t.Fatalf("attempt %d at %v, want %v from the processor clock", attempt.Number, attempt.At, testStart)
+		}
+		if failed := attempt.Err != nil; failed != (i < 2) {
+			t.Fatalf("attempt %d err = %v", attempt.Number, attempt.Err)
+		}
+	}
+}
+
+func TestRefundCurrencyMismatch(t *testing.T) {
+	pp := NewPaymentProcessor()
+	payment := NewPayment("PAY-1", NewMoney(100000, "INR"), defaultPaymentMethod(), PaymentPending)
+	if err := pp.ProcessPayment(payment); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pp.RefundPayment("PAY-1", NewMoney(1000, "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("USD refund of an INR charge: err = %v, want ErrCurrencyMismatch", err)
+	}
+	refunds, err := pp.GetRefundsForPayment("PAY-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 0 || payment.Status != PaymentCompleted {
+		t.Fatalf("%d refunds, status %s after a refused refund, want none and Completed", len(refunds), payment.Status)
+	}
+}
+
+func TestPassengerLedgerOrder(t *testing.T) {
+	pp := NewPaymentProcessor()
+	now := testStart
+	pp.now = func() time.Time { return now }
+	charge := func(id string, amount int64) *Payment {
+		t.Helper()
+		payment := NewPayment(id, NewMoney(amount, "INR"), defaultPaymentMethod(), PaymentPending)
+		payment.PassengerID = "P1"
+		if err := pp.ProcessPayment(payment); err != nil {
+			t.Fatal(err)
+		}
+		return payment
+	}
+	first := charge("PAY-1", 100000)
+	now = testStart.Add(2 * time.Hour)
+	refund, err := pp.RefundPayment("PAY-1", NewMoney(40000, "INR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = testStart.Add(time.Hour)
+	second := charge("PAY-2", 50000)
+
+	payments := pp.GetPaymentsForPassenger("P1")
+	want := []*Payment{first, second, refund}
+	for i := range want {
+		if i >= len(payments) || payments[i] != want[i] {
+			t.Fatalf("ledger order = %v, want %v", payments, want)
+		}
+	}
+	if !second.CreatedAt.Equal(testStart.Add(time.Hour)) {
+		t.Fatalf("CreatedAt = %v, want the processor clock", second.CreatedAt)
+	}
+	entries, err := RunningBalance(payments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries[len(entries)-1].Balance; got != NewMoney(110000, "INR") {
+		t.Fatalf("closing balance = %s, want 1,100.00", got)
+	}
+}
+
+func TestRefundRulesFees(t *testing.T) {
+	rules, err := NewRefundRules(
+		RefundBand{MinNotice: 7 * 24 * time.Hour, Percent: 100},
+		RefundBand{MinNotice: 24 * time.Hour, Percent: 50, Fee: NewMoney(300000, "INR")},
+		RefundBand{MinNotice: 0, Percent: 0},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	departure := testStart.Add(30 * 24 * time.Hour)
+	tests := []struct {
+		name       string
+		notice     time.Duration
+		paid       Money
+		wantRefund Money
+		wantFee    Money
+	}{
+		{"full", 10 * 24 * time.Hour, NewMoney(1000000, "INR"), NewMoney(1000000, "INR"), NewMoney(0, "INR")},
+		{"fee", 2 * 24 * time.Hour, NewMoney(1000000, "INR"), NewMoney(200000, "INR"), NewMoney(800000, "INR")},
+		{"fee capped at the refund", 2 * 24 * time.Hour, NewMoney(400000, "INR"), NewMoney(0, "INR"), NewMoney(400000, "INR")},
+		{"zero", time.Hour, NewMoney(1000000, "INR"), NewMoney(0, "INR"), NewMoney(1000000, "INR")},
+		{"fee in another currency", 2 * 24 * time.Hour, NewMoney(1000000, "USD"), NewMoney(0, "USD"), NewMoney(1000000, "USD")},
+	}
+	for _, tt := range tests {
+		refund, fee := rules.Evaluate(departure, departure.Add(-tt.notice), tt.paid)
+		if refund != tt.wantRefund || fee != tt.wantFee {
+			t.Errorf("%s: refund %s fee %s, want %s and %s", tt.name, refund, fee, tt.wantRefund, tt.wantFee)
+		}
+	}
+}
+
+func TestSearchDropsSoldOutFlights(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	addTestFlight(t, ams, "AI101", departure, 2)
+	addTestFlight(t, ams, "AI103", departure.Add(time.Hour), 3)
+	search := func(opts ...SearchOption) string {
+		t.Helper()
+		criteria, err := NewSearchCriteria("DEL", "BOM", departure, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := ams.Search(criteria)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return flightNumbers(result.Flights)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := ams.BookFlight("AI101", newTestPassenger(t, fmt.Sprintf("P%d", i), "Asha Rao")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := search(WithSort(SortByDeparture, false)); got != "AI103" {
+		t.Fatalf("search with AI101 sold out = %q, want AI103", got)
+	}
+	if got := search(WithMinSeats(3)); got != "AI103" {
+		t.Fatalf("search for 3 seats = %q, want AI103", got)
+	}
+	if _, _, err := ams.BookFlight("AI103", newTestPassenger(t, "P9", "Ravi Rao")); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(WithMinSeats(3)); got != "" {
+		t.Fatalf("search for 3 seats with 2 left = %q, want none", got)
+	}
+}
+
+func TestSearchSortOrder(t *testing.T) {
+	ams, _ := newTestSystem()
+	day := testStart.Add(7 * 24 * time.Hour)
+	flights := []struct {
+		number     string
+		departs    time.Duration
+		duration   time.Duration
+		fareRupees int64
+	}{
+		{"AI101", 6 * time.Hour, 3 * time.Hour, 6000},
+		{"AI103", 8 * time.Hour, 90 * time.Minute, 4000},
+		{"AI105", 7 * time.Hour, 4 * time.Hour, 5000},
+		{"AI107", 10 * time.Hour, 2 * time.Hour, 4000},
+	}
+	for _, f := range flights {
+		departure := day.Add(f.departs)
+		flight, err := NewFlight(f.number, "DEL", "BOM", departure, departure.Add(f.duration), NewAircraft("VT-"+f.number, "A320", 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		flight.Fare = NewMoney(f.fareRupees*100, "INR")
+		if err := ams.AddFlight(flight); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		key        SortKey
+		descending bool
+		want       string
+	}{
+		{SortByDeparture, false, "AI101 AI105 AI103 AI107"},
+		{SortByDeparture, true, "AI107 AI103 AI105 AI101"},
+		{SortByArrival, false, "AI101 AI103 AI105 AI107"},
+		{SortByDuration, false, "AI103 AI107 AI101 AI105"},
+		{SortByPrice, false, "AI103 AI107 AI105 AI101"},
+		{SortByPrice, true, "AI101 AI105 AI103 AI107"},
+	}
+	for _, tt := range tests {
+		criteria, err := NewSearchCriteria("DEL", "BOM", day, WithSort(tt.key, tt.descending))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := ams.Search(criteria)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := flightNumbers(result.Flights); got != tt.want {
+			t.Errorf("sort %d descending=%t: got %q, want %q", tt.key, tt.descending, got, tt.want)
+		}
+	}
+	if _, err := NewSearchCriteria("DEL", "BOM", day, WithSort(SortByPrice+1, false)); !errors.Is(err, ErrInvalidSortKey) {
+		t.Fatalf("unknown sort key: err = %v, want ErrInvalidSortKey", err)
+	}
+}
+
+func TestAirportCodes(t *testing.T) {
+	tests := []struct {
+		code    string
+		want    string
+		wantErr bool
+	}{
+		{"DEL", "DEL", false},
+		{"del", "DEL", false},
+		{" bOm ", "BOM", false},
+		{"DELL", "", true},
+		{"DE", "", true},
+		{"D3L", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAirportCode(tt.code)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidAirportCode) {
+				t.Errorf("ParseAirportCode(%q) err = %v, want ErrInvalidAirportCode", tt.code, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseAirportCode(%q) = %q, %v, want %q", tt.code, got, err, tt.want)
+		}
+	}
+
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	flight, err := NewFlight("AI101", "del", "Bom", departure, departure.Add(2*time.Hour), NewAircraft("VT-AI101", "A320", 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.AddFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+	if got := ams.SearchFlights("Del", "bom", departure); flightNumbers(got) != "AI101" {
+		t.Fatalf("mixed-case search = %q, want AI101", flightNumbers(got))
+	}
+	if _, err := NewSearchCriteria("DELL", "BOM", departure); !errors.Is(err, ErrInvalidAirportCode) {
+		t.Fatalf("search from DELL: err = %v, want ErrInvalidAirportCode", err)
+	}
+	if _, err := NewSearchCriteria("DEL", "", departure); !errors.Is(err, ErrInvalidAirportCode) {
+		t.Fatalf("search to nowhere: err = %v, want ErrInvalidAirportCode", err)
+	}
+	bad, err := NewFlight("AI103", "DELL", "BOM", departure, departure.Add(2*time.Hour), NewAircraft("VT-AI103", "A320", 10))
+	if err == nil {
+		err = ams.AddFlight(bad)
+	}
+	if !errors.Is(err, ErrInvalidAirportCode) {
+		t.Fatalf("flight from DELL: err = %v, want ErrInvalidAirportCode", err)
+	}
+}
+
+func TestSearchByFlightNumber(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	addTestFlight(t, ams, "6E204", departure, 10)
+	addTestFlight(t, ams, "6E511", departure.Add(time.Hour), 10)
+	addTestFlight(t, ams, "AI101", departure.Add(2*time.Hour), 10)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"6e204", "6E204"},
+		{"6E-204", "6E204"},
+		{" 6E 204 ", "6E204"},
+		{"6E", "6E204 6E511"},
+		{"6e", "6E204 6E511"},
+		{"6E20", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := flightNumbers(ams.SearchByFlightNumber(tt.query)); got != tt.want {
+			t.Errorf("SearchByFlightNumber(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchSkipsDepartedFlights(t *testing.T) {
+	ams, now := newTestSystem()
+	noon := testStart.Add(36 * time.Hour)
+	addTestFlight(t, ams, "AI101", noon.Add(-time.Hour), 10)
+	addTestFlight(t, ams, "AI103", noon, 10)
+	addTestFlight(t, ams, "AI105", noon.Add(time.Hour), 10)
+	search := func(opts ...SearchOption) string {
+		t.Helper()
+		criteria, err := NewSearchCriteria("DEL", "BOM", noon, append(opts, WithSort(SortByDeparture, false))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := ams.Search(criteria)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return flightNumbers(result.Flights)
+	}
+	if got := search(); got != "AI101 AI103 AI105" {
+		t.Fatalf("search the day before = %q, want all three flights", got)
+	}
+	*now = noon
+	if got := search(); got != "AI105" {
+		t.Fatalf("search at noon = %q, want only AI105", got)
+	}
+	if got := search(WithIncludePast()); got != "AI101 AI103 AI105" {
+		t.Fatalf("search at noon including past = %q, want all three flights", got)
+	}
+	if got := ams.SearchFlights("DEL", "BOM", noon); flightNumbers(got) != "AI105" {
+		t.Fatalf("SearchFlights at noon = %q, want only AI105", flightNumbers(got))
+	}
+}
+
+func TestSearchAirportGroup(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	for i, source := range []string{"JFK", "EWR", "LGA"} {
+		number := fmt.Sprintf("AI10%d", i)
+		flightDeparture := departure.Add(time.Duration(i) * time.Hour)
+		flight, err := NewFlight(number, source, "LHR", flightDeparture, flightDeparture.Add(7*time.Hour), NewAircraft("VT-"+number, "B787", 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ams.AddFlight(flight); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ams.RegisterAirportGroup("nyc", []string{"jfk", "EWR", "JFK"}); err != nil {
+		t.Fatal(err)
+	}
+	criteria, err := NewSearchCriteria("NYC", "LHR", departure, WithSort(SortByDeparture, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ams.Search(criteria)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := flightNumbers(result.Flights); got != "AI100 AI101" {
+		t.Fatalf("search from NYC = %q, want the JFK and EWR flights", got)
+	}
+	if got := ams.SearchFlights("JFK", "LHR", departure); flightNumbers(got) != "AI100" {
+		t.Fatalf("search from JFK = %q, want AI100", flightNumbers(got))
+	}
+	if err := ams.RegisterAirportGroup("NYC", []string{"JFK", "NEWARK"}); !errors.Is(err, ErrInvalidAirportCode) {
+		t.Fatalf("group with a bad member: err = %v, want ErrInvalidAirportCode", err)
+	}
+}
+
+func TestGetFlightReturnsDeepCopy(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	flight := addTestFlight(t, ams, "AI101", departure, 10,
+		WithFare(Business, NewMoney(2000000, "INR")),
+		WithRefundRules(DefaultRefundRules()),
+		WithClassRefundRules(Business, DefaultRefundRules()),
+	)
+	flight.Aircraft.ExitRows = []int{1}
+	copied, err := ams.GetFlight("AI101", departure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.Aircraft == flight.Aircraft || copied.RefundRules == flight.RefundRules || copied.ClassRefundRules[Business] == flight.ClassRefundRules[Business] {
+		t.Fatal("copy shares the aircraft or refund rules with the flight")
+	}
+	copied.Fares[Business] = NewMoney(1, "INR")
+	copied.Fares[First] = NewMoney(1, "INR")
+	copied.ClassRefundRules[First] = nil
+	copied.Aircraft.ExitRows[0] = 9
+	copied.Aircraft.TotalSeats = 200
+
+	if flight.Fares[Business] != NewMoney(2000000, "INR") || len(flight.Fares) != 1 {
+		t.Fatalf("fares changed through a copy: %v", flight.Fares)
+	}
+	if len(flight.ClassRefundRules) != 1 {
+		t.Fatalf("class refund rules changed through a copy: %v", flight.ClassRefundRules)
+	}
+	if flight.Aircraft.ExitRows[0] != 1 || flight.Aircraft.TotalSeats != 10 {
+		t.Fatalf("aircraft changed through a copy: %+v", flight.Aircraft)
+	}
+}
+
+func TestRemoveFlightAndAircraftRefusals(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	aircraft := NewAircraft("VT-ALA", "A320", 10)
+	if err := ams.AddAircraft(aircraft); err != nil {
+		t.Fatal(err)
+	}
+	flight := newTestFlight(t, "AI101", departure, aircraft)
+	flight.Fare = NewMoney(500000, "INR")
+	if err := ams.AddFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+	booking, payment, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ams.RemoveAircraft("VT-NOPE"); !errors.Is(err, ErrAircraftNotFound) {
+		t.Fatalf("RemoveAircraft of an unknown tail: err = %v, want ErrAircraftNotFound", err)
+	}
+	if err := ams.RemoveAircraft("VT-ALA"); !errors.Is(err, ErrAircraftInUse) {
+		t.Fatalf("RemoveAircraft while scheduled: err = %v, want ErrAircraftInUse", err)
+	}
+	if err := ams.RemoveFlight("AI101", false); !errors.Is(err, ErrFlightHasBookings) {
+		t.Fatalf("RemoveFlight with a booking: err = %v, want ErrFlightHasBookings", err)
+	}
+	if got := ams.SearchFlights("DEL", "BOM", departure); flightNumbers(got) != "AI101" {
+		t.Fatalf("refused removal dropped the flight from search: %q", flightNumbers(got))
+	}
+
+	if err := ams.RemoveFlight("AI101", true); err != nil {
+		t.Fatal(err)
+	}
+	if booking.Status != BookingCancelled || payment.Status != PaymentRefunded {
+		t.Fatalf("forced removal left booking %s and payment %s, want Cancelled and Refunded", booking.Status, payment.Status)
+	}
+	if _, err := ams.GetFlight("AI101", departure); !errors.Is(err, ErrFlightNotFound) {
+		t.Fatalf("GetFlight after removal: err = %v, want ErrFlightNotFound", err)
+	}
+	if err := ams.RemoveFlight("AI101", false); !errors.Is(err, ErrFlightNotFound) {
+		t.Fatalf("second RemoveFlight: err = %v, want ErrFlightNotFound", err)
+	}
+	if err := ams.RemoveAircraft("VT-ALA"); err != nil {
+		t.Fatalf("RemoveAircraft once its flight is gone: %v", err)
+	}
+}
+
+func TestAircraftScheduleConflicts(t *testing.T) {
+	ams, _ := newTestSystem()
+	day := testStart.Add(7 * 24 * time.Hour)
+	aircraft := NewAircraft("VT-ALA", "A320", 10)
+	flight := func(number, source, destination string, departs time.Duration) *Flight {
+		t.Helper()
+		departure := day.Add(departs)
+		f, err := NewFlight(number, source, destination, departure, departure.Add(2*time.Hour), aircraft)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	if err := ams.AddFlight(flight("AI101", "DEL", "BOM", 6*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		flight  *Flight
+		wantErr error
+	}{
+		{"overlap", flight("AI103", "DEL", "BOM", 7*time.Hour), ErrAircraftConflict},
+		{"tight turn", flight("AI105", "BOM", "DEL", 8*time.Hour+20*time.Minute), ErrAircraftConflict},
+		{"wrong airport", flight("AI107", "DEL", "BLR", 9*time.Hour), ErrAircraftConflict},
+		{"clean turn", flight("AI109", "BOM", "DEL", 8*time.Hour+30*time.Minute), nil},
+		{"after a ferry gap", flight("AI111", "BLR", "DEL", 15*time.Hour), nil},
+	}
+	for _, tt := range tests {
+		if err := ams.AddFlight(tt.flight); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := ams.AddFerryFlight(flight("AI113", "DEL", "BLR", 11*time.Hour)); err != nil {
+		t.Fatalf("ferry flight: %v", err)
+	}
+}
+
+func TestDelayThenEarlyDeparture(t *testing.T) {
+	ams, _ := newTestSystem()
+	departure := testStart.Add(7 * 24 * time.Hour)
+	flight := addTestFlight(t, ams, "AI101", departure, 10)
+	addTestFlight(t, ams, "AI103", departure.Add(time.Hour), 10)
+
+	for _, delay := range []time.Duration{30 * time.Minute, 45 * time.Minute} {
+		if err := ams.DelayFlight("AI101", delay, "late inbound"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := flight.TotalDelay(); got != 75*time.Minute {
+		t.Fatalf("TotalDelay = %s, want 1h15m", got)
+	}
+	if !flight.ScheduledDeparture().Equal(departure) || !flight.EstimatedArrival().Equal(departure.Add(2*time.Hour+75*time.Minute)) {
+		t.Fatalf("schedule %v, estimated arrival %v", flight.ScheduledDeparture(), flight.EstimatedArrival())
+	}
+
+	left := departure.Add(time.Hour)
+	if err := ams.RecordDeparture("AI101", left); err != nil {
+		t.Fatal(err)
+	}
+	if err := ams.RecordDeparture("AI103", departure.Add(50*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if actual, ok := flight.ActualDeparture(); !ok || !actual.Equal(left) || !flight.departureTime().Equal(left) {
+		t.Fatalf("actual departure = %v, %t, want %v ahead of the estimate", actual, ok, left)
+	}
+	if err := ams.DelayFlight("AI101", time.Minute, ""); !errors.Is(err, ErrFlightDeparted) {
+		t.Fatalf("delay after departure: err = %v, want ErrFlightDeparted", err)
+	}
+	if err := ams.RecordArrival("AI101", left); !errors.Is(err, ErrArrivalBeforeDeparture) {
+		t.Fatalf("arrival at departure time: err = %v, want ErrArrivalBeforeDeparture", err)
+	}
+	report := ams.OnTimePerformance(departure, departure.Add(24*time.Hour))
+	if report.Flights != 2 || report.OnTime != 1 || report.AverageDelay != 30*time.Minute {
+		t.Fatalf("report = %+v, want 2 flights, 1 on time, 30m average delay", report)
+	}
+}
+
+func TestNewPassengerValidation(t *testing.T) {
+	tests := []struct {
+		name, email, phone string
+		wantPhone          string
+		wantErr            error
+	}{
+		{"Asha Rao", "asha@example.com", "+91 98765-43210", "+919876543210", nil},
+		{"  Asha Rao ", "", "(022) 2345.6789", "02223456789", nil},
+		{"Asha Rao", "", "", "", nil},
+		{"   ", "asha@example.com", "", "", ErrPassengerNameRequired},
+		{"Asha Rao", "asha.example.com", "", "", ErrInvalidEmail},
+		{"Asha Rao", "asha@example", "", "", ErrInvalidEmail},
+		{"Asha Rao", "asha@@example.com", "", "", ErrInvalidEmail},
+		{"Asha Rao", "asha rao@example.com", "", "", ErrInvalidEmail},
+		{"Asha Rao", "asha@example..com", "", "", ErrInvalidEmail},
+		{"Asha Rao", "", "12345", "", ErrInvalidPhone},
+		{"Asha Rao", "", "98765x43210", "", ErrInvalidPhone},
+		{"Asha Rao", "", "98+76543210", "", ErrInvalidPhone},
+		{"Asha Rao", "", "+1234567890123456", "", ErrInvalidPhone},
+	}
+	for _, tt := range tests {
+		passenger, err := NewPassenger("P1", tt.name, tt.email, tt.phone)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("NewPassenger(%q, %q, %q) err = %v, want %v", tt.name, tt.email, tt.phone, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (passenger.Name != "Asha Rao" || passenger.Phone != tt.wantPhone) {
+			t.Errorf("NewPassenger(%q, %q, %q) = %q %q, want trimmed name and phone %q", tt.name, tt.email, tt.phone, passenger.Name, passenger.Phone, tt.wantPhone)
+		}
+	}
+}
+
+func TestUpdateProfileReachesBookings(t *testing.T) {
+	ams, now := newTestSystem()
+	addTestFlight(t, ams, "AI101", testStart.Add(7*24*time.Hour), 10)
+	booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ams.Passengers().RegisterPassenger(newTestPassenger(t, "P2", "Ravi Rao")); err != nil {
+		t.Fatal(err)
+	}
+	*now = testStart.Add(time.Hour)
+	email := "Asha.Rao@example.org"
+	if err := ams.Passengers().UpdateProfile("P1", ProfileUpdate{Email: &email}); err != nil {
+		t.Fatal(err)
+	}
+	if booking.Passenger.Email != email {
+		t.Fatalf("booking email = %q, want %q", booking.Passenger.Email, email)
+	}
+	if found, err := ams.Passengers().FindByEmail("asha.rao@EXAMPLE.org"); err != nil || found.PassengerID != "P1" {
+		t.Fatalf("FindByEmail(new) = %v, %v, want P1", found, err)
+	}
+	if _, err := ams.Passengers().FindByEmail("p1@example.com"); !errors.Is(err, ErrPassengerNotFound) {
+		t.Fatalf("FindByEmail(old): err = %v, want ErrPassengerNotFound", err)
+	}
+	history := ams.Passengers().ProfileHistory("P1")
+	if len(history) != 1 || history[0].Field != "Email" || history[0].From != "p1@example.com" || !history[0].At.Equal(*now) {
+		t.Fatalf("history = %+v, want one email change at %v", history, *now)
+	}
+
+	taken := "p2@example.com"
+	if err := ams.Passengers().UpdateProfile("P1", ProfileUpdate{Email: &taken}); !errors.Is(err, ErrDuplicatePassenger) {
+		t.Fatalf("taking another passenger's email: err = %v, want ErrDuplicatePassenger", err)
+	}
+	id := "P9"
+	if err := ams.Passengers().UpdateProfile("P1", ProfileUpdate{PassengerID: &id}); !errors.Is(err, ErrPassengerIDImmutable) {
+		t.Fatalf("changing the ID: err = %v, want ErrPassengerIDImmutable", err)
+	}
+	if booking.Passenger.Email != email {
+		t.Fatalf("refused updates changed the booking email to %q", booking.Passenger.Email)
+	}
+}
+
+func TestFrequentFlyerTierPromotion(t *testing.T) {
+	ams, now := newTestSystem()
+	if err := ams.SetRouteDistance("DEL", "BOM", 35000); err != nil {
+		t.Fatal(err)
+	}
+	passenger := newTestPassenger(t, "P1", "Asha Rao")
+	if _, err := ams.Passengers().RegisterPassenger(passenger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ams.EnrollFrequentFlyer("P1"); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []FrequentFlyerTier{TierSilver, TierGold, TierPlatinum} {
+		number := fmt.Sprintf("AI10%d", i)
+		departure := testStart.Add(time.Duration(i+1) * 24 * time.Hour)
+		addTestFlight(t, ams, number, departure, 10)
+		booking, _, err := ams.BookFlight(number, passenger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ams.bookingManager.UpdateStatus(booking.BookingID, BookingCheckedIn); err != nil {
+			t.Fatal(err)
+		}
+		*now = departure.Add(3 * time.Hour)
+		if _, err := ams.CloseFlight(number, *now); err != nil {
+			t.Fatal(err)
+		}
+		account, err := ams.GetAccount("P1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account.Tier != want || account.Balance != 35000*(i+1) {
+			t.Fatalf("after %d flights: tier %s, balance %d, want %s and %d", i+1, account.Tier, account.Balance, want, 35000*(i+1))
+		}
+	}
+	*now = testStart.AddDate(1, 0, 2).Add(12 * time.Hour)
+	account, err := ams.GetAccount("P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Tier != TierSilver || account.Balance != 105000 {
+		t.Fatalf("a year on: tier %s, balance %d, want Silver on the last flight's miles and the balance kept", account.Tier, account.Balance)
+	}
+}
+
+func TestGroupBookingOptions(t *testing.T) {
+	ams, _ := newTestSystem()
+	aircraft := NewAircraftWithCabins("VT-GRP", "A320",
+		CabinLayout{Class: Business, Seats: 4, Columns: "AB CD"},
+		CabinLayout{Class: Economy, Seats: 12, Columns: "ABC DEF"})
+	flight := newTestFlight(t, "AI201", testStart.Add(48*time.Hour), aircraft)
+	flight.Fares = map[CabinClass]Money{Business: NewMoney(2000000, "INR"), Economy: NewMoney(500000, "INR")}
+	if err := ams.AddFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+	passengers := []*Passenger{newTestPassenger(t, "P1", "Asha Rao"), newTestPassenger(t, "P2", "Ravi Iyer")}
+	card := NewCreditCard("4111111111111111", "Asha Rao")
+	contact, err := NewBookingContact("Meera Rao", "meera@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := ams.CreateGroupBooking("AI201", passengers, nil, WithCabinClass(Business), WithPaymentMethod(card), WithContact(contact))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Payment.Method != card {
+		t.Fatalf("group paid with %v, want the chosen card", group.Payment.Method)
+	}
+	for _, booking := range group.Bookings {
+		if class, _ := flight.seatClass(booking.SeatNumber); class != Business || booking.Contact != contact {
+			t.Fatalf("booking %s: seat %d in %s, contact %v, want business and the group contact", booking.BookingID, booking.SeatNumber, class, booking.Contact)
+		}
+	}
+
+	others := []*Passenger{newTestPassenger(t, "P3", "Kiran Rao"), newTestPassenger(t, "P4", "Dev Rao")}
+	tests := []struct {
+		name    string
+		seats   []int
+		opts    []BookingOption
+		wantErr error
+	}{
+		{"seat number", nil, []BookingOption{WithSeat(5)}, ErrUnsupportedGroupOption},
+		{"seat lock", nil, []BookingOption{WithSeatLockOwner("agent-1")}, ErrUnsupportedGroupOption},
+		{"seat position", nil, []BookingOption{WithSeatPreference(SeatPreference{Position: Window})}, ErrUnsupportedGroupOption},
+		{"no payment method", nil, []BookingOption{WithPaymentMethod(nil)}, ErrPaymentMethodRequired},
+		{"seat outside cabin", []int{3, 5}, []BookingOption{WithCabinClass(Business)}, ErrCabinClassMismatch},
+	}
+	for _, tt := range tests {
+		if _, err := ams.CreateGroupBooking("AI201", others, tt.seats, tt.opts...); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	business := Business
+	trio := append(others, newTestPassenger(t, "P5", "Isha Rao"))
+	if _, err := ams.CreateGroupBooking("AI201", trio, nil, WithSeatPreference(SeatPreference{Class: &business})); !errors.Is(err, ErrNoAdjacentSeats) {
+		t.Errorf("three into two business seats: err = %v, want ErrNoAdjacentSeats", err)
+	}
+	if got := flight.AvailableSeatCount(); got != 14 {
+		t.Fatalf("AvailableSeatCount = %d after refused groups, want 14", got)
+	}
+}
+
+func TestCheckedInBookingIsLocked(t *testing.T) {
+	ams, now := newTestSystem()
+	departure := testStart.Add(24 * time.Hour)
+	flight := addTestFlight(t, ams, "AI101", departure, 10)
+	addTestFlight(t, ams, "AI103", departure.Add(3*time.Hour), 10)
+	booking, _, err := ams.BookFlight("AI101", newTestPassenger(t, "P1", "Asha Rao"), WithSeat(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = departure.Add(-2 * time.Hour)
+	first, err := ams.CheckIn(booking.BookingID, *now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ams.ChangeSeat(booking.BookingID, 5); !errors.Is(err, ErrBookingLocked) {
+		t.Fatalf("ChangeSeat after check-in: err = %v, want ErrBookingLocked", err)
+	}
+	if err := ams.RebookToFlight(booking.BookingID, "AI103", 5, false); !errors.Is(err, ErrBookingLocked) {
+		t.Fatalf("RebookToFlight after check-in: err = %v, want ErrBookingLocked", err)
+	}
+	if booking.SeatNumber != 2 || booking.NeedsBoardingPassReissue() {
+		t.Fatalf("refused changes moved the booking to seat %d or superseded its pass", booking.SeatNumber)
+	}
+
+	if err := ams.ChangeSeat(booking.BookingID, 5, AgentOverride()); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := ams.StaleBoardingPasses("AI101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0] != booking || !booking.NeedsBoardingPassReissue() {
+		t.Fatalf("stale passes = %v, want the overridden booking", stale)
+	}
+	pass, err := ams.GenerateBoardingPass(booking.BookingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass.Seat == first.Seat || pass.Seat != flight.Seats[4].Label() || booking.NeedsBoardingPassReissue() {
+		t.Fatalf("reissued pass seat %s (was %s), want %s and no longer stale", pass.Seat, first.Seat, flight.Seats[4].Label())
+	}
+}